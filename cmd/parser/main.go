@@ -7,10 +7,15 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/zihaolam/ethereum-parser/internal/api"
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/datastore/filedb"
+	"github.com/zihaolam/ethereum-parser/internal/datastore/memorydb"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
 	"github.com/zihaolam/ethereum-parser/internal/parser"
 )
 
@@ -30,18 +35,142 @@ func main() {
 	)
 	testnet := flag.Bool("testnet", false, "Use testnet endpoint")
 
-	// Default to 0 means start parsing from the latest block
-	initialBlockNumber := flag.Int("initial-block", 0, "Initial block number to start parsing from")
+	// Default to 0 means start parsing from the latest block.
+	// Ignored when the datastore already has a checkpoint to resume from.
+	initialBlockNumber := flag.Int(
+		"initial-block",
+		0,
+		"Initial block number to start parsing from, only applies to a fresh datastore",
+	)
 	scanInterval := flag.Int("scan-interval", 10, "Interval in seconds to scan for new blocks")
+	confirmations := flag.Int(
+		"confirmations",
+		0,
+		"Number of confirmations after which a transaction counts as settled",
+	)
+	headTag := flag.String(
+		"head-tag",
+		ethclient.LatestTag,
+		"Block tag to follow as chain head: 'latest', 'safe' or 'finalized'",
+	)
+	workers := flag.Int("workers", 4, "Number of blocks fetched concurrently when catching up")
+	prefetch := flag.Int("prefetch", 16, "Maximum number of blocks fetched ahead of processing")
+	rpcMaxAttempts := flag.Int(
+		"rpc-max-attempts",
+		ethclient.DefaultRetryPolicy.MaxAttempts,
+		"Maximum number of attempts per RPC call, 1 disables retries",
+	)
+	rpcRateLimit := flag.Float64(
+		"rpc-rate-limit",
+		0,
+		"Maximum number of RPC requests per second, 0 means unlimited",
+	)
+	rpcEndpoints := flag.String(
+		"rpc-endpoints",
+		"",
+		"Comma-separated RPC endpoints to fail over between, overrides the default endpoint",
+	)
+	rpcQuorum := flag.Int(
+		"rpc-quorum",
+		0,
+		"Number of endpoints that must agree on each block, 0 disables quorum mode",
+	)
+	wsEndpoint := flag.String(
+		"ws-endpoint",
+		"",
+		"WebSocket RPC endpoint to subscribe to new heads on, polling only if empty",
+	)
+	receipts := flag.Bool("receipts", false, "Fetch receipts of subscribed transactions")
+	tokenTransfers := flag.Bool("token-transfers", false, "Index ERC-20 transfers of subscribed addresses")
+	nftTransfers := flag.Bool("nft-transfers", false, "Index ERC-721 and ERC-1155 transfers of subscribed addresses")
+	internalTxs := flag.Bool(
+		"internal-txs",
+		false,
+		"Trace blocks to index internal transactions of subscribed addresses, needs the debug or trace API",
+	)
+	webhookSecret := flag.String("webhook-secret", "", "Secret to sign webhook deliveries with HMAC-SHA256")
+	webhookMaxAttempts := flag.Int(
+		"webhook-max-attempts",
+		parser.DefaultWebhookRetryPolicy.MaxAttempts,
+		"Maximum number of attempts per webhook delivery before it is dead-lettered",
+	)
+	webhookAllowPrivate := flag.Bool(
+		"webhook-allow-private",
+		false,
+		"Allow webhooks on loopback, private and link-local addresses",
+	)
+	store := flag.String("datastore", "memory", "Datastore to use: 'memory' or 'file'")
+	dataDir := flag.String("data-dir", "data", "Directory for the file datastore")
 
 	flag.Parse()
 
 	// Use default logger for now
 	logger := log.Default()
 
-	endpoint := getEndpoint(*testnet)
+	switch *headTag {
+	case ethclient.LatestTag, ethclient.SafeTag, ethclient.FinalizedTag:
+	default:
+		logger.Fatalf("Invalid head tag: %s\n", *headTag)
+	}
 
-	p := parser.New(logger, endpoint, *initialBlockNumber)
+	endpoints := []string{getEndpoint(*testnet)}
+	if *rpcEndpoints != "" {
+		endpoints = make([]string, 0)
+		for _, endpoint := range strings.Split(*rpcEndpoints, ",") {
+			if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+		if len(endpoints) == 0 {
+			logger.Fatalf("Invalid RPC endpoints: %q\n", *rpcEndpoints)
+		}
+	}
+
+	var db datastore.DataStore
+	switch *store {
+	case "memory":
+		db = memorydb.New()
+	case "file":
+		fileDB, err := filedb.Open(*dataDir)
+		if err != nil {
+			logger.Fatalf("Could not open datastore: %v\n", err)
+		}
+		defer fileDB.Close()
+		db = fileDB
+	default:
+		logger.Fatalf("Invalid datastore: %s\n", *store)
+	}
+
+	retryPolicy := ethclient.DefaultRetryPolicy
+	retryPolicy.MaxAttempts = max(*rpcMaxAttempts, 1)
+
+	webhookRetryPolicy := parser.DefaultWebhookRetryPolicy
+	webhookRetryPolicy.MaxAttempts = max(*webhookMaxAttempts, 1)
+
+	p := parser.New(
+		logger,
+		endpoints[0],
+		*initialBlockNumber,
+		parser.WithConfirmations(*confirmations),
+		parser.WithHeadTag(*headTag),
+		parser.WithDataStore(db),
+		parser.WithWorkers(*workers),
+		parser.WithPrefetch(*prefetch),
+		parser.WithWebSocketEndpoint(*wsEndpoint),
+		parser.WithReceipts(*receipts),
+		parser.WithTokenTransfers(*tokenTransfers),
+		parser.WithNFTTransfers(*nftTransfers),
+		parser.WithInternalTransactions(*internalTxs),
+		parser.WithWebhookSecret(*webhookSecret),
+		parser.WithWebhookRetryPolicy(webhookRetryPolicy),
+		parser.WithPrivateWebhooks(*webhookAllowPrivate),
+		parser.WithClientOptions(
+			ethclient.WithRetryPolicy(retryPolicy),
+			ethclient.WithRateLimit(*rpcRateLimit, max(int(*rpcRateLimit), 1)),
+			ethclient.WithEndpoints(endpoints[1:]...),
+			ethclient.WithQuorum(*rpcQuorum),
+		),
+	)
 
 	// Initialize the API with the parser
 	api := api.New(p, logger)
@@ -62,7 +191,7 @@ func main() {
 	go func() {
 		// Start the scanner
 		interval := time.Duration(*scanInterval) * time.Second
-		logger.Println("Starting scanner on " + endpoint)
+		logger.Println("Starting scanner on " + strings.Join(endpoints, ", "))
 		p.StartScan(ctx, interval)
 	}()
 
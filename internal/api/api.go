@@ -3,10 +3,16 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
 	"github.com/zihaolam/ethereum-parser/internal/logging"
 	"github.com/zihaolam/ethereum-parser/internal/parser"
 )
@@ -26,9 +32,21 @@ func New(parser *parser.Parser, logger logging.Logger) *Api {
 func (api *Api) Start(ctx context.Context, addr string) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/subscribe", api.loggingMiddleware(api.handleSubscribe()))
+	mux.HandleFunc("/subscriptions", api.loggingMiddleware(api.handleListSubscriptions()))
+	mux.HandleFunc("/subscriptions/", api.loggingMiddleware(api.handleSubscription()))
+	mux.HandleFunc("/backfills", api.loggingMiddleware(api.handleListBackfills()))
+	mux.HandleFunc("/backfills/", api.loggingMiddleware(api.handleGetBackfill()))
+	mux.HandleFunc("/webhooks/dead_letters", api.loggingMiddleware(api.handleListDeadLetters()))
+	mux.HandleFunc("/webhooks/dead_letters/", api.loggingMiddleware(api.handleDeadLetter()))
 	mux.HandleFunc("/transactions", api.loggingMiddleware(api.handleGetTransactions()))
+	mux.HandleFunc("/token_transfers", api.loggingMiddleware(api.handleGetTokenTransfers()))
+	mux.HandleFunc("/nft_transfers", api.loggingMiddleware(api.handleGetNFTTransfers()))
+	mux.HandleFunc("/nft_holdings", api.loggingMiddleware(api.handleGetNFTHoldings()))
+	mux.HandleFunc("/internal_transactions", api.loggingMiddleware(api.handleGetInternalTransactions()))
+	mux.HandleFunc("/withdrawals", api.loggingMiddleware(api.handleGetWithdrawals()))
 	mux.HandleFunc("/current_block", api.loggingMiddleware(api.handleGetCurrentBlock()))
 	mux.HandleFunc("/scan", api.loggingMiddleware(api.handleScanBlock()))
+	mux.HandleFunc("/stats", api.loggingMiddleware(api.handleGetStats()))
 	mux.HandleFunc("/", api.loggingMiddleware(api.handleWildcard()))
 
 	server := &http.Server{
@@ -59,41 +77,405 @@ func (api *Api) handleWildcard() http.HandlerFunc {
 	}
 }
 
+// Validates an address parameter and returns its canonical lowercase form.
+// Responds with 400 Bad Request if the address is missing or invalid.
+func addressParam(w http.ResponseWriter, address string) (string, bool) {
+	if address == "" {
+		http.Error(w, "Address required", http.StatusBadRequest)
+		return "", false
+	}
+	normalized, err := ethclient.NormalizeAddress(address)
+	if err != nil {
+		http.Error(w, "Invalid address "+address, http.StatusBadRequest)
+		return "", false
+	}
+	return normalized, true
+}
+
 func (api *Api) handleSubscribe() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		address := r.URL.Query().Get("address")
-		if address == "" {
-			http.Error(w, "Address required", http.StatusBadRequest)
+		address, ok := addressParam(w, r.URL.Query().Get("address"))
+		if !ok {
 			return
 		}
 
-		if subscribed := api.parser.Subscribe(address); !subscribed {
+		opts := []parser.SubscribeOption{parser.WithLabels(r.URL.Query()["label"]...)}
+		if fromBlockQuery := r.URL.Query().Get("fromBlock"); fromBlockQuery != "" {
+			fromBlock, err := strconv.Atoi(fromBlockQuery)
+			if err != nil || fromBlock < 0 {
+				http.Error(w, "Failed to parse from block", http.StatusBadRequest)
+				return
+			}
+			// the scanner indexes the blocks from its next one anyway
+			if last := api.parser.GetCurrentBlock(); last > 0 && fromBlock > last+1 {
+				http.Error(w, "From block is ahead of the scanner", http.StatusBadRequest)
+				return
+			}
+			opts = append(opts, parser.WithFromBlock(fromBlock))
+		}
+		if webhook := r.URL.Query().Get("webhook"); webhook != "" {
+			if err := api.parser.ValidateWebhook(webhook); err != nil {
+				http.Error(w, "Webhook must be an http or https URL on a public network", http.StatusBadRequest)
+				return
+			}
+			opts = append(opts, parser.WithWebhook(webhook))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if subscribed := api.parser.Subscribe(address, opts...); !subscribed {
 			json.NewEncoder(w).
-				Encode(map[string]interface{}{"data": false, "message": "Already subscribed" + address})
+				Encode(map[string]interface{}{"data": false, "message": "Already subscribed to address " + ethclient.ChecksumAddress(address)})
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).
-			Encode(map[string]interface{}{"data": true, "message": "Subscribed to address " + address})
+			Encode(map[string]interface{}{"data": true, "message": "Subscribed to address " + ethclient.ChecksumAddress(address)})
 		return
 	}
 }
 
+func (api *Api) handleListSubscriptions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		subscriptions, err := api.parser.ListSubscriptions()
+		if err != nil {
+			http.Error(w, "Failed to list subscriptions", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(subscriptions)
+	}
+}
+
+// Serves GET and DELETE /subscriptions/{address}
+func (api *Api) handleSubscription() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address := strings.TrimPrefix(r.URL.Path, "/subscriptions/")
+		if address == "" || strings.Contains(address, "/") {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		address, ok := addressParam(w, address)
+		if !ok {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			subscription, ok := api.parser.GetSubscription(address)
+			if !ok {
+				http.Error(w, "Not subscribed to address "+ethclient.ChecksumAddress(address), http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(subscription)
+		case http.MethodDelete:
+			if !api.parser.Unsubscribe(address) {
+				http.Error(w, "Not subscribed to address "+ethclient.ChecksumAddress(address), http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).
+				Encode(map[string]interface{}{"data": true, "message": "Unsubscribed from address " + ethclient.ChecksumAddress(address)})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (api *Api) handleListBackfills() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		jobs, err := api.parser.ListBackfillJobs()
+		if err != nil {
+			http.Error(w, "Failed to list backfills", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jobs)
+	}
+}
+
+// Serves GET /backfills/{address}
+func (api *Api) handleGetBackfill() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address := strings.TrimPrefix(r.URL.Path, "/backfills/")
+		if address == "" || strings.Contains(address, "/") {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		address, ok := addressParam(w, address)
+		if !ok {
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		job, ok := api.parser.GetBackfillJob(address)
+		if !ok {
+			http.Error(w, "No backfill for address "+ethclient.ChecksumAddress(address), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+func (api *Api) handleListDeadLetters() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		deliveries, err := api.parser.ListDeadLetters()
+		if err != nil {
+			http.Error(w, "Failed to list dead letters", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
+
+// Serves GET and DELETE /webhooks/dead_letters/{id} and POST /webhooks/dead_letters/{id}/replay
+func (api *Api) handleDeadLetter() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/webhooks/dead_letters/")
+		id, replay := strings.CutSuffix(id, "/replay")
+		if id == "" || strings.Contains(id, "/") {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case replay && r.Method == http.MethodPost:
+			if !api.parser.ReplayDeadLetter(id) {
+				http.Error(w, "No dead letter "+id, http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).
+				Encode(map[string]interface{}{"data": true, "message": "Replaying dead letter " + id})
+		case !replay && r.Method == http.MethodGet:
+			delivery, ok := api.parser.GetDeadLetter(id)
+			if !ok {
+				http.Error(w, "No dead letter "+id, http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(delivery)
+		case !replay && r.Method == http.MethodDelete:
+			if !api.parser.DeleteDeadLetter(id) {
+				http.Error(w, "No dead letter "+id, http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).
+				Encode(map[string]interface{}{"data": true, "message": "Deleted dead letter " + id})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
 func (api *Api) handleGetTransactions() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		address := r.URL.Query().Get("address")
-		if address == "" {
-			http.Error(w, "Address required", http.StatusBadRequest)
+		address, ok := addressParam(w, r.URL.Query().Get("address"))
+		if !ok {
 			return
 		}
 
-		transactions := api.parser.GetTransactions(address)
+		params := r.URL.Query()
+		paged := false
+		for _, param := range []string{
+			"cursor", "limit", "fromBlock", "toBlock", "fromTime", "toTime", "direction", "minValue", "maxValue",
+			"counterparty", "order",
+		} {
+			paged = paged || params.Has(param)
+		}
+		if !paged {
+			// the full history, as before pagination was added
+			transactions := api.parser.GetTransactions(address)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(transactions)
+			return
+		}
+
+		query, err := parseTransactionQuery(params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page, err := api.parser.GetTransactionsPage(address, query)
+		if errors.Is(err, parser.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to get transactions", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+// Parses the pagination and filter parameters of GET /transactions
+func parseTransactionQuery(params url.Values) (parser.TransactionQuery, error) {
+	query := parser.TransactionQuery{Cursor: params.Get("cursor")}
+
+	for param, dst := range map[string]*int{
+		"limit":     &query.Limit,
+		"fromBlock": &query.FromBlock,
+		"toBlock":   &query.ToBlock,
+	} {
+		if v := params.Get(param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return query, fmt.Errorf("Failed to parse %s", param)
+			}
+			*dst = n
+		}
+	}
+	if query.Limit > parser.MaxPageSize {
+		return query, fmt.Errorf("Limit must be at most %d", parser.MaxPageSize)
+	}
+
+	for param, dst := range map[string]**big.Int{
+		"minValue": &query.MinValue,
+		"maxValue": &query.MaxValue,
+	} {
+		if v := params.Get(param); v != "" {
+			// decimal wei or a 0x-prefixed hex quantity
+			n, ok := new(big.Int).SetString(v, 0)
+			if !ok || n.Sign() < 0 {
+				return query, fmt.Errorf("Failed to parse %s", param)
+			}
+			*dst = n
+		}
+	}
+
+	for param, dst := range map[string]*time.Time{
+		"fromTime": &query.FromTime,
+		"toTime":   &query.ToTime,
+	} {
+		if v := params.Get(param); v != "" {
+			t, err := parseTime(v)
+			if err != nil {
+				return query, fmt.Errorf("Failed to parse %s", param)
+			}
+			*dst = t
+		}
+	}
+
+	switch direction := parser.Direction(params.Get("direction")); direction {
+	case "", parser.DirectionIn, parser.DirectionOut:
+		query.Direction = direction
+	default:
+		return query, errors.New("Direction must be 'in' or 'out'")
+	}
+
+	switch order := parser.SortOrder(params.Get("order")); order {
+	case "", parser.OrderAsc, parser.OrderDesc:
+		query.Order = order
+	default:
+		return query, errors.New("Order must be 'asc' or 'desc'")
+	}
+
+	if counterparty := params.Get("counterparty"); counterparty != "" {
+		normalized, err := ethclient.NormalizeAddress(counterparty)
+		if err != nil {
+			return query, errors.New("Invalid counterparty " + counterparty)
+		}
+		query.Counterparty = normalized
+	}
+	return query, nil
+}
+
+// Parses a time given as unix seconds or in RFC 3339
+func parseTime(v string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(v, 10, 64); err == nil && seconds >= 0 {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func (api *Api) handleGetTokenTransfers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address, ok := addressParam(w, r.URL.Query().Get("address"))
+		if !ok {
+			return
+		}
+
+		transfers := api.parser.GetTokenTransfers(address)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(transfers)
+	}
+}
+
+func (api *Api) handleGetNFTTransfers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address, ok := addressParam(w, r.URL.Query().Get("address"))
+		if !ok {
+			return
+		}
+
+		transfers := api.parser.GetNFTTransfers(address)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(transfers)
+	}
+}
+
+func (api *Api) handleGetNFTHoldings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address, ok := addressParam(w, r.URL.Query().Get("address"))
+		if !ok {
+			return
+		}
+
+		holdings := api.parser.GetNFTHoldings(address)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(holdings)
+	}
+}
+
+func (api *Api) handleGetInternalTransactions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address, ok := addressParam(w, r.URL.Query().Get("address"))
+		if !ok {
+			return
+		}
+
+		transactions := api.parser.GetInternalTransactions(address)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(transactions)
 	}
 }
 
+func (api *Api) handleGetWithdrawals() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address, ok := addressParam(w, r.URL.Query().Get("address"))
+		if !ok {
+			return
+		}
+
+		withdrawals := api.parser.GetWithdrawals(address)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(withdrawals)
+	}
+}
+
 func (api *Api) handleGetCurrentBlock() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		block := api.parser.GetCurrentBlock()
@@ -116,6 +498,13 @@ func (api *Api) handleScanBlock() http.HandlerFunc {
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(block)
+		json.NewEncoder(w).Encode(parser.Block{Block: block})
+	}
+}
+
+func (api *Api) handleGetStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(api.parser.Stats())
 	}
 }
@@ -0,0 +1,116 @@
+package datastore
+
+// Batch is a view of the datastore handed to DataStore.Batch.
+// Reads see the batch's own writes, which are only applied to the datastore once the batch succeeds.
+type Batch interface {
+	Has(key string) bool
+	Get(key string) ([][]byte, error)
+	Put(key string, value [][]byte) error
+	Append(key string, values [][]byte) error
+	Update(key string, updater func([][]byte) ([][]byte, error)) error
+	Delete(key string) error
+}
+
+// Write is a single buffered write of a batch.
+type Write struct {
+	Key     string
+	Value   [][]byte
+	Deleted bool
+	// Value holds values appended to the stored ones rather than replacing them
+	Appended bool
+}
+
+// Overlay is a Batch that buffers writes on top of a datastore's key value map.
+// Implementations use it to provide Batch and then apply Writes atomically.
+type Overlay struct {
+	base   map[string][][]byte
+	writes map[string]Write
+	// keys in the order they were first written, so writes are applied deterministically
+	order []string
+}
+
+func NewOverlay(base map[string][][]byte) *Overlay {
+	return &Overlay{
+		base:   base,
+		writes: make(map[string]Write),
+	}
+}
+
+func (o *Overlay) Has(key string) bool {
+	_, err := o.Get(key)
+	return err == nil
+}
+
+func (o *Overlay) Get(key string) ([][]byte, error) {
+	w, written := o.writes[key]
+	switch {
+	case written && w.Deleted:
+		return nil, KeyDoesNotExist
+	case written && w.Appended:
+		v := o.base[key]
+		return append(v[:len(v):len(v)], w.Value...), nil
+	case written:
+		return w.Value, nil
+	}
+
+	v, ok := o.base[key]
+	if !ok {
+		return nil, KeyDoesNotExist
+	}
+	return v, nil
+}
+
+func (o *Overlay) Put(key string, value [][]byte) error {
+	o.set(Write{Key: key, Value: value})
+	return nil
+}
+
+func (o *Overlay) Append(key string, values [][]byte) error {
+	w, ok := o.writes[key]
+	switch {
+	case !ok:
+		o.set(Write{Key: key, Value: values, Appended: true})
+	case w.Deleted:
+		// appending to a key deleted in this batch recreates it
+		o.set(Write{Key: key, Value: values})
+	default:
+		// copy so that appending never writes into a caller's slice
+		w.Value = append(w.Value[:len(w.Value):len(w.Value)], values...)
+		o.set(w)
+	}
+	return nil
+}
+
+func (o *Overlay) Update(key string, updater func([][]byte) ([][]byte, error)) error {
+	v, err := o.Get(key)
+	if err != nil {
+		return err
+	}
+	newValue, err := updater(v)
+	if err != nil {
+		return err
+	}
+	o.set(Write{Key: key, Value: newValue})
+	return nil
+}
+
+func (o *Overlay) Delete(key string) error {
+	o.set(Write{Key: key, Deleted: true})
+	return nil
+}
+
+func (o *Overlay) set(w Write) {
+	if _, ok := o.writes[w.Key]; !ok {
+		o.order = append(o.order, w.Key)
+	}
+	o.writes[w.Key] = w
+}
+
+// Returns the buffered writes, one per key, in the order the keys were first written.
+func (o *Overlay) Writes() []Write {
+	writes := make([]Write, 0, len(o.order))
+	for _, key := range o.order {
+		writes = append(writes, o.writes[key])
+	}
+	return writes
+}
@@ -1,5 +1,9 @@
 package datastore
 
+import "errors"
+
+var KeyDoesNotExist = errors.New("key does not exist")
+
 type DataStore interface {
 	// Returns all keys in the datastore.
 	List() ([]string, error)
@@ -11,13 +15,37 @@ type DataStore interface {
 	// Returns error if key does not exist
 	Get(key string) ([][]byte, error)
 
+	// Returns the number of values stored under the given key.
+	// Returns error if key does not exist
+	Len(key string) (int, error)
+
+	// Retrieves the values at positions start up to, but not including, end of the given key,
+	// so that a page of a long list can be read without the rest. Positions are clamped to the stored values.
+	// Returns error if key does not exist
+	GetRange(key string, start, end int) ([][]byte, error)
+
 	// Stores a given value into the datastore.
 	// Values are stored as slices of slices of bytes so that multiple values can be stored under the same key.
 	Put(key string, value [][]byte) error
 
+	// Appends values to those stored under the given key, creating the key if it does not exist.
+	// Only the new values are written, so growing a long list stays cheap.
+	Append(key string, values [][]byte) error
+
 	// Updates the value of the given key using the provided update function.
 	Update(key string, updater func([][]byte) ([][]byte, error)) error
 
 	// Deletes key value pair from the datastore. Safely returns even if key does not exist.
 	Delete(key string) error
+
+	// Runs fn with exclusive access to the datastore.
+	// Writes made through the batch are applied atomically if fn returns nil and discarded otherwise.
+	Batch(fn func(batch Batch) error) error
+}
+
+// Returns values[start:end] with start and end clamped to the bounds of values
+func Slice(values [][]byte, start, end int) [][]byte {
+	start = min(max(start, 0), len(values))
+	end = min(max(end, start), len(values))
+	return values[start:end:end]
 }
@@ -0,0 +1,539 @@
+// Package filedb implements a DataStore persisted to an append-only log file.
+//
+// Every write, or every batch of writes, is appended to the log as a single checksummed
+// record and fsynced before it is applied in memory. On open the log is replayed; a torn or corrupted record at the tail,
+// left behind by a crash mid-write, is truncated away, while a corrupted record followed by
+// further records fails the open rather than dropping them. The directory is locked while
+// it is open, so only one process writes the log. Once the log grows well beyond the
+// size of the live data it is compacted by rewriting the live entries to a new file and
+// atomically renaming it over the old one.
+package filedb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+)
+
+const (
+	logFileName     = "data.log"
+	compactFileName = "data.log.compact"
+	// locked while the datastore is open; the log itself is replaced by compaction
+	lockFileName = "data.lock"
+
+	// record header: 4 byte payload length followed by 4 byte CRC-32 of the payload
+	headerSize = 8
+
+	// the log is compacted once it is larger than this and twice the size of the live data
+	compactMinSize = 4 << 20
+)
+
+const (
+	opPut byte = iota + 1
+	opDelete
+	opAppend
+)
+
+var KeyDoesNotExist = datastore.KeyDoesNotExist
+
+// Returned by Open if another process has the datastore open
+var ErrLocked = errors.New("datastore is locked by another process")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type FileDB struct {
+	data  map[string][][]byte
+	mutex sync.RWMutex
+
+	dir  string
+	file *os.File
+	lock *os.File
+	// size of the log file and of the records needed to rebuild the live data
+	logSize  int64
+	liveSize int64
+	// set if a failed write could not be dropped from the log again, which would then
+	// apply it on replay. Every later write fails with it.
+	broken error
+}
+
+// Opens the datastore in the given directory, creating it if it does not exist,
+// and replays the log into memory.
+func Open(dir string) (*FileDB, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating data directory: %v", err)
+	}
+
+	lock, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file: %v", err)
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("error locking %s: %w", dir, err)
+	}
+
+	// a leftover compaction file means compaction was interrupted before the rename
+	os.Remove(filepath.Join(dir, compactFileName))
+
+	file, err := os.OpenFile(filepath.Join(dir, logFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("error opening log: %v", err)
+	}
+
+	db := &FileDB{
+		data:  make(map[string][][]byte),
+		dir:   dir,
+		file:  file,
+		lock:  lock,
+		mutex: sync.RWMutex{},
+	}
+
+	if err := db.replay(); err != nil {
+		file.Close()
+		lock.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Reads every record in the log. A bad record that runs to the end of the log is the
+// remainder of an interrupted write and is truncated away; one followed by more data
+// means the log is corrupted and fails the replay.
+func (db *FileDB) replay() error {
+	if _, err := db.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	info, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(db.file)
+	var offset int64
+	for {
+		writes, n, err := readRecord(r, info.Size()-offset)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || (errors.Is(err, errCorruptRecord) && offset+n == info.Size()) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading log record at offset %d: %w", offset, err)
+		}
+
+		for _, w := range writes {
+			db.apply(w)
+		}
+		offset += n
+	}
+
+	// drop a torn tail so new records are appended after the last good one
+	if err := db.file.Truncate(offset); err != nil {
+		return fmt.Errorf("error truncating log: %v", err)
+	}
+	if _, err := db.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if err := db.file.Sync(); err != nil {
+		return err
+	}
+
+	db.logSize = offset
+	return nil
+}
+
+func (db *FileDB) Has(key string) bool {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	_, ok := db.data[key]
+	return ok
+}
+
+func (db *FileDB) Get(key string) ([][]byte, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	v, ok := db.data[key]
+	if !ok {
+		return nil, KeyDoesNotExist
+	}
+	return v[:len(v):len(v)], nil
+}
+
+func (db *FileDB) Len(key string) (int, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	v, ok := db.data[key]
+	if !ok {
+		return 0, KeyDoesNotExist
+	}
+	return len(v), nil
+}
+
+func (db *FileDB) GetRange(key string, start, end int) ([][]byte, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	v, ok := db.data[key]
+	if !ok {
+		return nil, KeyDoesNotExist
+	}
+	return datastore.Slice(v, start, end), nil
+}
+
+func (db *FileDB) Put(key string, value [][]byte) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.write([]datastore.Write{{Key: key, Value: value}})
+}
+
+func (db *FileDB) Append(key string, values [][]byte) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.write([]datastore.Write{{Key: key, Value: values, Appended: true}})
+}
+
+func (db *FileDB) Update(key string, updater func([][]byte) ([][]byte, error)) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	v, ok := db.data[key]
+	if !ok {
+		return KeyDoesNotExist
+	}
+
+	newValue, err := updater(v)
+	if err != nil {
+		return err
+	}
+	return db.write([]datastore.Write{{Key: key, Value: newValue}})
+}
+
+func (db *FileDB) List() ([]string, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	keys := make([]string, 0, len(db.data))
+	for k := range db.data {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (db *FileDB) Delete(key string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if _, ok := db.data[key]; !ok {
+		return nil
+	}
+	return db.write([]datastore.Write{{Key: key, Deleted: true}})
+}
+
+func (db *FileDB) Batch(fn func(batch datastore.Batch) error) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	overlay := datastore.NewOverlay(db.data)
+	if err := fn(overlay); err != nil {
+		return err
+	}
+
+	writes := overlay.Writes()
+	if len(writes) == 0 {
+		return nil
+	}
+	return db.write(writes)
+}
+
+// Closes the underlying log file and releases the lock. The datastore must not be used afterwards.
+func (db *FileDB) Close() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	err := db.file.Close()
+	if lockErr := db.lock.Close(); err == nil {
+		err = lockErr
+	}
+	return err
+}
+
+// Appends the writes to the log as one record, syncs it and applies them in memory.
+// Must be called with the write lock held.
+func (db *FileDB) write(writes []datastore.Write) error {
+	if db.broken != nil {
+		return fmt.Errorf("log unusable after a failed write: %v", db.broken)
+	}
+
+	record := encodeRecord(writes)
+	if _, err := db.file.Write(record); err != nil {
+		db.rollback()
+		return fmt.Errorf("error writing log: %v", err)
+	}
+	if err := db.file.Sync(); err != nil {
+		db.rollback()
+		return fmt.Errorf("error syncing log: %v", err)
+	}
+
+	db.logSize += int64(len(record))
+	for _, w := range writes {
+		db.apply(w)
+	}
+
+	if db.logSize > compactMinSize && db.logSize > 2*db.liveSize {
+		// the record is already durable, a failed compaction leaves the old log
+		// intact and is retried on the next write
+		db.compact()
+	}
+	return nil
+}
+
+// Drops a record that failed to be written or synced, in part or in full, so that the log
+// stays replayable and a replay does not apply writes that were reported as failed.
+// Marks the datastore broken if the log cannot be truncated.
+func (db *FileDB) rollback() {
+	err := db.file.Truncate(db.logSize)
+	if err == nil {
+		_, err = db.file.Seek(db.logSize, io.SeekStart)
+	}
+	if err == nil {
+		err = db.file.Sync()
+	}
+	if err != nil {
+		db.broken = err
+	}
+}
+
+// Applies a write to the in-memory data and keeps track of the live data size.
+func (db *FileDB) apply(w datastore.Write) {
+	old, ok := db.data[w.Key]
+	if ok && w.Appended {
+		// account for the appended values only, so appends stay O(1) in the stored values
+		db.liveSize += int64(uvarintLen(len(old)+len(w.Value)) - uvarintLen(len(old)))
+		for _, v := range w.Value {
+			db.liveSize += int64(uvarintLen(len(v)) + len(v))
+		}
+		// readers hold slices no longer than the values they got, so growing in place is safe
+		db.data[w.Key] = append(old, w.Value...)
+		return
+	}
+	if ok {
+		db.liveSize -= recordSize(w.Key, old)
+	}
+
+	if w.Deleted {
+		delete(db.data, w.Key)
+		return
+	}
+	db.data[w.Key] = w.Value
+	db.liveSize += recordSize(w.Key, w.Value)
+}
+
+// Rewrites the live data to a new log and atomically replaces the old one.
+// Must be called with the write lock held.
+func (db *FileDB) compact() error {
+	compactPath := filepath.Join(db.dir, compactFileName)
+	tmp, err := os.Create(compactPath)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	var size int64
+	for key, value := range db.data {
+		n, err := w.Write(encodeRecord([]datastore.Write{{Key: key, Value: value}}))
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		size += int64(n)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	logPath := filepath.Join(db.dir, logFileName)
+	if err := os.Rename(compactPath, logPath); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	db.file.Close()
+	db.file = tmp
+	db.logSize = size
+	return syncDir(db.dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Record payload layout:
+//
+//	uvarint write count | (op | uvarint key length | key | uvarint value count | (uvarint length | bytes)...)...
+//
+// An append holds only the appended values.
+func encodeRecord(writes []datastore.Write) []byte {
+	size := uvarintLen(len(writes))
+	for _, w := range writes {
+		size += entrySize(w.Key, w.Value)
+	}
+
+	record := make([]byte, headerSize, headerSize+size)
+	record = binary.AppendUvarint(record, uint64(len(writes)))
+	for _, w := range writes {
+		if w.Deleted {
+			record = append(record, opDelete)
+			record = appendBytes(record, []byte(w.Key))
+			record = binary.AppendUvarint(record, 0)
+			continue
+		}
+		op := opPut
+		if w.Appended {
+			op = opAppend
+		}
+		record = append(record, op)
+		record = appendBytes(record, []byte(w.Key))
+		record = binary.AppendUvarint(record, uint64(len(w.Value)))
+		for _, v := range w.Value {
+			record = appendBytes(record, v)
+		}
+	}
+
+	payload := record[headerSize:]
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	return record
+}
+
+func appendBytes(b []byte, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// Encoded size of a record holding a single put, used for compaction accounting.
+func recordSize(key string, value [][]byte) int64 {
+	return int64(headerSize + uvarintLen(1) + entrySize(key, value))
+}
+
+// Encoded size of a single write within a record.
+func entrySize(key string, value [][]byte) int {
+	size := 1 + uvarintLen(len(key)) + len(key) + uvarintLen(len(value))
+	for _, v := range value {
+		size += uvarintLen(len(v)) + len(v)
+	}
+	return size
+}
+
+func uvarintLen(n int) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], uint64(n))
+}
+
+var errCorruptRecord = errors.New("corrupt record")
+
+// Reads one record, returning its writes and the number of bytes it occupies in the log.
+// Returns io.EOF at the end of the log, io.ErrUnexpectedEOF for a record cut short by it and
+// errCorruptRecord, together with the record's size, for one that fails to decode.
+func readRecord(r *bufio.Reader, remaining int64) ([]datastore.Write, int64, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+	if int64(length) > remaining-headerSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	size := int64(headerSize + len(payload))
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return nil, size, errCorruptRecord
+	}
+
+	writes, err := decodePayload(payload)
+	if err != nil {
+		return nil, size, err
+	}
+	return writes, size, nil
+}
+
+func decodePayload(payload []byte) ([]datastore.Write, error) {
+	count, n := binary.Uvarint(payload)
+	if n <= 0 || count > uint64(len(payload)) {
+		return nil, errCorruptRecord
+	}
+	rest := payload[n:]
+
+	writes := make([]datastore.Write, 0, count)
+	for i := uint64(0); i < count; i++ {
+		if len(rest) == 0 {
+			return nil, errCorruptRecord
+		}
+		op := rest[0]
+		if op != opPut && op != opDelete && op != opAppend {
+			return nil, errCorruptRecord
+		}
+
+		key, remaining, err := readBytes(rest[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		valueCount, n := binary.Uvarint(remaining)
+		if n <= 0 || valueCount > uint64(len(remaining)) {
+			return nil, errCorruptRecord
+		}
+		rest = remaining[n:]
+
+		value := make([][]byte, 0, valueCount)
+		for j := uint64(0); j < valueCount; j++ {
+			var v []byte
+			v, rest, err = readBytes(rest)
+			if err != nil {
+				return nil, err
+			}
+			value = append(value, v)
+		}
+
+		writes = append(writes, datastore.Write{
+			Key:      string(key),
+			Value:    value,
+			Deleted:  op == opDelete,
+			Appended: op == opAppend,
+		})
+	}
+	return writes, nil
+}
+
+// Reads a uvarint length-prefixed byte slice and returns it with the remaining input.
+func readBytes(b []byte) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(b)
+	if n <= 0 || length > uint64(len(b)-n) {
+		return nil, nil, errCorruptRecord
+	}
+	b = b[n:]
+	return b[:length:length], b[length:], nil
+}
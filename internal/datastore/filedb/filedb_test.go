@@ -0,0 +1,487 @@
+package filedb_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/datastore/filedb"
+)
+
+func TestMain(t *testing.T) {
+	var db *filedb.FileDB
+	t.Run("Open", func(t *testing.T) {
+		var err error
+		db, err = filedb.Open(t.TempDir())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if db == nil {
+			t.Fatal("expected a non-nil FileDB instance")
+		}
+	})
+	defer db.Close()
+
+	t.Run("Has", func(t *testing.T) {
+		key := "testKey"
+
+		if db.Has(key) {
+			t.Fatal("expected Has to return false for a non-existent key")
+		}
+
+		db.Put(key, [][]byte{[]byte("value")})
+
+		if !db.Has(key) {
+			t.Fatal("expected Has to return true for an existing key")
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		key := "testKey2"
+		value := [][]byte{[]byte("value")}
+
+		_, err := db.Get(key)
+		if !errors.Is(err, filedb.KeyDoesNotExist) {
+			t.Fatal("expected KeyDoesNotExist error for a non-existent key")
+		}
+
+		db.Put(key, value)
+
+		gotValue, err := db.Get(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !bytes.Equal(gotValue[0], value[0]) {
+			t.Fatalf("expected %v, got %v", value, gotValue)
+		}
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		key := "testKey"
+		value := [][]byte{[]byte("value")}
+
+		err := db.Put(key, value)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		gotValue, err := db.Get(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !bytes.Equal(gotValue[0], value[0]) {
+			t.Fatalf("expected %v, got %v", value, gotValue)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		key := "testKey"
+		initialValue := [][]byte{[]byte("initial")}
+		updatedValue := [][]byte{[]byte("updated")}
+
+		err := db.Put(key, initialValue)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err = db.Update(key, func(val [][]byte) ([][]byte, error) {
+			return updatedValue, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		gotValue, err := db.Get(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !bytes.Equal(gotValue[0], updatedValue[0]) {
+			t.Fatalf("expected %v, got %v", updatedValue, gotValue)
+		}
+
+		// Test Update on non-existent key
+		err = db.Update("nonExistentKey", func(val [][]byte) ([][]byte, error) {
+			return nil, errors.New("error updating")
+		})
+		if !errors.Is(err, filedb.KeyDoesNotExist) {
+			t.Fatal("expected KeyDoesNotExist error for non-existent key")
+		}
+	})
+
+	t.Run("GetRange", func(t *testing.T) {
+		key := "rangeKey"
+		db.Put(key, [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+
+		n, err := db.Len(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n != 3 {
+			t.Fatalf("expected 3 values, got %d", n)
+		}
+
+		gotValue, err := db.GetRange(key, 1, 5)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(gotValue) != 2 || string(gotValue[0]) != "b" || string(gotValue[1]) != "c" {
+			t.Fatalf("expected values b and c, got %q", gotValue)
+		}
+
+		if _, err := db.GetRange("nonExistentKey", 0, 1); !errors.Is(err, filedb.KeyDoesNotExist) {
+			t.Fatal("expected KeyDoesNotExist error for a non-existent key")
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		newdb, err := filedb.Open(t.TempDir())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer newdb.Close()
+
+		keys, err := newdb.List()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(keys) != 0 {
+			t.Fatalf("expected no keys, got %d", len(keys))
+		}
+
+		newdb.Put("key1", [][]byte{[]byte("value1")})
+		newdb.Put("key2", [][]byte{[]byte("value2")})
+
+		keys, err = newdb.List()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(keys) != 2 {
+			t.Fatalf("expected 2 keys, got %d", len(keys))
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		db.Put("batchKey1", [][]byte{[]byte("old")})
+
+		err := db.Batch(func(batch datastore.Batch) error {
+			if err := batch.Put("batchKey2", [][]byte{[]byte("value2")}); err != nil {
+				return err
+			}
+			if !batch.Has("batchKey2") {
+				t.Fatal("expected batch to see its own writes")
+			}
+			return batch.Update("batchKey1", func(val [][]byte) ([][]byte, error) {
+				return [][]byte{[]byte("new")}, nil
+			})
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		gotValue, err := db.Get("batchKey1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(gotValue[0]) != "new" || !db.Has("batchKey2") {
+			t.Fatal("expected all batch writes to be applied")
+		}
+
+		// A failing batch discards its writes
+		err = db.Batch(func(batch datastore.Batch) error {
+			batch.Delete("batchKey1")
+			batch.Put("batchKey3", [][]byte{[]byte("value3")})
+			return errors.New("batch failed")
+		})
+		if err == nil {
+			t.Fatal("expected batch error to be returned")
+		}
+		if !db.Has("batchKey1") || db.Has("batchKey3") {
+			t.Fatal("expected failed batch writes to be discarded")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		key := "testKey"
+		value := [][]byte{[]byte("value")}
+
+		db.Put(key, value)
+
+		err := db.Delete(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = db.Get(key)
+		if !errors.Is(err, filedb.KeyDoesNotExist) {
+			t.Fatal("expected KeyDoesNotExist error after deleting key")
+		}
+	})
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	db, err := filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	db.Put("kept", [][]byte{[]byte("a"), []byte("b")})
+	db.Put("deleted", [][]byte{[]byte("c")})
+	db.Update("kept", func(val [][]byte) ([][]byte, error) {
+		return append(val, []byte("d")), nil
+	})
+	db.Delete("deleted")
+	db.Put("empty", [][]byte{})
+	db.Batch(func(batch datastore.Batch) error {
+		batch.Put("batched1", [][]byte{[]byte("e")})
+		return batch.Put("batched2", [][]byte{[]byte("f")})
+	})
+	db.Close()
+
+	db, err = filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+
+	gotValue, err := db.Get("kept")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(gotValue) != 3 || string(gotValue[2]) != "d" {
+		t.Fatalf("expected updated value to survive reopen, got %q", gotValue)
+	}
+	if db.Has("deleted") {
+		t.Fatal("expected deleted key to stay deleted after reopen")
+	}
+	if !db.Has("empty") {
+		t.Fatal("expected key with empty value to survive reopen")
+	}
+	if !db.Has("batched1") || !db.Has("batched2") {
+		t.Fatal("expected batch writes to survive reopen")
+	}
+}
+
+func TestAppend(t *testing.T) {
+	dir := t.TempDir()
+	db, err := filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	db.Append("list", [][]byte{[]byte("a")})
+	db.Append("list", [][]byte{[]byte("b"), []byte("c")})
+	db.Batch(func(batch datastore.Batch) error {
+		batch.Append("list", [][]byte{[]byte("d")})
+		if v, _ := batch.Get("list"); len(v) != 4 {
+			t.Fatalf("expected batch to see appended values, got %q", v)
+		}
+		return batch.Append("list", [][]byte{[]byte("e")})
+	})
+	// a key deleted and appended to in one batch only holds the appended values
+	db.Batch(func(batch datastore.Batch) error {
+		batch.Put("recreated", [][]byte{[]byte("old")})
+		return nil
+	})
+	db.Batch(func(batch datastore.Batch) error {
+		batch.Delete("recreated")
+		return batch.Append("recreated", [][]byte{[]byte("new")})
+	})
+
+	before := fileSize(t, dir)
+	db.Append("list", [][]byte{[]byte("f")})
+	if grown := fileSize(t, dir) - before; grown > 32 {
+		t.Errorf("expected an append to log only the new value, log grew by %d bytes", grown)
+	}
+	db.Close()
+
+	db, err = filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+
+	gotValue, err := db.Get("list")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(gotValue) != 6 || string(gotValue[0]) != "a" || string(gotValue[5]) != "f" {
+		t.Fatalf("expected appended values to survive reopen, got %q", gotValue)
+	}
+	if gotValue, _ := db.Get("recreated"); len(gotValue) != 1 || string(gotValue[0]) != "new" {
+		t.Fatalf("expected recreated key to hold only the appended value, got %q", gotValue)
+	}
+}
+
+func fileSize(t *testing.T, dir string) int64 {
+	info, err := os.Stat(filepath.Join(dir, "data.log"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return info.Size()
+}
+
+func TestTornWriteRecovery(t *testing.T) {
+	dir := t.TempDir()
+	db, err := filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.Put("key1", [][]byte{[]byte("value1")})
+	db.Close()
+
+	// Simulate a crash in the middle of appending a record
+	logPath := filepath.Join(dir, "data.log")
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.Write([]byte{0xff, 0x00, 0x00, 0x00, 0x12, 0x34, 0x56, 0x78, 0x01, 0x04})
+	f.Close()
+
+	db, err = filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.Get("key1"); err != nil {
+		t.Fatalf("expected records before the torn write to survive, got %v", err)
+	}
+
+	// New records are appended after the last intact one
+	db.Put("key2", [][]byte{[]byte("value2")})
+	db.Close()
+
+	db, err = filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+	if !db.Has("key1") || !db.Has("key2") {
+		t.Fatal("expected both records to survive reopen after recovery")
+	}
+}
+
+func TestCorruptRecordRecovery(t *testing.T) {
+	dir := t.TempDir()
+	db, err := filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.Put("key1", [][]byte{[]byte("value1")})
+	db.Put("key2", [][]byte{[]byte("value2")})
+	db.Close()
+
+	// Flip the last byte of the log, corrupting the checksum of the second record
+	logPath := filepath.Join(dir, "data.log")
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(logPath, data, 0o644)
+
+	db, err = filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+	if !db.Has("key1") {
+		t.Fatal("expected intact record to survive")
+	}
+	if db.Has("key2") {
+		t.Fatal("expected corrupted record to be discarded")
+	}
+}
+
+func TestCorruptRecordBeforeTail(t *testing.T) {
+	dir := t.TempDir()
+	db, err := filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.Put("key1", [][]byte{[]byte("value1")})
+	db.Put("key2", [][]byte{[]byte("value2")})
+	db.Close()
+
+	// Flip a payload byte of the first record, which is followed by the second one
+	logPath := filepath.Join(dir, "data.log")
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data[9] ^= 0xff
+	os.WriteFile(logPath, data, 0o644)
+
+	if _, err := filedb.Open(dir); err == nil {
+		t.Fatal("expected a corrupted record before the tail to fail the open")
+	}
+	if size := fileSize(t, dir); size != int64(len(data)) {
+		t.Fatalf("expected the log to be left intact, got %d bytes instead of %d", size, len(data))
+	}
+}
+
+func TestLock(t *testing.T) {
+	dir := t.TempDir()
+	db, err := filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := filedb.Open(dir); !errors.Is(err, filedb.ErrLocked) {
+		t.Fatalf("expected the open datastore to be locked, got %v", err)
+	}
+
+	db.Close()
+	db, err = filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("expected the lock to be released on close, got %v", err)
+	}
+	db.Close()
+}
+
+func TestCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db, err := filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	value := [][]byte{bytes.Repeat([]byte("x"), 1<<20)}
+	for i := 0; i < 10; i++ {
+		if err := db.Put("key", value); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	db.Put("small", [][]byte{[]byte("value")})
+
+	info, err := os.Stat(filepath.Join(dir, "data.log"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Size() > 3<<20 {
+		t.Fatalf("expected log to be compacted, got %d bytes", info.Size())
+	}
+	db.Close()
+
+	db, err = filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+	gotValue, err := db.Get("key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(gotValue[0], value[0]) {
+		t.Fatal("expected value to survive compaction")
+	}
+	if !db.Has("small") {
+		t.Fatal("expected all keys to survive compaction")
+	}
+}
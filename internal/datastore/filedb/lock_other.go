@@ -0,0 +1,11 @@
+//go:build !unix
+
+package filedb
+
+import "os"
+
+// File locks are not supported on this platform, so nothing stops a second process
+// from opening the datastore.
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package filedb
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Takes an exclusive lock on the file without blocking. The lock is released when
+// the file is closed, including when the process exits.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLocked
+	}
+	return err
+}
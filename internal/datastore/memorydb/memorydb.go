@@ -1,11 +1,12 @@
 package memorydb
 
 import (
-	"errors"
 	"sync"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
 )
 
-var KeyDoesNotExist = errors.New("key does not exist")
+var KeyDoesNotExist = datastore.KeyDoesNotExist
 
 type MemoryDB struct {
 	data  map[string][][]byte
@@ -33,7 +34,27 @@ func (db *MemoryDB) Get(key string) ([][]byte, error) {
 	if !ok {
 		return nil, KeyDoesNotExist
 	}
-	return v, nil
+	return v[:len(v):len(v)], nil
+}
+
+func (db *MemoryDB) Len(key string) (int, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	v, ok := db.data[key]
+	if !ok {
+		return 0, KeyDoesNotExist
+	}
+	return len(v), nil
+}
+
+func (db *MemoryDB) GetRange(key string, start, end int) ([][]byte, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	v, ok := db.data[key]
+	if !ok {
+		return nil, KeyDoesNotExist
+	}
+	return datastore.Slice(v, start, end), nil
 }
 
 func (db *MemoryDB) Put(key string, value [][]byte) error {
@@ -43,6 +64,13 @@ func (db *MemoryDB) Put(key string, value [][]byte) error {
 	return nil
 }
 
+func (db *MemoryDB) Append(key string, values [][]byte) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.apply(datastore.Write{Key: key, Value: values, Appended: true})
+	return nil
+}
+
 func (db *MemoryDB) Update(key string, updater func([][]byte) ([][]byte, error)) error {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
@@ -75,3 +103,30 @@ func (db *MemoryDB) Delete(key string) error {
 	delete(db.data, key)
 	return nil
 }
+
+func (db *MemoryDB) Batch(fn func(batch datastore.Batch) error) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	overlay := datastore.NewOverlay(db.data)
+	if err := fn(overlay); err != nil {
+		return err
+	}
+
+	for _, w := range overlay.Writes() {
+		db.apply(w)
+	}
+	return nil
+}
+
+// Must be called with the write lock held.
+func (db *MemoryDB) apply(w datastore.Write) {
+	switch {
+	case w.Deleted:
+		delete(db.data, w.Key)
+	case w.Appended:
+		// readers hold slices no longer than the values they got, so growing in place is safe
+		db.data[w.Key] = append(db.data[w.Key], w.Value...)
+	default:
+		db.data[w.Key] = w.Value
+	}
+}
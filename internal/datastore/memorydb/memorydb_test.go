@@ -5,6 +5,7 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
 	"github.com/zihaolam/ethereum-parser/internal/datastore/memorydb"
 )
 
@@ -106,6 +107,62 @@ func TestMain(t *testing.T) {
 		}
 	})
 
+	t.Run("GetRange", func(t *testing.T) {
+		key := "rangeKey"
+		db.Put(key, [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+
+		n, err := db.Len(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n != 3 {
+			t.Fatalf("expected 3 values, got %d", n)
+		}
+
+		gotValue, err := db.GetRange(key, 1, 5)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(gotValue) != 2 || string(gotValue[0]) != "b" || string(gotValue[1]) != "c" {
+			t.Fatalf("expected values b and c, got %q", gotValue)
+		}
+		if gotValue, _ := db.GetRange(key, 4, 6); len(gotValue) != 0 {
+			t.Fatalf("expected no values past the end, got %q", gotValue)
+		}
+
+		if _, err := db.GetRange("nonExistentKey", 0, 1); !errors.Is(err, memorydb.KeyDoesNotExist) {
+			t.Fatal("expected KeyDoesNotExist error for a non-existent key")
+		}
+		if _, err := db.Len("nonExistentKey"); !errors.Is(err, memorydb.KeyDoesNotExist) {
+			t.Fatal("expected KeyDoesNotExist error for a non-existent key")
+		}
+	})
+
+	t.Run("Append", func(t *testing.T) {
+		key := "appendKey"
+		db.Append(key, [][]byte{[]byte("a")})
+		first, _ := db.Get(key)
+
+		db.Append(key, [][]byte{[]byte("b")})
+		err := db.Batch(func(batch datastore.Batch) error {
+			return batch.Append(key, [][]byte{[]byte("c")})
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		gotValue, err := db.Get(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(gotValue) != 3 || string(gotValue[0]) != "a" || string(gotValue[2]) != "c" {
+			t.Fatalf("expected values a, b and c, got %q", gotValue)
+		}
+		if len(first) != 1 {
+			t.Fatalf("expected earlier reads to be unaffected by appends, got %q", first)
+		}
+	})
+
 	t.Run("List", func(t *testing.T) {
 		newdb := memorydb.New()
 		keys, err := newdb.List()
@@ -128,6 +185,46 @@ func TestMain(t *testing.T) {
 		}
 	})
 
+	t.Run("Batch", func(t *testing.T) {
+		db.Put("batchKey1", [][]byte{[]byte("old")})
+
+		err := db.Batch(func(batch datastore.Batch) error {
+			if err := batch.Put("batchKey2", [][]byte{[]byte("value2")}); err != nil {
+				return err
+			}
+			if !batch.Has("batchKey2") {
+				t.Fatal("expected batch to see its own writes")
+			}
+			return batch.Update("batchKey1", func(val [][]byte) ([][]byte, error) {
+				return [][]byte{[]byte("new")}, nil
+			})
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		gotValue, err := db.Get("batchKey1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(gotValue[0]) != "new" || !db.Has("batchKey2") {
+			t.Fatal("expected all batch writes to be applied")
+		}
+
+		// A failing batch discards its writes
+		err = db.Batch(func(batch datastore.Batch) error {
+			batch.Delete("batchKey1")
+			batch.Put("batchKey3", [][]byte{[]byte("value3")})
+			return errors.New("batch failed")
+		})
+		if err == nil {
+			t.Fatal("expected batch error to be returned")
+		}
+		if !db.Has("batchKey1") || db.Has("batchKey3") {
+			t.Fatal("expected failed batch writes to be discarded")
+		}
+	})
+
 	t.Run("Delete", func(t *testing.T) {
 		key := "testKey"
 		value := [][]byte{[]byte("value")}
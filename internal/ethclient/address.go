@@ -0,0 +1,107 @@
+package ethclient
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/zihaolam/ethereum-parser/internal/keccak"
+)
+
+var (
+	ErrInvalidAddress  = errors.New("invalid address")
+	ErrInvalidChecksum = errors.New("invalid address checksum")
+)
+
+// Address is a 20 byte Ethereum account address.
+// Its canonical form, used for storage and comparison, is lowercase hex; String
+// returns the EIP-55 mixed-case checksum encoding for display.
+type Address [20]byte
+
+// Parses a 0x-prefixed 40 digit hex address. Mixed-case input must carry a valid
+// EIP-55 checksum; all lowercase or all uppercase input is accepted without one.
+func ParseAddress(s string) (Address, error) {
+	var a Address
+	if len(s) != 42 || !strings.HasPrefix(s, "0x") {
+		return a, fmt.Errorf("%w: %q", ErrInvalidAddress, s)
+	}
+	if _, err := hex.Decode(a[:], []byte(s[2:])); err != nil {
+		return a, fmt.Errorf("%w: %q", ErrInvalidAddress, s)
+	}
+
+	digits := s[2:]
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && s != a.String() {
+		return a, fmt.Errorf("%w: %q", ErrInvalidChecksum, s)
+	}
+	return a, nil
+}
+
+// Reports whether s is a valid address as accepted by ParseAddress
+func IsAddress(s string) bool {
+	_, err := ParseAddress(s)
+	return err == nil
+}
+
+// Returns the canonical lowercase form of the address
+func (a Address) Hex() string {
+	return "0x" + hex.EncodeToString(a[:])
+}
+
+// Returns the EIP-55 checksum encoding of the address: hex letters are uppercased
+// where the matching nibble of the Keccak-256 hash of the lowercase hex is 8 or more.
+func (a Address) String() string {
+	digits := []byte(hex.EncodeToString(a[:]))
+	hash := keccak.Sum256(digits)
+	for i, c := range digits {
+		nibble := hash[i/2] >> 4
+		if i%2 == 1 {
+			nibble = hash[i/2] & 0xf
+		}
+		if c >= 'a' && nibble >= 8 {
+			digits[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(digits)
+}
+
+// Encodes the address in its canonical lowercase form, as nodes do
+func (a Address) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.Hex())
+}
+
+func (a *Address) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseAddress(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Returns the canonical lowercase form of s if it is a valid address
+func NormalizeAddress(s string) (string, error) {
+	a, err := ParseAddress(s)
+	if err != nil {
+		return "", err
+	}
+	return a.Hex(), nil
+}
+
+// Returns the EIP-55 checksum encoding of s if it is a valid address, and s unchanged otherwise
+func ChecksumAddress(s string) string {
+	a, err := ParseAddress(s)
+	if err != nil {
+		return s
+	}
+	return a.String()
+}
@@ -0,0 +1,74 @@
+package ethclient_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+func TestAddressChecksum(t *testing.T) {
+	// test vectors from EIP-55
+	for _, checksummed := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	} {
+		a, err := ethclient.ParseAddress(checksummed)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if a.String() != checksummed {
+			t.Errorf("expected checksum %s, got %s", checksummed, a.String())
+		}
+		if a.Hex() != strings.ToLower(checksummed) {
+			t.Errorf("expected lowercase hex for %s, got %s", checksummed, a.Hex())
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		err     error
+	}{
+		{"Lowercase", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", nil},
+		{"Uppercase", "0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED", nil},
+		{"BadChecksum", "0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", ethclient.ErrInvalidChecksum},
+		{"NoPrefix", "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed00", ethclient.ErrInvalidAddress},
+		{"TooShort", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1bea", ethclient.ErrInvalidAddress},
+		{"TooLong", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed00", ethclient.ErrInvalidAddress},
+		{"NotHex", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaeg", ethclient.ErrInvalidAddress},
+		{"Empty", "", ethclient.ErrInvalidAddress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ethclient.ParseAddress(tt.address)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestAddressJSON(t *testing.T) {
+	var a ethclient.Address
+	if err := json.Unmarshal([]byte(`"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"`), &a); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(b) != `"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"` {
+		t.Errorf("expected lowercase JSON, got %s", b)
+	}
+	if err := json.Unmarshal([]byte(`"0x1234"`), &a); !errors.Is(err, ethclient.ErrInvalidAddress) {
+		t.Errorf("expected ErrInvalidAddress, got %v", err)
+	}
+}
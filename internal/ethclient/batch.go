@@ -0,0 +1,216 @@
+package ethclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Maximum number of calls sent in one batch request. Larger batches are split,
+// as most nodes reject or throttle very large batches.
+const maxBatchSize = 100
+
+// Sends the requests as JSON-RPC batches and returns the responses in request order.
+// Responses are matched to requests by id, so ids must be unique within the batch.
+func sendBatchRPC[T any](
+	ctx context.Context,
+	c Client,
+	rbodies []RequestBody,
+) ([]ResponseBody[T], error) {
+	responses := make([]ResponseBody[T], 0, len(rbodies))
+	for start := 0; start < len(rbodies); start += maxBatchSize {
+		chunk := rbodies[start:min(start+maxBatchSize, len(rbodies))]
+
+		methods := make([]string, 0, len(chunk))
+		for _, req := range chunk {
+			methods = append(methods, req.Method)
+		}
+
+		var batch []ResponseBody[T]
+		err := c.do(ctx, isIdempotent(methods...), func(e *endpoint) error {
+			batch = nil
+			var raw json.RawMessage
+			if err := c.post(ctx, e, chunk, &raw); err != nil {
+				return err
+			}
+			if err := decodeBatch(raw, &batch); err != nil {
+				return err
+			}
+			// retry the whole chunk if the node rate limited any call in it
+			for _, res := range batch {
+				if res.Error != nil && errors.Is(res.Error, ErrRateLimited) {
+					return res.Error
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		byID := make(map[int]ResponseBody[T], len(batch))
+		for _, res := range batch {
+			byID[res.ID] = res
+		}
+
+		for _, req := range chunk {
+			res, ok := byID[req.ID]
+			if !ok {
+				return nil, fmt.Errorf("missing response for request %d (%s)", req.ID, req.Method)
+			}
+			if res.Error != nil {
+				return nil, fmt.Errorf("request %d (%s): %w", req.ID, req.Method, res.Error)
+			}
+			responses = append(responses, res)
+		}
+	}
+
+	return responses, nil
+}
+
+// Returns request bodies for calling method once per params, with ids unique within the batch.
+func makeBatchRequestBodies(method string, params []interface{}) []RequestBody {
+	bodies := make([]RequestBody, 0, len(params))
+	for i, p := range params {
+		body := makeRequestBody(method, p)
+		body.ID = i + 1
+		bodies = append(bodies, body)
+	}
+	return bodies
+}
+
+// Decodes the responses to a batch. A node that rejects the whole batch, for example
+// because it is rate limited or does not support batches, answers with a single error
+// object instead, which is returned as its *RPCError.
+func decodeBatch[T any](raw json.RawMessage, batch *[]ResponseBody[T]) error {
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		var res ResponseBody[json.RawMessage]
+		if err := json.Unmarshal(trimmed, &res); err != nil {
+			return fmt.Errorf("error decoding response body: %w", err)
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+		return errors.New("error decoding response body: expected an array of responses to a batch")
+	}
+	if err := json.Unmarshal(raw, batch); err != nil {
+		return fmt.Errorf("error decoding response body: %w", err)
+	}
+	return nil
+}
+
+// Returns the blocks with the given block numbers, in the same order.
+// It calls the JSON-RPC eth_getBlockByNumber method in batches.
+func (c Client) GetBlocksByNumber(ctx context.Context, blocknumbers []int) ([]Block, error) {
+	params := make([]interface{}, 0, len(blocknumbers))
+	for _, n := range blocknumbers {
+		params = append(params, []interface{}{fmt.Sprintf("0x%x", n), true})
+	}
+
+	res, err := sendBatchRPC[*Block](
+		ctx,
+		c,
+		makeBatchRequestBodies(GetBlockByNumberMethod, params),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error sending batch rpc: %w", err)
+	}
+
+	blocks := make([]Block, 0, len(res))
+	for i, r := range res {
+		if r.Result == nil {
+			return nil, fmt.Errorf("block %d: %w", blocknumbers[i], ErrBlockNotFound)
+		}
+		blocks = append(blocks, *r.Result)
+	}
+	return blocks, nil
+}
+
+// Block as returned by eth_getBlockByNumber without transaction bodies, listing only their hashes
+type blockWithHashes struct {
+	Block
+	Transactions []Hash `json:"transactions"`
+}
+
+// Returns the blocks with the given block numbers without their transactions, in the same order.
+// It calls the JSON-RPC eth_getBlockByNumber method in batches, leaving out the transaction bodies.
+func (c Client) GetBlocksWithoutTransactions(ctx context.Context, blocknumbers []int) ([]Block, error) {
+	params := make([]interface{}, 0, len(blocknumbers))
+	for _, n := range blocknumbers {
+		params = append(params, []interface{}{fmt.Sprintf("0x%x", n), false})
+	}
+
+	res, err := sendBatchRPC[*blockWithHashes](
+		ctx,
+		c,
+		makeBatchRequestBodies(GetBlockByNumberMethod, params),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error sending batch rpc: %w", err)
+	}
+
+	blocks := make([]Block, 0, len(res))
+	for i, r := range res {
+		if r.Result == nil {
+			return nil, fmt.Errorf("block %d: %w", blocknumbers[i], ErrBlockNotFound)
+		}
+		blocks = append(blocks, r.Result.Block)
+	}
+	return blocks, nil
+}
+
+// Returns the receipts of the transactions with the given hashes, in the same order.
+// It calls the JSON-RPC eth_getTransactionReceipt method in batches.
+func (c Client) GetTransactionReceipts(ctx context.Context, hashes []string) ([]Receipt, error) {
+	params := make([]interface{}, 0, len(hashes))
+	for _, hash := range hashes {
+		params = append(params, []string{hash})
+	}
+
+	res, err := sendBatchRPC[*Receipt](
+		ctx,
+		c,
+		makeBatchRequestBodies(GetTransactionReceiptMethod, params),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error sending batch rpc: %w", err)
+	}
+
+	receipts := make([]Receipt, 0, len(res))
+	for i, r := range res {
+		if r.Result == nil {
+			return nil, fmt.Errorf("transaction %s: %w", hashes[i], ErrReceiptNotFound)
+		}
+		receipts = append(receipts, *r.Result)
+	}
+	return receipts, nil
+}
+
+// Returns the transactions with the given hashes, in the same order.
+// It calls the JSON-RPC eth_getTransactionByHash method in batches.
+func (c Client) GetTransactionsByHash(ctx context.Context, hashes []string) ([]Transaction, error) {
+	params := make([]interface{}, 0, len(hashes))
+	for _, hash := range hashes {
+		params = append(params, []string{hash})
+	}
+
+	res, err := sendBatchRPC[*Transaction](
+		ctx,
+		c,
+		makeBatchRequestBodies(GetTransactionByHashMethod, params),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error sending batch rpc: %w", err)
+	}
+
+	txs := make([]Transaction, 0, len(res))
+	for i, r := range res {
+		if r.Result == nil {
+			return nil, fmt.Errorf("transaction %s not found", hashes[i])
+		}
+		txs = append(txs, *r.Result)
+	}
+	return txs, nil
+}
@@ -0,0 +1,218 @@
+package ethclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+type rpcRequest struct {
+	ID     int               `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// Starts a server answering JSON-RPC batches with handle, returning responses in reverse order.
+func newBatchServer(t *testing.T, handle func(req rpcRequest) interface{}) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var reqs []rpcRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		responses := make([]map[string]interface{}, 0, len(reqs))
+		for i := len(reqs) - 1; i >= 0; i-- {
+			responses = append(responses, map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      reqs[i].ID,
+				"result":  handle(reqs[i]),
+			})
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestGetBlocksByNumber(t *testing.T) {
+	server, calls := newBatchServer(t, func(req rpcRequest) interface{} {
+		var number ethclient.Quantity
+		json.Unmarshal(req.Params[0], &number)
+		return ethclient.Block{Header: ethclient.Header{Number: number}}
+	})
+	client := ethclient.New(server.URL)
+
+	blocknumbers := make([]int, 0, 150)
+	for i := 0; i < 150; i++ {
+		blocknumbers = append(blocknumbers, 1000+i)
+	}
+
+	blocks, err := client.GetBlocksByNumber(context.Background(), blocknumbers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls.Load() != 2 {
+		t.Errorf("expected 150 blocks to be fetched in 2 batches, got %d", calls.Load())
+	}
+	if len(blocks) != len(blocknumbers) {
+		t.Fatalf("expected %d blocks, got %d", len(blocknumbers), len(blocks))
+	}
+	for i, block := range blocks {
+		if block.Number.String() != fmt.Sprintf("0x%x", blocknumbers[i]) {
+			t.Fatalf("expected block %d at index %d, got %s", blocknumbers[i], i, block.Number)
+		}
+	}
+}
+
+func TestGetBlocksWithoutTransactions(t *testing.T) {
+	server, _ := newBatchServer(t, func(req rpcRequest) interface{} {
+		var number ethclient.Quantity
+		var full bool
+		json.Unmarshal(req.Params[0], &number)
+		json.Unmarshal(req.Params[1], &full)
+		if full {
+			t.Errorf("expected transaction bodies to be left out")
+		}
+		return map[string]interface{}{
+			"number":       number,
+			"transactions": []string{"0x" + strings.Repeat("ab", 32)},
+			"withdrawals":  []ethclient.Withdrawal{{Index: number, Amount: ethclient.IntQuantity(1)}},
+		}
+	})
+	client := ethclient.New(server.URL)
+
+	blocks, err := client.GetBlocksWithoutTransactions(context.Background(), []int{7, 8})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %d", len(blocks))
+	}
+	for i, block := range blocks {
+		if want := fmt.Sprintf("0x%x", 7+i); block.Number.String() != want ||
+			len(block.Withdrawals) != 1 || block.Withdrawals[0].Index.String() != want || block.Transactions != nil {
+			t.Fatalf("unexpected block at index %d: %+v", i, block)
+		}
+	}
+}
+
+func TestGetTransactionReceipts(t *testing.T) {
+	server, _ := newBatchServer(t, func(req rpcRequest) interface{} {
+		var hash string
+		json.Unmarshal(req.Params[0], &hash)
+		if hash == "0xpending" {
+			return nil
+		}
+		return ethclient.Receipt{TransactionHash: hash, Status: "0x1"}
+	})
+	client := ethclient.New(server.URL)
+
+	hashes := []string{"0x01", "0x02", "0x03"}
+	receipts, err := client.GetTransactionReceipts(context.Background(), hashes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, receipt := range receipts {
+		if receipt.TransactionHash != hashes[i] {
+			t.Fatalf("expected receipt for %s at index %d, got %s", hashes[i], i, receipt.TransactionHash)
+		}
+	}
+
+	if _, err := client.GetTransactionReceipts(context.Background(), []string{"0x01", "0xpending"}); err == nil {
+		t.Fatal("expected error for missing receipt")
+	}
+}
+
+func TestGetTransactionsByHash(t *testing.T) {
+	server, calls := newBatchServer(t, func(req rpcRequest) interface{} {
+		var hash string
+		json.Unmarshal(req.Params[0], &hash)
+		if hash == "0xunknown" {
+			return nil
+		}
+		h, _ := ethclient.ParseHash(hash)
+		return ethclient.Transaction{Hash: h}
+	})
+	client := ethclient.New(server.URL)
+
+	hashes := []string{
+		"0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b",
+		"0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2",
+	}
+	txs, err := client.GetTransactionsByHash(context.Background(), hashes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, tx := range txs {
+		if tx.Hash.Hex() != hashes[i] {
+			t.Fatalf("expected transaction %s at index %d, got %s", hashes[i], i, tx.Hash)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 batch request, got %d", calls.Load())
+	}
+
+	if _, err := client.GetTransactionsByHash(context.Background(), []string{"0xunknown"}); err == nil {
+		t.Fatal("expected error for unknown transaction")
+	}
+}
+
+func TestBatchMissingResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []rpcRequest
+		json.NewDecoder(r.Body).Decode(&reqs)
+		// only answer the first request
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"jsonrpc": "2.0", "id": reqs[0].ID, "result": ethclient.Block{}},
+		})
+	}))
+	defer server.Close()
+
+	client := ethclient.New(server.URL)
+	if _, err := client.GetBlocksByNumber(context.Background(), []int{1, 2}); err == nil {
+		t.Fatal("expected error for missing batch response")
+	}
+}
+
+func TestBatchRejected(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the whole batch is rejected with a single error object
+		if calls.Add(1) == 1 {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      nil,
+				"error":   map[string]interface{}{"code": ethclient.CodeLimitExceeded, "message": "rate limited"},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      nil,
+			"error":   map[string]interface{}{"code": -32600, "message": "batch requests not supported"},
+		})
+	}))
+	defer server.Close()
+
+	client := ethclient.New(server.URL, ethclient.WithRetryPolicy(ethclient.RetryPolicy{MaxAttempts: 2}))
+	_, err := client.GetBlocksByNumber(context.Background(), []int{1, 2})
+	var rpcErr *ethclient.RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != -32600 {
+		t.Fatalf("expected the batch's RPC error, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected the rate limited batch to be retried, got %d calls", calls.Load())
+	}
+}
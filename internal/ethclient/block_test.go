@@ -0,0 +1,81 @@
+package ethclient_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// A post-Cancun block as returned by eth_getBlockByNumber without transaction objects
+const nodeBlock = `{
+	"number": "0x13a5c2b",
+	"hash": "0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2",
+	"parentHash": "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b",
+	"timestamp": "0x6655c1e3",
+	"miner": "0x95222290dd7278aa3ddd389cc1e1d165cc4bafe5",
+	"gasLimit": "0x1c9c380",
+	"gasUsed": "0xe4e1c0",
+	"stateRoot": "0x0000000000000000000000000000000000000000000000000000000000000001",
+	"transactionsRoot": "0x0000000000000000000000000000000000000000000000000000000000000002",
+	"receiptsRoot": "0x0000000000000000000000000000000000000000000000000000000000000003",
+	"logsBloom": "0x0010",
+	"sha3Uncles": "0x1dcc4de8dec75d7aab85b567b6ccd41ad312451b948a7413f0a142fd40d49347",
+	"extraData": "0x6265617665726275696c642e6f7267",
+	"difficulty": "0x0",
+	"totalDifficulty": "0xc70d815d562d3cfa955",
+	"nonce": "0x0000000000000000",
+	"mixHash": "0x0000000000000000000000000000000000000000000000000000000000000004",
+	"baseFeePerGas": "0x1dcd6500",
+	"withdrawalsRoot": "0x0000000000000000000000000000000000000000000000000000000000000005",
+	"blobGasUsed": "0x40000",
+	"excessBlobGas": "0x0",
+	"parentBeaconBlockRoot": "0x0000000000000000000000000000000000000000000000000000000000000006",
+	"requestsHash": null,
+	"size": "0x1a2b",
+	"transactions": [],
+	"uncles": [],
+	"withdrawals": [
+		{
+			"index": "0x2e2b3e0",
+			"validatorIndex": "0x10c5b",
+			"address": "0xb9d7934878b5fb9610b3fe8a5e441e8fad7e293f",
+			"amount": "0x11e1a05"
+		}
+	]
+}`
+
+func TestBlockJSON(t *testing.T) {
+	var block ethclient.Block
+	if err := json.Unmarshal([]byte(nodeBlock), &block); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := time.Date(2024, 5, 28, 11, 37, 7, 0, time.UTC); !block.Time().Equal(want) {
+		t.Errorf("expected block time %s, got %s", want, block.Time())
+	}
+	if block.WithdrawalsRoot == nil || block.ParentBeaconBlockRoot == nil || block.RequestsHash != nil {
+		t.Errorf("expected fork roots to be set up to Cancun, got %+v", block.Header)
+	}
+	if len(block.Withdrawals) != 1 || block.Withdrawals[0].Address.Hex() != "0xb9d7934878b5fb9610b3fe8a5e441e8fad7e293f" {
+		t.Errorf("unexpected withdrawals %+v", block.Withdrawals)
+	}
+
+	b, err := json.Marshal(block)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got, want map[string]interface{}
+	json.Unmarshal(b, &got)
+	json.Unmarshal([]byte(nodeBlock), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %s, got %s", nodeBlock, b)
+	}
+}
+
+func TestHeaderTimeUnset(t *testing.T) {
+	if !(ethclient.Header{}).Time().IsZero() {
+		t.Fatal("expected zero time for a header without timestamp")
+	}
+}
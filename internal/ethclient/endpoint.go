@@ -0,0 +1,152 @@
+package ethclient
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// weight of the newest sample in the latency and error rate moving averages
+	healthSmoothing = 0.2
+	// consecutive failures after which an endpoint is only used as a last resort
+	maxConsecutiveFailures = 3
+	// how long an endpoint stays demoted after reaching maxConsecutiveFailures
+	failureCooldown = 30 * time.Second
+)
+
+// endpoint is an RPC endpoint together with its observed health
+type endpoint struct {
+	url string
+
+	mutex               sync.Mutex
+	latency             time.Duration
+	errorRate           float64
+	consecutiveFailures int
+	lastFailure         time.Time
+}
+
+// EndpointHealth is a snapshot of an endpoint's observed health
+type EndpointHealth struct {
+	URL       string        `json:"url"`
+	Latency   time.Duration `json:"latency"`
+	ErrorRate float64       `json:"error_rate"`
+	// whether the endpoint is demoted after repeated failures
+	Demoted bool `json:"demoted"`
+}
+
+func newEndpoint(url string) *endpoint {
+	return &endpoint{url: url}
+}
+
+// Records the outcome of a call. Only failures that say something about the endpoint,
+// such as network errors or rate limiting, count against it.
+func (e *endpoint) record(latency time.Duration, failed bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		e.latency = time.Duration(healthSmoothing*float64(latency) + (1-healthSmoothing)*float64(e.latency))
+	}
+
+	sample := 0.0
+	if failed {
+		sample = 1
+		e.consecutiveFailures++
+		e.lastFailure = time.Now()
+	} else {
+		e.consecutiveFailures = 0
+	}
+	e.errorRate = healthSmoothing*sample + (1-healthSmoothing)*e.errorRate
+}
+
+func (e *endpoint) health(now time.Time) EndpointHealth {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return EndpointHealth{
+		URL:       e.url,
+		Latency:   e.latency,
+		ErrorRate: e.errorRate,
+		Demoted: e.consecutiveFailures >= maxConsecutiveFailures &&
+			now.Sub(e.lastFailure) < failureCooldown,
+	}
+}
+
+// Lower is better: latency penalised by the error rate
+func (h EndpointHealth) score() float64 {
+	return float64(h.Latency) * (1 + 10*h.ErrorRate)
+}
+
+// Returns the endpoints ordered from healthiest to least healthy.
+// Demoted endpoints come last; ties keep the configured order.
+func (c Client) rankedEndpoints() []*endpoint {
+	now := time.Now()
+	type ranked struct {
+		endpoint *endpoint
+		health   EndpointHealth
+	}
+	candidates := make([]ranked, 0, len(c.endpoints))
+	for _, e := range c.endpoints {
+		candidates = append(candidates, ranked{endpoint: e, health: e.health(now)})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i].health, candidates[j].health
+		if a.Demoted != b.Demoted {
+			return !a.Demoted
+		}
+		return a.score() < b.score()
+	})
+
+	endpoints := make([]*endpoint, 0, len(candidates))
+	for _, r := range candidates {
+		endpoints = append(endpoints, r.endpoint)
+	}
+	return endpoints
+}
+
+// Returns the observed health of every endpoint, healthiest first
+func (c Client) EndpointHealth() []EndpointHealth {
+	now := time.Now()
+	health := make([]EndpointHealth, 0, len(c.endpoints))
+	for _, e := range c.rankedEndpoints() {
+		health = append(health, e.health(now))
+	}
+	return health
+}
+
+// Runs call against the healthiest endpoint, failing over to the next one when it
+// fails with a retryable error. Calls of methods that are not idempotent never fail over.
+func (c Client) failover(ctx context.Context, idempotent bool, call func(e *endpoint) error) error {
+	var err error
+	for _, e := range c.rankedEndpoints() {
+		err = c.callEndpoint(ctx, e, call)
+		if err == nil || !idempotent || ctx.Err() != nil || !IsRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Runs call against the endpoint once the rate limiter allows another request, and records
+// the outcome in its health. Every attempt, including retries and failovers, takes a token.
+func (c Client) callEndpoint(ctx context.Context, e *endpoint, call func(e *endpoint) error) error {
+	if err := c.limiter.wait(ctx); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err := call(e)
+	e.record(time.Since(start), err != nil && IsRetryable(err))
+	return err
+}
+
+// Sends a call with failover between endpoints, retrying the whole round according to the retry policy
+func (c Client) do(ctx context.Context, idempotent bool, call func(e *endpoint) error) error {
+	return c.withRetry(ctx, idempotent, func() error {
+		return c.failover(ctx, idempotent, call)
+	})
+}
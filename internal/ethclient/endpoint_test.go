@@ -0,0 +1,142 @@
+package ethclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var noRetry = RetryPolicy{MaxAttempts: 1}
+
+func TestFailover(t *testing.T) {
+	down, downAttempts := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	up, upAttempts := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		writeResult(w, id, "0x10")
+	})
+
+	client := New(down.URL, WithEndpoints(up.URL), WithRetryPolicy(noRetry))
+	for i := 0; i < 10; i++ {
+		if _, err := client.GetCurrentBlockNumber(context.Background()); err != nil {
+			t.Fatalf("expected call to fail over to the healthy endpoint, got %v", err)
+		}
+	}
+
+	if upAttempts.Load() != 10 {
+		t.Fatalf("expected every call to reach the healthy endpoint, got %d", upAttempts.Load())
+	}
+	// the failing endpoint is ranked last after its first failure
+	if downAttempts.Load() != 1 {
+		t.Fatalf("expected failing endpoint to be tried once, got %d", downAttempts.Load())
+	}
+
+	health := client.EndpointHealth()
+	if health[0].URL != up.URL || health[1].ErrorRate == 0 {
+		t.Fatalf("expected healthy endpoint to rank first, got %+v", health)
+	}
+}
+
+func TestFailoverRateLimited(t *testing.T) {
+	down, _ := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	up, _ := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		writeResult(w, id, "0x10")
+	})
+
+	// the request failed over to the second endpoint waits for a token of its own
+	client := New(down.URL, WithEndpoints(up.URL), WithRetryPolicy(noRetry), WithRateLimit(10, 1))
+	start := time.Now()
+	if _, err := client.GetCurrentBlockNumber(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("expected every request sent to take a token, took %v", elapsed)
+	}
+}
+
+func TestNoFailoverOnPermanentErrors(t *testing.T) {
+	first, _ := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		writeError(w, id, CodeExecutionReverted, "execution reverted")
+	})
+	second, secondAttempts := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		writeResult(w, id, "0x10")
+	})
+
+	client := New(first.URL, WithEndpoints(second.URL), WithRetryPolicy(noRetry))
+	if _, err := client.GetCurrentBlockNumber(context.Background()); !errors.Is(err, ErrExecutionReverted) {
+		t.Fatalf("expected ErrExecutionReverted, got %v", err)
+	}
+	if secondAttempts.Load() != 0 {
+		t.Fatal("expected permanent errors not to fail over")
+	}
+}
+
+func TestAllEndpointsFail(t *testing.T) {
+	first, _ := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	second, _ := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	})
+
+	client := New(first.URL, WithEndpoints(second.URL), WithRetryPolicy(noRetry))
+	_, err := client.GetCurrentBlockNumber(context.Background())
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("expected HTTP error from the last endpoint, got %v", err)
+	}
+}
+
+// Starts a server returning a block with the given hash for every block number
+func newBlockServer(t *testing.T, hash Hash) string {
+	server, _ := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		writeResult(w, id, Block{Header: Header{Number: IntQuantity(1), Hash: hash}})
+	})
+	return server.URL
+}
+
+func TestQuorum(t *testing.T) {
+	a := newBlockServer(t, Hash{0xaa})
+	b := newBlockServer(t, Hash{0xaa})
+	c := newBlockServer(t, Hash{0xbb})
+
+	client := New(a, WithEndpoints(b), WithQuorum(2), WithRetryPolicy(noRetry))
+	block, err := client.GetBlockByNumber(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if block.Hash != (Hash{0xaa}) {
+		t.Fatalf("expected agreed block hash, got %s", block.Hash)
+	}
+
+	client = New(a, WithEndpoints(b, c), WithQuorum(3), WithRetryPolicy(noRetry))
+	if _, err := client.GetBlockByNumber(context.Background(), 1); !errors.Is(err, ErrQuorumMismatch) {
+		t.Fatalf("expected ErrQuorumMismatch, got %v", err)
+	}
+}
+
+func TestQuorumReplacesFailedEndpoints(t *testing.T) {
+	down, _ := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	a := newBlockServer(t, Hash{0xaa})
+	b := newBlockServer(t, Hash{0xaa})
+
+	client := New(down.URL, WithEndpoints(a, b), WithQuorum(2), WithRetryPolicy(noRetry))
+	block, err := client.GetBlockByNumber(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if block.Hash != (Hash{0xaa}) {
+		t.Fatalf("expected agreed block hash, got %s", block.Hash)
+	}
+
+	client = New(down.URL, WithEndpoints(a), WithQuorum(2), WithRetryPolicy(noRetry))
+	if _, err := client.GetBlockByNumber(context.Background(), 1); err == nil {
+		t.Fatal("expected error when too few endpoints respond")
+	}
+}
@@ -0,0 +1,83 @@
+package ethclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Well-known classes of JSON-RPC errors. RPCError matches them with errors.Is,
+// so callers can branch on the class without knowing node specific codes and messages.
+var (
+	ErrRateLimited       = errors.New("rate limited")
+	ErrBlockNotFound     = errors.New("block not found")
+	ErrReceiptNotFound   = errors.New("receipt not found")
+	ErrExecutionReverted = errors.New("execution reverted")
+	ErrMethodNotFound    = errors.New("method not found")
+	ErrInvalidParams     = errors.New("invalid params")
+)
+
+// JSON-RPC error codes, see https://www.jsonrpc.org/specification#error_object and EIP-1474
+const (
+	CodeInvalidParams      = -32602
+	CodeMethodNotFound     = -32601
+	CodeLimitExceeded      = -32005
+	CodeExecutionReverted  = 3
+	CodeResourceNotFound   = -32001
+	CodeServerErrorDefault = -32000
+)
+
+// RPCError is the error object returned by a node in place of a result
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	if len(e.Data) > 0 {
+		return fmt.Sprintf("rpc error %d: %s (%s)", e.Code, e.Message, e.Data)
+	}
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// Reports whether the error belongs to one of the well-known error classes
+func (e *RPCError) Is(target error) bool {
+	message := strings.ToLower(e.Message)
+	switch target {
+	case ErrRateLimited:
+		return e.Code == CodeLimitExceeded ||
+			strings.Contains(message, "rate limit") ||
+			strings.Contains(message, "too many requests")
+	case ErrBlockNotFound:
+		return e.Code == CodeResourceNotFound ||
+			strings.Contains(message, "header not found") ||
+			strings.Contains(message, "block not found") ||
+			strings.Contains(message, "unknown block")
+	case ErrExecutionReverted:
+		return e.Code == CodeExecutionReverted || strings.Contains(message, "execution reverted")
+	case ErrMethodNotFound:
+		return e.Code == CodeMethodNotFound
+	case ErrInvalidParams:
+		return e.Code == CodeInvalidParams
+	}
+	return false
+}
+
+// HTTPError is returned when the node responds with a non-200 status code
+type HTTPError struct {
+	StatusCode int
+	// delay requested by the node through the Retry-After header, if any
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("error response status code: %v", e.StatusCode)
+}
+
+func (e *HTTPError) Is(target error) bool {
+	return target == ErrRateLimited && e.StatusCode == http.StatusTooManyRequests
+}
@@ -0,0 +1,162 @@
+package ethclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Retries are covered by the retry tests, error classification is tested on the first attempt
+var noRetry = ethclient.WithRetryPolicy(ethclient.RetryPolicy{MaxAttempts: 1})
+
+// Starts a server that answers every JSON-RPC call with the given error object
+func newErrorServer(t *testing.T, rpcErr map[string]interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req rpcRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+			"error":   rpcErr,
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRPCError(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   map[string]interface{}
+		class error
+	}{
+		{
+			name:  "RateLimited",
+			err:   map[string]interface{}{"code": -32005, "message": "limit exceeded"},
+			class: ethclient.ErrRateLimited,
+		},
+		{
+			name:  "BlockNotFound",
+			err:   map[string]interface{}{"code": -32000, "message": "header not found"},
+			class: ethclient.ErrBlockNotFound,
+		},
+		{
+			name:  "ExecutionReverted",
+			err:   map[string]interface{}{"code": 3, "message": "execution reverted", "data": "0x08c379a0"},
+			class: ethclient.ErrExecutionReverted,
+		},
+		{
+			name:  "MethodNotFound",
+			err:   map[string]interface{}{"code": -32601, "message": "the method does not exist"},
+			class: ethclient.ErrMethodNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := ethclient.New(newErrorServer(t, tt.err).URL, noRetry)
+
+			// previously panicked slicing the empty result
+			_, err := client.GetCurrentBlockNumber(context.Background())
+			if err == nil {
+				t.Fatal("expected error")
+			}
+
+			var rpcErr *ethclient.RPCError
+			if !errors.As(err, &rpcErr) {
+				t.Fatalf("expected RPCError, got %v", err)
+			}
+			if rpcErr.Code != tt.err["code"] {
+				t.Errorf("expected code %v, got %d", tt.err["code"], rpcErr.Code)
+			}
+			if rpcErr.Message != tt.err["message"] {
+				t.Errorf("expected message %v, got %s", tt.err["message"], rpcErr.Message)
+			}
+			if !errors.Is(err, tt.class) {
+				t.Errorf("expected error to match %v", tt.class)
+			}
+
+			_, err = client.GetBlockByNumber(context.Background(), 1)
+			if !errors.Is(err, tt.class) {
+				t.Errorf("expected GetBlockByNumber error to match %v, got %v", tt.class, err)
+			}
+		})
+	}
+}
+
+func TestRPCErrorData(t *testing.T) {
+	client := ethclient.New(newErrorServer(t, map[string]interface{}{
+		"code":    3,
+		"message": "execution reverted",
+		"data":    "0x08c379a0",
+	}).URL, noRetry)
+
+	_, err := client.GetCurrentBlockNumber(context.Background())
+	var rpcErr *ethclient.RPCError
+	if !errors.As(err, &rpcErr) {
+		t.Fatalf("expected RPCError, got %v", err)
+	}
+	if string(rpcErr.Data) != `"0x08c379a0"` {
+		t.Errorf("expected error data to be kept, got %s", rpcErr.Data)
+	}
+}
+
+func TestBlockNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req rpcRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": nil})
+	}))
+	defer server.Close()
+
+	client := ethclient.New(server.URL)
+	if _, err := client.GetBlockByNumber(context.Background(), 1); !errors.Is(err, ethclient.ErrBlockNotFound) {
+		t.Fatalf("expected ErrBlockNotFound for null block, got %v", err)
+	}
+}
+
+func TestHTTPRateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := ethclient.New(server.URL, noRetry)
+	_, err := client.GetCurrentBlockNumber(context.Background())
+	if !errors.Is(err, ethclient.ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited for HTTP 429, got %v", err)
+	}
+}
+
+func TestBatchRPCError(t *testing.T) {
+	server, _ := newBatchServer(t, func(req rpcRequest) interface{} {
+		return nil
+	})
+	client := ethclient.New(server.URL)
+	if _, err := client.GetBlocksByNumber(context.Background(), []int{1}); !errors.Is(err, ethclient.ErrBlockNotFound) {
+		t.Fatalf("expected ErrBlockNotFound, got %v", err)
+	}
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []rpcRequest
+		json.NewDecoder(r.Body).Decode(&reqs)
+		json.NewEncoder(w).Encode([]map[string]interface{}{{
+			"jsonrpc": "2.0",
+			"id":      reqs[0].ID,
+			"error":   map[string]interface{}{"code": -32005, "message": "limit exceeded"},
+		}})
+	}))
+	defer server.Close()
+
+	client = ethclient.New(server.URL, noRetry)
+	_, err := client.GetBlocksByNumber(context.Background(), []int{1})
+	var rpcErr *ethclient.RPCError
+	if !errors.As(err, &rpcErr) || !errors.Is(err, ethclient.ErrRateLimited) {
+		t.Fatalf("expected rate limit RPCError from batch, got %v", err)
+	}
+}
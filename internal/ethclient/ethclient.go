@@ -5,19 +5,76 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"math/rand"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 const (
 	ApiVersion                  = "2.0"
 	GetCurrentBlocknumberMethod = "eth_blockNumber"
 	GetBlockByNumberMethod      = "eth_getBlockByNumber"
+	GetBlockByHashMethod        = "eth_getBlockByHash"
+	GetTransactionReceiptMethod = "eth_getTransactionReceipt"
+	GetBlockReceiptsMethod      = "eth_getBlockReceipts"
+	GetLogsMethod               = "eth_getLogs"
+	GetTransactionByHashMethod  = "eth_getTransactionByHash"
+)
+
+// Block tags accepted by eth_getBlockByNumber in place of a block number.
+const (
+	LatestTag    = "latest"
+	SafeTag      = "safe"
+	FinalizedTag = "finalized"
 )
 
 type Client struct {
-	endpoint string
+	endpoints  []*endpoint
+	httpClient *http.Client
+	retry      RetryPolicy
+	limiter    *rateLimiter
+	// number of endpoints that must agree on a block fetched with GetBlockByNumber
+	quorum int
+}
+
+// Option configures optional behaviour of the Client.
+type Option func(*Client)
+
+// Retry policy for failed calls. Defaults to DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// Additional endpoints to fail over to. Calls go to the healthiest endpoint,
+// scored by latency and error rate.
+func WithEndpoints(endpoints ...string) Option {
+	return func(c *Client) {
+		for _, url := range endpoints {
+			c.endpoints = append(c.endpoints, newEndpoint(url))
+		}
+	}
+}
+
+// Fetch blocks with GetBlockByNumber from n endpoints and reject them unless all agree on the hash.
+// Values below 2 disable quorum mode.
+func WithQuorum(n int) Option {
+	return func(c *Client) {
+		c.quorum = n
+	}
+}
+
+// Limits requests to the node with a token bucket holding up to burst requests. Every
+// request sent counts, including retries and failovers. By default requests are not limited.
+func WithRateLimit(requestsPerSecond float64, burst int) Option {
+	return func(c *Client) {
+		if requestsPerSecond > 0 {
+			c.limiter = newRateLimiter(requestsPerSecond, burst)
+		}
+	}
 }
 
 type RequestBody struct {
@@ -28,119 +85,449 @@ type RequestBody struct {
 }
 
 type ResponseBody[T any] struct {
-	Jsonrpc string `json:"jsonrpc"`
-	ID      int    `json:"id"`
-	Result  T      `json:"result"`
+	Jsonrpc string    `json:"jsonrpc"`
+	ID      int       `json:"id"`
+	Result  T         `json:"result"`
+	Error   *RPCError `json:"error"`
+}
+
+// Header is a block header. Fields introduced by a fork are unset on blocks before it.
+type Header struct {
+	Number           Quantity `json:"number"`
+	Hash             Hash     `json:"hash"`
+	ParentHash       Hash     `json:"parentHash"`
+	Timestamp        Quantity `json:"timestamp"`
+	Miner            Address  `json:"miner"`
+	GasLimit         Quantity `json:"gasLimit"`
+	GasUsed          Quantity `json:"gasUsed"`
+	StateRoot        Hash     `json:"stateRoot"`
+	TransactionsRoot Hash     `json:"transactionsRoot"`
+	ReceiptsRoot     Hash     `json:"receiptsRoot"`
+	LogsBloom        Data     `json:"logsBloom"`
+	Sha3Uncles       Hash     `json:"sha3Uncles"`
+	ExtraData        Data     `json:"extraData"`
+	// proof of work fields, zero since the merge. Nodes may still report the
+	// total difficulty, or leave it out.
+	Difficulty      Quantity `json:"difficulty"`
+	TotalDifficulty Quantity `json:"totalDifficulty"`
+	Nonce           Data     `json:"nonce"`
+	// the beacon chain's randomness since the merge
+	MixHash Hash `json:"mixHash"`
+	// London
+	BaseFeePerGas Quantity `json:"baseFeePerGas"`
+	// Shanghai
+	WithdrawalsRoot *Hash `json:"withdrawalsRoot"`
+	// Cancun
+	BlobGasUsed           Quantity `json:"blobGasUsed"`
+	ExcessBlobGas         Quantity `json:"excessBlobGas"`
+	ParentBeaconBlockRoot *Hash    `json:"parentBeaconBlockRoot"`
+	// Prague
+	RequestsHash *Hash `json:"requestsHash"`
+}
+
+// Returns the time the block was produced, the zero time if the timestamp is unset
+func (h Header) Time() time.Time {
+	seconds, err := h.Timestamp.Int()
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(seconds), 0).UTC()
 }
 
 type Block struct {
-	Number       string        `json:"number"`
-	Hash         string        `json:"hash"`
+	Header
+	Size         Quantity      `json:"size"`
 	Transactions []Transaction `json:"transactions"`
+	Uncles       []Hash        `json:"uncles"`
+	// validator withdrawals from the beacon chain, nil before Shanghai
+	Withdrawals []Withdrawal `json:"withdrawals"`
 }
 
+// Withdrawal is a transfer of staked ether from the beacon chain, EIP-4895
+type Withdrawal struct {
+	Index          Quantity `json:"index"`
+	ValidatorIndex Quantity `json:"validatorIndex"`
+	Address        Address  `json:"address"`
+	// in gwei
+	Amount Quantity `json:"amount"`
+}
+
+// Transaction types, see EIP-2718
+const (
+	LegacyTxType     = 0
+	AccessListTxType = 1
+	DynamicFeeTxType = 2
+	BlobTxType       = 3
+	SetCodeTxType    = 4
+)
+
+// Transaction is a transaction of any type. Fields a type does not have are unset.
 type Transaction struct {
-	ChainID          string            `json:"chainId"`
-	BlockNumber      string            `json:"blockNumber"`
-	BlockHash        string            `json:"-"`
-	Hash             string            `json:"hash"`
-	Nonce            string            `json:"nonce"`
-	From             string            `json:"from"`
-	To               string            `json:"to"`
-	Value            string            `json:"value"`
-	Gas              string            `json:"gas"`
-	GasPrice         string            `json:"gasPrice"`
-	Input            string            `json:"input"`
-	Type             string            `json:"-"`
-	R                string            `json:"-"`
-	S                string            `json:"-"`
-	V                string            `json:"-"`
-	TransactionIndex string            `json:"-"`
-	AccessList       []AccessListEntry `json:"-"`
+	Type        Quantity `json:"type"`
+	ChainID     Quantity `json:"chainId"`
+	BlockNumber Quantity `json:"blockNumber"`
+	BlockHash   Hash     `json:"blockHash"`
+	Hash        Hash     `json:"hash"`
+	Nonce       Quantity `json:"nonce"`
+	From        Address  `json:"from"`
+	// nil for a contract creation
+	To    *Address `json:"to"`
+	Value Quantity `json:"value"`
+	Gas   Quantity `json:"gas"`
+	// the price bid by legacy and access list transactions. Nodes report the price paid
+	// by mined transactions of later types, or their fee cap if they are older.
+	GasPrice Quantity `json:"gasPrice"`
+	// fee caps of dynamic fee transactions and later types, EIP-1559
+	MaxFeePerGas         Quantity `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas Quantity `json:"maxPriorityFeePerGas"`
+	// fee cap and blob hashes of blob transactions, EIP-4844
+	MaxFeePerBlobGas    Quantity `json:"maxFeePerBlobGas"`
+	BlobVersionedHashes []Hash   `json:"blobVersionedHashes"`
+	Input               Data     `json:"input"`
+	// storage pre-declared by access list transactions and later types, EIP-2930
+	AccessList []AccessListEntry `json:"accessList"`
+	// code delegations of set code transactions, EIP-7702
+	AuthorizationList []Authorization `json:"authorizationList"`
+	// signature. V is the recovery id of typed transactions, and also encodes the chain ID
+	// of legacy transactions following EIP-155.
+	V                Quantity `json:"v"`
+	R                Quantity `json:"r"`
+	S                Quantity `json:"s"`
+	YParity          Quantity `json:"yParity"`
+	TransactionIndex Quantity `json:"transactionIndex"`
+	// the timestamp of the block, returned by newer nodes only
+	BlockTimestamp Quantity `json:"blockTimestamp"`
 }
 
 type AccessListEntry struct {
-	Address     string   `json:"address"`
-	StorageKeys []string `json:"storageKeys"`
+	Address     Address `json:"address"`
+	StorageKeys []Hash  `json:"storageKeys"`
+}
+
+// Authorization lets an account delegate its code to the contract at Address, EIP-7702
+type Authorization struct {
+	ChainID Quantity `json:"chainId"`
+	Address Address  `json:"address"`
+	Nonce   Quantity `json:"nonce"`
+	YParity Quantity `json:"yParity"`
+	R       Quantity `json:"r"`
+	S       Quantity `json:"s"`
+}
+
+// Returns the price per gas the transaction pays in a block with the given base fee:
+// its gas price, or for dynamic fee transactions and later types, the base fee plus
+// its priority fee up to its fee cap. Unset if the price depends on an unset base fee.
+func (tx Transaction) EffectiveGasPrice(baseFee Quantity) Quantity {
+	if !tx.MaxFeePerGas.IsSet() {
+		return tx.GasPrice
+	}
+	if !baseFee.IsSet() || !tx.MaxPriorityFeePerGas.IsSet() {
+		return Quantity{}
+	}
+	price := new(big.Int).Add(baseFee.Big(), tx.MaxPriorityFeePerGas.Big())
+	if price.Cmp(tx.MaxFeePerGas.Big()) > 0 {
+		return tx.MaxFeePerGas
+	}
+	return NewQuantity(price)
+}
+
+type Receipt struct {
+	TransactionHash   string `json:"transactionHash"`
+	TransactionIndex  string `json:"transactionIndex"`
+	BlockHash         string `json:"blockHash"`
+	BlockNumber       string `json:"blockNumber"`
+	From              string `json:"from"`
+	To                string `json:"to"`
+	Status            string `json:"status"`
+	GasUsed           string `json:"gasUsed"`
+	CumulativeGasUsed string `json:"cumulativeGasUsed"`
+	EffectiveGasPrice string `json:"effectiveGasPrice"`
+	ContractAddress   string `json:"contractAddress"`
+	Logs              []Log  `json:"logs"`
+	// blob transactions only
+	BlobGasUsed  string `json:"blobGasUsed,omitempty"`
+	BlobGasPrice string `json:"blobGasPrice,omitempty"`
+}
+
+// Reports whether the transaction was executed successfully, as opposed to reverted
+func (r Receipt) Succeeded() bool {
+	return r.Status == "0x1"
+}
+
+// Returns the fee the transaction paid in wei, for its gas and, if it is a blob
+// transaction, its blob gas. Unset if the receipt lacks the gas used or price.
+func (r Receipt) Fee() Quantity {
+	gasUsed, err := ParseQuantity(r.GasUsed)
+	if err != nil {
+		return Quantity{}
+	}
+	price, err := ParseQuantity(r.EffectiveGasPrice)
+	if err != nil {
+		return Quantity{}
+	}
+	fee := new(big.Int).Mul(gasUsed.v, price.v)
+
+	if r.BlobGasUsed != "" || r.BlobGasPrice != "" {
+		blobGasUsed, err := ParseQuantity(r.BlobGasUsed)
+		if err != nil {
+			return Quantity{}
+		}
+		blobGasPrice, err := ParseQuantity(r.BlobGasPrice)
+		if err != nil {
+			return Quantity{}
+		}
+		fee.Add(fee, new(big.Int).Mul(blobGasUsed.v, blobGasPrice.v))
+	}
+	return Quantity{v: fee}
+}
+
+type Log struct {
+	Address          string   `json:"address"`
+	Topics           []string `json:"topics"`
+	Data             string   `json:"data"`
+	BlockNumber      string   `json:"blockNumber"`
+	BlockHash        string   `json:"blockHash"`
+	TransactionHash  string   `json:"transactionHash"`
+	TransactionIndex string   `json:"transactionIndex"`
+	LogIndex         string   `json:"logIndex"`
+	Removed          bool     `json:"removed"`
+}
+
+// LogFilter selects logs for eth_getLogs. Either a block range or a block hash is set.
+// Topics match by position; a nil position matches any topic and multiple values match any of them.
+type LogFilter struct {
+	FromBlock string     `json:"fromBlock,omitempty"`
+	ToBlock   string     `json:"toBlock,omitempty"`
+	BlockHash string     `json:"blockHash,omitempty"`
+	Address   []string   `json:"address,omitempty"`
+	Topics    [][]string `json:"topics,omitempty"`
 }
 
-func New(endpoint string) *Client {
-	return &Client{
-		endpoint: endpoint,
+func New(url string, opts ...Option) *Client {
+	c := &Client{
+		endpoints:  []*endpoint{newEndpoint(url)},
+		httpClient: http.DefaultClient,
+		retry:      DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 func sendRPC[T any](
 	ctx context.Context,
-	endpoint string,
+	c Client,
 	rbody RequestBody,
 ) (ResponseBody[T], error) {
-	body, err := json.Marshal(rbody)
+	var responseBody ResponseBody[T]
+	err := c.do(ctx, isIdempotent(rbody.Method), func(e *endpoint) error {
+		responseBody = ResponseBody[T]{}
+		if err := c.post(ctx, e, rbody, &responseBody); err != nil {
+			return err
+		}
+		if responseBody.Error != nil {
+			return responseBody.Error
+		}
+		return nil
+	})
 	if err != nil {
-		return ResponseBody[T]{}, fmt.Errorf("error marshaling json: %v", err)
+		return ResponseBody[T]{}, err
+	}
+	return responseBody, nil
+}
+
+// Posts the JSON encoded payload to the endpoint and decodes the response into out.
+func (c Client) post(ctx context.Context, e *endpoint, payload interface{}, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshaling json: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", e.url, bytes.NewReader(body))
 	if err != nil {
-		return ResponseBody[T]{}, fmt.Errorf("error creating request: %v", err)
+		return fmt.Errorf("error creating request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	r, err := http.DefaultClient.Do(req)
+	r, err := c.httpClient.Do(req)
 	if err != nil {
-		return ResponseBody[T]{}, fmt.Errorf("error making request: %v", err)
+		return fmt.Errorf("error making request: %w", err)
 	}
 
+	defer r.Body.Close()
+
 	if r.StatusCode != http.StatusOK {
-		return ResponseBody[T]{}, fmt.Errorf("error response status code: %v", r.StatusCode)
+		return &HTTPError{
+			StatusCode: r.StatusCode,
+			RetryAfter: parseRetryAfter(r.Header.Get("Retry-After")),
+		}
 	}
 
-	var responseBody ResponseBody[T]
-
-	defer r.Body.Close()
-
-	if err := json.NewDecoder(r.Body).Decode(&responseBody); err != nil {
-		return ResponseBody[T]{}, fmt.Errorf("error decoding response body: %v", err)
+	if err := json.NewDecoder(r.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding response body: %w", err)
 	}
 
-	return responseBody, nil
+	return nil
 }
 
 // Returns the current block number.
 // It calls the JSON-RPC eth_blockNumber method.
 func (c Client) GetCurrentBlockNumber(ctx context.Context) (int, error) {
 	body := makeRequestBody(GetCurrentBlocknumberMethod, []string{})
-	res, err := sendRPC[string](ctx, c.endpoint, body)
+	res, err := sendRPC[Quantity](ctx, c, body)
 
 	if err != nil {
-		return 0, fmt.Errorf("error sending rpc: %v", err)
+		return 0, fmt.Errorf("error sending rpc: %w", err)
 	}
 
-	blocknumber, err := strconv.ParseInt(res.Result[2:], 16, 64)
+	blocknumber, err := res.Result.Int()
 	if err != nil {
-		return 0, fmt.Errorf("error parsing response body: %v", err)
+		return 0, fmt.Errorf("error parsing response body: %w", err)
 	}
 
-	return int(blocknumber), nil
+	return blocknumber, nil
+}
+
+// Returns the number of the block referenced by the given tag, e.g. "safe" or "finalized".
+// It calls the JSON-RPC eth_getBlockByNumber method without transaction bodies.
+func (c Client) GetBlockNumberByTag(ctx context.Context, tag string) (int, error) {
+	body := makeRequestBody(GetBlockByNumberMethod, []interface{}{tag, false})
+	res, err := sendRPC[*Header](ctx, c, body)
+
+	if err != nil {
+		return 0, fmt.Errorf("error sending rpc: %w", err)
+	}
+	if res.Result == nil {
+		return 0, fmt.Errorf("block %s: %w", tag, ErrBlockNotFound)
+	}
+
+	blocknumber, err := res.Result.Number.Int()
+	if err != nil {
+		return 0, fmt.Errorf("error parsing response body: %w", err)
+	}
+
+	return blocknumber, nil
 }
 
 // Returns the block information for the given block number.
 // It calls the JSON-RPC eth_getBlockByNumber method.
 func (c Client) GetBlockByNumber(ctx context.Context, blocknumber int) (Block, error) {
+	if c.quorum > 1 {
+		return c.getBlockByNumberQuorum(ctx, blocknumber)
+	}
+
 	body := makeRequestBody(
 		GetBlockByNumberMethod,
 		[]interface{}{fmt.Sprintf("0x%x", blocknumber), true},
 	)
-	res, err := sendRPC[Block](ctx, c.endpoint, body)
+	res, err := sendRPC[*Block](ctx, c, body)
 
 	if err != nil {
-		return Block{}, fmt.Errorf("error sending rpc: %v", err)
+		return Block{}, fmt.Errorf("error sending rpc: %w", err)
+	}
+	if res.Result == nil {
+		return Block{}, fmt.Errorf("block %d: %w", blocknumber, ErrBlockNotFound)
+	}
+
+	return *res.Result, nil
+}
+
+// Returns the header of the block with the given block number.
+// It calls the JSON-RPC eth_getBlockByNumber method without transaction bodies.
+func (c Client) GetHeaderByNumber(ctx context.Context, blocknumber int) (Header, error) {
+	body := makeRequestBody(
+		GetBlockByNumberMethod,
+		[]interface{}{fmt.Sprintf("0x%x", blocknumber), false},
+	)
+	res, err := sendRPC[*Header](ctx, c, body)
+
+	if err != nil {
+		return Header{}, fmt.Errorf("error sending rpc: %w", err)
+	}
+	if res.Result == nil {
+		return Header{}, fmt.Errorf("block %d: %w", blocknumber, ErrBlockNotFound)
+	}
+
+	return *res.Result, nil
+}
+
+// Returns the header of the block with the given hash, which may be a block no longer
+// on the canonical chain if the node still has it.
+// It calls the JSON-RPC eth_getBlockByHash method without transaction bodies.
+func (c Client) GetHeaderByHash(ctx context.Context, hash string) (Header, error) {
+	body := makeRequestBody(GetBlockByHashMethod, []interface{}{hash, false})
+	res, err := sendRPC[*Header](ctx, c, body)
+
+	if err != nil {
+		return Header{}, fmt.Errorf("error sending rpc: %w", err)
+	}
+	if res.Result == nil {
+		return Header{}, fmt.Errorf("block %s: %w", hash, ErrBlockNotFound)
+	}
+
+	return *res.Result, nil
+}
+
+// Returns the receipt of the transaction with the given hash.
+// It calls the JSON-RPC eth_getTransactionReceipt method.
+func (c Client) GetTransactionReceipt(ctx context.Context, hash string) (Receipt, error) {
+	body := makeRequestBody(GetTransactionReceiptMethod, []string{hash})
+	res, err := sendRPC[*Receipt](ctx, c, body)
+
+	if err != nil {
+		return Receipt{}, fmt.Errorf("error sending rpc: %w", err)
+	}
+	if res.Result == nil {
+		return Receipt{}, fmt.Errorf("transaction %s: %w", hash, ErrReceiptNotFound)
+	}
+
+	return *res.Result, nil
+}
+
+// Returns the receipts of all transactions in the block with the given block number.
+// It calls the JSON-RPC eth_getBlockReceipts method, which not every node supports;
+// the error matches ErrMethodNotFound if the node does not.
+func (c Client) GetBlockReceipts(ctx context.Context, blocknumber int) ([]Receipt, error) {
+	body := makeRequestBody(GetBlockReceiptsMethod, []string{fmt.Sprintf("0x%x", blocknumber)})
+	res, err := sendRPC[*[]Receipt](ctx, c, body)
+
+	if err != nil {
+		return nil, fmt.Errorf("error sending rpc: %w", err)
+	}
+	if res.Result == nil {
+		return nil, fmt.Errorf("block %d: %w", blocknumber, ErrBlockNotFound)
+	}
+
+	return *res.Result, nil
+}
+
+// Returns the logs matching the filter.
+// It calls the JSON-RPC eth_getLogs method.
+func (c Client) GetLogs(ctx context.Context, filter LogFilter) ([]Log, error) {
+	body := makeRequestBody(GetLogsMethod, []LogFilter{filter})
+	res, err := sendRPC[[]Log](ctx, c, body)
+
+	if err != nil {
+		return nil, fmt.Errorf("error sending rpc: %w", err)
 	}
 
 	return res.Result, nil
 }
 
+// Parses a 0x-prefixed hex quantity such as a block number.
+func ParseHexInt(s string) (int, error) {
+	if len(s) < 3 || s[:2] != "0x" {
+		return 0, fmt.Errorf("invalid hex quantity %q", s)
+	}
+	n, err := strconv.ParseInt(s[2:], 16, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
 func makeRequestBody(method string, params interface{}) RequestBody {
 	return RequestBody{
 		Jsonrpc: ApiVersion,
@@ -0,0 +1,43 @@
+package ethclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+func TestGetLogs(t *testing.T) {
+	var filter map[string]interface{}
+	server := newRPCServer(t, func(req rpcRequest) interface{} {
+		if req.Method != ethclient.GetLogsMethod {
+			t.Errorf("unexpected method %s", req.Method)
+		}
+		json.Unmarshal(req.Params[0], &filter)
+		return []ethclient.Log{{Address: "0xtoken", Topics: []string{"0xtopic"}, LogIndex: "0x0"}}
+	})
+	client := ethclient.New(server.URL)
+
+	logs, err := client.GetLogs(context.Background(), ethclient.LogFilter{
+		BlockHash: "0xblock",
+		Topics:    [][]string{{"0xtopic"}, nil, {"0xto"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(logs) != 1 || logs[0].Address != "0xtoken" {
+		t.Fatalf("unexpected logs: %+v", logs)
+	}
+
+	if filter["blockHash"] != "0xblock" {
+		t.Errorf("expected block hash in filter, got %v", filter)
+	}
+	if _, ok := filter["fromBlock"]; ok {
+		t.Errorf("expected unset block range to be omitted, got %v", filter)
+	}
+	topics, _ := filter["topics"].([]interface{})
+	if len(topics) != 3 || topics[1] != nil {
+		t.Errorf("expected wildcard topic to be null, got %v", filter["topics"])
+	}
+}
@@ -0,0 +1,89 @@
+package ethclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrQuorumMismatch is returned when endpoints queried in quorum mode disagree on a block
+var ErrQuorumMismatch = errors.New("endpoints disagree on block")
+
+type quorumResult struct {
+	url   string
+	block *Block
+	err   error
+}
+
+// Fetches the block from c.quorum endpoints and returns it only if all of them agree on its hash.
+// Endpoints that fail are replaced by the next healthiest ones while there are any left.
+func (c Client) getBlockByNumberQuorum(ctx context.Context, blocknumber int) (Block, error) {
+	body := makeRequestBody(
+		GetBlockByNumberMethod,
+		[]interface{}{fmt.Sprintf("0x%x", blocknumber), true},
+	)
+
+	endpoints := c.rankedEndpoints()
+	if len(endpoints) < c.quorum {
+		return Block{}, fmt.Errorf("quorum of %d needs at least as many endpoints, got %d", c.quorum, len(endpoints))
+	}
+
+	results := make(chan quorumResult)
+	query := func(e *endpoint) {
+		var res ResponseBody[*Block]
+		err := c.withRetry(ctx, true, func() error {
+			return c.callEndpoint(ctx, e, func(e *endpoint) error {
+				res = ResponseBody[*Block]{}
+				if err := c.post(ctx, e, body, &res); err != nil {
+					return err
+				}
+				if res.Error != nil {
+					return res.Error
+				}
+				return nil
+			})
+		})
+		results <- quorumResult{url: e.url, block: res.Result, err: err}
+	}
+
+	next := 0
+	for ; next < c.quorum; next++ {
+		go query(endpoints[next])
+	}
+
+	var agreed []quorumResult
+	var lastErr error
+	for pending := c.quorum; pending > 0; pending-- {
+		r := <-results
+		switch {
+		case r.err != nil:
+			lastErr = r.err
+		case r.block == nil:
+			lastErr = fmt.Errorf("block %d from %s: %w", blocknumber, r.url, ErrBlockNotFound)
+		default:
+			agreed = append(agreed, r)
+			continue
+		}
+
+		// replace the failed endpoint while there are endpoints left
+		if next < len(endpoints) {
+			go query(endpoints[next])
+			next++
+			pending++
+		}
+	}
+
+	if len(agreed) < c.quorum {
+		return Block{}, fmt.Errorf("only %d of %d endpoints returned block %d: %w", len(agreed), c.quorum, blocknumber, lastErr)
+	}
+
+	for _, r := range agreed[1:] {
+		if r.block.Hash != agreed[0].block.Hash {
+			return Block{}, fmt.Errorf(
+				"block %d: %s returned %s, %s returned %s: %w",
+				blocknumber, agreed[0].url, agreed[0].block.Hash, r.url, r.block.Hash, ErrQuorumMismatch,
+			)
+		}
+	}
+	return *agreed[0].block, nil
+}
@@ -0,0 +1,113 @@
+package ethclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Starts a server answering single JSON-RPC calls with handle
+func newRPCServer(t *testing.T, handle func(req rpcRequest) interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req rpcRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": handle(req)})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetTransactionReceipt(t *testing.T) {
+	server := newRPCServer(t, func(req rpcRequest) interface{} {
+		if req.Method != ethclient.GetTransactionReceiptMethod {
+			t.Errorf("unexpected method %s", req.Method)
+		}
+		var hash string
+		json.Unmarshal(req.Params[0], &hash)
+		if hash == "0xpending" {
+			return nil
+		}
+		return ethclient.Receipt{
+			TransactionHash:   hash,
+			Status:            "0x0",
+			GasUsed:           "0x5208",
+			EffectiveGasPrice: "0x3b9aca00",
+			Logs:              []ethclient.Log{{Address: "0xtoken", Topics: []string{"0xtopic"}}},
+		}
+	})
+	client := ethclient.New(server.URL)
+
+	receipt, err := client.GetTransactionReceipt(context.Background(), "0x01")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if receipt.TransactionHash != "0x01" || receipt.GasUsed != "0x5208" || len(receipt.Logs) != 1 {
+		t.Fatalf("unexpected receipt: %+v", receipt)
+	}
+	if receipt.Succeeded() {
+		t.Fatal("expected reverted transaction not to succeed")
+	}
+
+	if _, err := client.GetTransactionReceipt(context.Background(), "0xpending"); !errors.Is(err, ethclient.ErrReceiptNotFound) {
+		t.Fatalf("expected ErrReceiptNotFound for pending transaction, got %v", err)
+	}
+}
+
+func TestReceiptFee(t *testing.T) {
+	tests := []struct {
+		name    string
+		receipt ethclient.Receipt
+		want    string
+	}{
+		{"transfer", ethclient.Receipt{GasUsed: "0x5208", EffectiveGasPrice: "0x3b9aca00"}, "0x1319718a5000"},
+		{
+			name:    "blob",
+			receipt: ethclient.Receipt{GasUsed: "0x5208", EffectiveGasPrice: "0x2", BlobGasUsed: "0x20000", BlobGasPrice: "0x3"},
+			want:    "0x6a410",
+		},
+		{"missing price", ethclient.Receipt{GasUsed: "0x5208"}, ""},
+		{"missing blob gas price", ethclient.Receipt{GasUsed: "0x5208", EffectiveGasPrice: "0x2", BlobGasUsed: "0x20000"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.receipt.Fee().String(); got != tt.want {
+				t.Fatalf("expected fee %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetBlockReceipts(t *testing.T) {
+	server := newRPCServer(t, func(req rpcRequest) interface{} {
+		if req.Method != ethclient.GetBlockReceiptsMethod {
+			t.Errorf("unexpected method %s", req.Method)
+		}
+		return []ethclient.Receipt{
+			{TransactionHash: "0x01", Status: "0x1"},
+			{TransactionHash: "0x02", Status: "0x1", ContractAddress: "0xcontract"},
+		}
+	})
+	client := ethclient.New(server.URL)
+
+	receipts, err := client.GetBlockReceipts(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(receipts) != 2 || receipts[1].ContractAddress != "0xcontract" {
+		t.Fatalf("unexpected receipts: %+v", receipts)
+	}
+}
+
+func TestGetBlockReceiptsUnsupported(t *testing.T) {
+	server := newErrorServer(t, map[string]interface{}{"code": -32601, "message": "the method eth_getBlockReceipts does not exist"})
+	client := ethclient.New(server.URL, noRetry)
+
+	if _, err := client.GetBlockReceipts(context.Background(), 1); !errors.Is(err, ethclient.ErrMethodNotFound) {
+		t.Fatalf("expected ErrMethodNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package ethclient
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed calls of idempotent methods are retried.
+// Rate limited calls, HTTP 5xx responses and network errors are retried with
+// exponential backoff and jitter, honouring the Retry-After header when the node sends one,
+// up to MaxBackoff.
+type RetryPolicy struct {
+	// Maximum number of attempts per call, including the first. 1 disables retries.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 250 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+}
+
+// Methods that only read chain state and are therefore safe to retry
+var idempotentMethods = map[string]bool{
+	GetCurrentBlocknumberMethod: true,
+	GetBlockByNumberMethod:      true,
+	GetBlockByHashMethod:        true,
+	GetTransactionReceiptMethod: true,
+	GetBlockReceiptsMethod:      true,
+	GetLogsMethod:               true,
+	TraceBlockByNumberMethod:    true,
+	TraceBlockMethod:            true,
+	TraceFilterMethod:           true,
+	GetTransactionByHashMethod:  true,
+}
+
+func isIdempotent(methods ...string) bool {
+	for _, method := range methods {
+		if !idempotentMethods[method] {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether a failed call may succeed when retried: rate limiting, HTTP 5xx responses
+// and network errors are transient, other errors are not
+func IsRetryable(err error) bool {
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	// transport errors such as refused or reset connections
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// Returns how long to wait before the next attempt, after the given number of failed attempts
+func (p RetryPolicy) backoff(attempt int, err error) time.Duration {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+		// a node asking for a longer wait than the policy allows is retried at its maximum
+		return min(httpErr.RetryAfter, p.MaxBackoff)
+	}
+
+	backoff := p.MaxBackoff
+	if shift := attempt - 1; shift < 32 {
+		backoff = min(p.InitialBackoff<<shift, p.MaxBackoff)
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	// equal jitter: wait between half and the full backoff
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
+
+// Runs attempt until it succeeds, fails with a non-retryable error or the policy gives up.
+// Calls of methods that are not idempotent are attempted only once.
+func (c Client) withRetry(ctx context.Context, idempotent bool, attempt func() error) error {
+	for i := 1; ; i++ {
+		err := attempt()
+		if err == nil || !idempotent || i >= c.retry.MaxAttempts || ctx.Err() != nil || !IsRetryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(c.retry.backoff(i, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Parses a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
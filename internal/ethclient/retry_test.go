@@ -0,0 +1,210 @@
+package ethclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var fastRetry = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+}
+
+// Starts a server whose handler receives the 1-based attempt number of each request
+func newFlakyServer(t *testing.T, handle func(attempt int, w http.ResponseWriter, id int)) (*httptest.Server, *atomic.Int32) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req RequestBody
+		json.NewDecoder(r.Body).Decode(&req)
+		handle(int(attempts.Add(1)), w, req.ID)
+	}))
+	t.Cleanup(server.Close)
+	return server, &attempts
+}
+
+func writeResult(w http.ResponseWriter, id int, result interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": id, "result": result})
+}
+
+func writeError(w http.ResponseWriter, id int, code int, message string) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"error":   map[string]interface{}{"code": code, "message": message},
+	})
+}
+
+func TestRetryServerErrors(t *testing.T) {
+	server, attempts := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		if attempt < 3 {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		writeResult(w, id, "0x10")
+	})
+
+	client := New(server.URL, WithRetryPolicy(fastRetry))
+	blocknumber, err := client.GetCurrentBlockNumber(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if blocknumber != 16 {
+		t.Fatalf("expected block 16, got %d", blocknumber)
+	}
+	if attempts.Load() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts.Load())
+	}
+}
+
+func TestRetryRateLimitedRPCError(t *testing.T) {
+	server, attempts := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		if attempt == 1 {
+			writeError(w, id, CodeLimitExceeded, "limit exceeded")
+			return
+		}
+		writeResult(w, id, "0x10")
+	})
+
+	client := New(server.URL, WithRetryPolicy(fastRetry))
+	if _, err := client.GetCurrentBlockNumber(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts.Load() != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts.Load())
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	server, attempts := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	client := New(server.URL, WithRetryPolicy(fastRetry))
+	_, err := client.GetCurrentBlockNumber(context.Background())
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected last HTTP error to be returned, got %v", err)
+	}
+	if attempts.Load() != int32(fastRetry.MaxAttempts) {
+		t.Fatalf("expected %d attempts, got %d", fastRetry.MaxAttempts, attempts.Load())
+	}
+}
+
+func TestNoRetryOnPermanentErrors(t *testing.T) {
+	server, attempts := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		writeError(w, id, CodeMethodNotFound, "method not found")
+	})
+
+	client := New(server.URL, WithRetryPolicy(fastRetry))
+	if _, err := client.GetCurrentBlockNumber(context.Background()); !errors.Is(err, ErrMethodNotFound) {
+		t.Fatalf("expected ErrMethodNotFound, got %v", err)
+	}
+	if attempts.Load() != 1 {
+		t.Fatalf("expected 1 attempt, got %d", attempts.Load())
+	}
+}
+
+func TestNoRetryForNonIdempotentMethods(t *testing.T) {
+	server, attempts := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	client := New(server.URL, WithRetryPolicy(fastRetry))
+	_, err := sendRPC[string](context.Background(), *client, makeRequestBody("eth_sendRawTransaction", []string{"0x00"}))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if attempts.Load() != 1 {
+		t.Fatalf("expected non-idempotent call to be attempted once, got %d", attempts.Load())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	server, attempts := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		if attempt == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		writeResult(w, id, "0x10")
+	})
+
+	client := New(server.URL, WithRetryPolicy(RetryPolicy{MaxAttempts: 2, MaxBackoff: 2 * time.Second}))
+	start := time.Now()
+	if _, err := client.GetCurrentBlockNumber(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("expected retry to wait for Retry-After, waited %v", elapsed)
+	}
+	if attempts.Load() != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts.Load())
+	}
+}
+
+func TestRetryContextCancelled(t *testing.T) {
+	server, _ := newFlakyServer(t, func(attempt int, w http.ResponseWriter, id int) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	client := New(server.URL, WithRetryPolicy(RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: time.Hour,
+		MaxBackoff:     time.Hour,
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.GetCurrentBlockNumber(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected backoff to stop when the context is done, got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt := 1; attempt <= 10; attempt++ {
+		want := min(policy.InitialBackoff<<(attempt-1), policy.MaxBackoff)
+		got := policy.backoff(attempt, errors.New("error"))
+		if got < want/2 || got > want {
+			t.Fatalf("expected backoff for attempt %d between %v and %v, got %v", attempt, want/2, want, got)
+		}
+	}
+
+	if got := policy.backoff(1, &HTTPError{StatusCode: 429, RetryAfter: 700 * time.Millisecond}); got != 700*time.Millisecond {
+		t.Fatalf("expected Retry-After to override backoff, got %v", got)
+	}
+	if got := policy.backoff(1, &HTTPError{StatusCode: 429, RetryAfter: time.Hour}); got != policy.MaxBackoff {
+		t.Fatalf("expected Retry-After to be capped at the maximum backoff, got %v", got)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(100, 5)
+
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the burst is free, the remaining 10 requests take at least 100ms at 100 requests per second
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("expected limiter to throttle requests, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := newRateLimiter(0.001, 1)
+	slow.wait(ctx)
+	if err := slow.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected wait to stop when the context is done, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package ethclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zihaolam/ethereum-parser/internal/websocket"
+)
+
+const (
+	SubscribeMethod    = "eth_subscribe"
+	SubscriptionMethod = "eth_subscription"
+	NewHeadsEvent      = "newHeads"
+)
+
+// Interval at which the subscription's connection is pinged. The subscription ends once
+// the node sent nothing, not even a pong, for twice as long.
+const keepAliveInterval = 20 * time.Second
+
+// HeadSubscription delivers new chain heads pushed by the node over a WebSocket
+type HeadSubscription struct {
+	conn  *websocket.Conn
+	heads chan Header
+	// error that ended the subscription, set before heads is closed
+	err  error
+	once sync.Once
+}
+
+type subscriptionNotification struct {
+	Method string `json:"method"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+// Subscribes to new chain heads with eth_subscribe("newHeads") over the WebSocket endpoint.
+// The subscription ends when ctx is done, Close is called or the connection drops or stalls.
+func SubscribeNewHeads(ctx context.Context, url string) (*HeadSubscription, error) {
+	conn, err := websocket.Dial(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing websocket: %w", err)
+	}
+
+	id, err := subscribe(conn, NewHeadsEvent)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.KeepAlive(keepAliveInterval)
+
+	sub := &HeadSubscription{
+		conn:  conn,
+		heads: make(chan Header, 16),
+	}
+	stop := context.AfterFunc(ctx, func() { sub.Close() })
+	go func() {
+		defer stop()
+		sub.run(id)
+	}()
+	return sub, nil
+}
+
+// Sends eth_subscribe and returns the subscription id from the node's response
+func subscribe(conn *websocket.Conn, event string) (string, error) {
+	body := makeRequestBody(SubscribeMethod, []string{event})
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling json: %w", err)
+	}
+	if err := conn.WriteMessage(payload); err != nil {
+		return "", fmt.Errorf("error sending subscription request: %w", err)
+	}
+
+	// notifications cannot arrive before the subscription id, so the first response is ours
+	message, err := conn.ReadMessage()
+	if err != nil {
+		return "", fmt.Errorf("error reading subscription response: %w", err)
+	}
+
+	var res ResponseBody[string]
+	if err := json.Unmarshal(message, &res); err != nil {
+		return "", fmt.Errorf("error decoding subscription response: %w", err)
+	}
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.ID != body.ID || res.Result == "" {
+		return "", fmt.Errorf("unexpected subscription response: %s", message)
+	}
+	return res.Result, nil
+}
+
+// Reads notifications until the connection fails or is closed
+func (s *HeadSubscription) run(id string) {
+	defer close(s.heads)
+	// also stops the keepalive pings of a connection that failed
+	defer s.Close()
+	for {
+		message, err := s.conn.ReadMessage()
+		if err != nil {
+			s.err = err
+			return
+		}
+
+		var notification subscriptionNotification
+		if err := json.Unmarshal(message, &notification); err != nil {
+			continue
+		}
+		if notification.Method != SubscriptionMethod || notification.Params.Subscription != id {
+			continue
+		}
+
+		var header Header
+		if err := json.Unmarshal(notification.Params.Result, &header); err != nil {
+			continue
+		}
+
+		select {
+		case s.heads <- header:
+		default:
+			// the consumer is behind, it only needs to know that the head moved
+		}
+	}
+}
+
+// Returns the channel of new heads. It is closed when the subscription ends.
+func (s *HeadSubscription) Heads() <-chan Header {
+	return s.heads
+}
+
+// Returns the error that ended the subscription. Only valid once the heads channel is closed.
+func (s *HeadSubscription) Err() error {
+	return s.err
+}
+
+// Ends the subscription and closes the connection
+func (s *HeadSubscription) Close() error {
+	var err error
+	s.once.Do(func() {
+		err = s.conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,119 @@
+package ethclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/websocket"
+)
+
+// Starts a WebSocket server that runs handle for every connection
+func newWebSocketServer(t *testing.T, handle func(conn *websocket.Conn)) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func writeJSON(conn *websocket.Conn, v interface{}) {
+	b, _ := json.Marshal(v)
+	conn.WriteMessage(b)
+}
+
+func notification(subscription string, header ethclient.Header) map[string]interface{} {
+	return map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  ethclient.SubscriptionMethod,
+		"params":  map[string]interface{}{"subscription": subscription, "result": header},
+	}
+}
+
+func TestSubscribeNewHeads(t *testing.T) {
+	url := newWebSocketServer(t, func(conn *websocket.Conn) {
+		message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req rpcRequest
+		json.Unmarshal(message, &req)
+		if req.Method != ethclient.SubscribeMethod {
+			return
+		}
+		writeJSON(conn, map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": "0xsub"})
+
+		for i := 1; i <= 3; i++ {
+			writeJSON(conn, notification("0xsub", ethclient.Header{Number: ethclient.IntQuantity(i)}))
+			// notifications for other subscriptions are ignored
+			writeJSON(conn, notification("0xother", ethclient.Header{Number: ethclient.IntQuantity(0xff)}))
+		}
+	})
+
+	sub, err := ethclient.SubscribeNewHeads(context.Background(), url)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sub.Close()
+
+	var numbers []string
+	for header := range sub.Heads() {
+		numbers = append(numbers, header.Number.String())
+	}
+
+	if strings.Join(numbers, ",") != "0x1,0x2,0x3" {
+		t.Fatalf("expected heads 0x1,0x2,0x3, got %v", numbers)
+	}
+	if sub.Err() == nil {
+		t.Fatal("expected error once the server closed the connection")
+	}
+}
+
+func TestSubscribeNewHeadsError(t *testing.T) {
+	url := newWebSocketServer(t, func(conn *websocket.Conn) {
+		message, _ := conn.ReadMessage()
+		var req rpcRequest
+		json.Unmarshal(message, &req)
+		writeJSON(conn, map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+			"error":   map[string]interface{}{"code": -32601, "message": "notifications not supported"},
+		})
+	})
+
+	_, err := ethclient.SubscribeNewHeads(context.Background(), url)
+	if !errors.Is(err, ethclient.ErrMethodNotFound) {
+		t.Fatalf("expected ErrMethodNotFound, got %v", err)
+	}
+}
+
+func TestSubscribeNewHeadsCancel(t *testing.T) {
+	url := newWebSocketServer(t, func(conn *websocket.Conn) {
+		message, _ := conn.ReadMessage()
+		var req rpcRequest
+		json.Unmarshal(message, &req)
+		writeJSON(conn, map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": "0xsub"})
+		// keep the connection open until the client goes away
+		conn.ReadMessage()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := ethclient.SubscribeNewHeads(ctx, url)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cancel()
+	for range sub.Heads() {
+	}
+}
@@ -0,0 +1,125 @@
+package ethclient
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	TraceBlockByNumberMethod = "debug_traceBlockByNumber"
+	TraceBlockMethod         = "trace_block"
+	TraceFilterMethod        = "trace_filter"
+
+	// geth's built-in tracer returning the call tree of each transaction
+	CallTracer = "callTracer"
+)
+
+// CallFrame is a call in the call tree returned by geth's callTracer.
+// Type is the EVM opcode that made the call, e.g. CALL, DELEGATECALL or CREATE2.
+type CallFrame struct {
+	Type    string      `json:"type"`
+	From    string      `json:"from"`
+	To      string      `json:"to"`
+	Value   string      `json:"value"`
+	Gas     string      `json:"gas"`
+	GasUsed string      `json:"gasUsed"`
+	Input   string      `json:"input"`
+	Output  string      `json:"output"`
+	Error   string      `json:"error"`
+	Calls   []CallFrame `json:"calls"`
+}
+
+// TransactionTrace is the call tree of one transaction of a traced block.
+// TxHash is only returned by newer nodes.
+type TransactionTrace struct {
+	TxHash string    `json:"txHash"`
+	Result CallFrame `json:"result"`
+}
+
+// Trace is an entry of the flat trace list returned by trace_block on Erigon, Nethermind
+// and OpenEthereum. TraceAddress is the path of the call from the top-level call.
+type Trace struct {
+	Type                string       `json:"type"`
+	Action              TraceAction  `json:"action"`
+	Result              *TraceResult `json:"result"`
+	Error               string       `json:"error"`
+	Subtraces           int          `json:"subtraces"`
+	TraceAddress        []int        `json:"traceAddress"`
+	TransactionHash     string       `json:"transactionHash"`
+	TransactionPosition int          `json:"transactionPosition"`
+	BlockHash           string       `json:"blockHash"`
+	BlockNumber         int          `json:"blockNumber"`
+}
+
+// TraceAction holds the fields of a call, create or suicide trace. Calls set CallType,
+// suicides set Address, RefundAddress and Balance instead of From, To and Value.
+type TraceAction struct {
+	CallType      string `json:"callType"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+	Value         string `json:"value"`
+	Gas           string `json:"gas"`
+	Input         string `json:"input"`
+	Init          string `json:"init"`
+	Address       string `json:"address"`
+	RefundAddress string `json:"refundAddress"`
+	Balance       string `json:"balance"`
+}
+
+type TraceResult struct {
+	GasUsed string `json:"gasUsed"`
+	Output  string `json:"output"`
+	// address of the created contract, for create traces
+	Address string `json:"address"`
+}
+
+// TraceFilter selects traces for trace_filter by block range and by the addresses
+// that made or received the call.
+type TraceFilter struct {
+	FromBlock   string   `json:"fromBlock,omitempty"`
+	ToBlock     string   `json:"toBlock,omitempty"`
+	FromAddress []string `json:"fromAddress,omitempty"`
+	ToAddress   []string `json:"toAddress,omitempty"`
+}
+
+// Returns the call tree of every transaction in the block with the given block number, in order.
+// It calls the JSON-RPC debug_traceBlockByNumber method with the callTracer.
+func (c Client) TraceBlockByNumber(ctx context.Context, blocknumber int) ([]TransactionTrace, error) {
+	body := makeRequestBody(
+		TraceBlockByNumberMethod,
+		[]interface{}{fmt.Sprintf("0x%x", blocknumber), map[string]string{"tracer": CallTracer}},
+	)
+	res, err := sendRPC[[]TransactionTrace](ctx, c, body)
+
+	if err != nil {
+		return nil, fmt.Errorf("error sending rpc: %w", err)
+	}
+
+	return res.Result, nil
+}
+
+// Returns the flattened traces of every transaction in the block with the given block number.
+// It calls the JSON-RPC trace_block method.
+func (c Client) TraceBlock(ctx context.Context, blocknumber int) ([]Trace, error) {
+	body := makeRequestBody(TraceBlockMethod, []string{fmt.Sprintf("0x%x", blocknumber)})
+	res, err := sendRPC[[]Trace](ctx, c, body)
+
+	if err != nil {
+		return nil, fmt.Errorf("error sending rpc: %w", err)
+	}
+
+	return res.Result, nil
+}
+
+// Returns the traces matching the filter, including the top-level call of each transaction.
+// It calls the JSON-RPC trace_filter method, available on Erigon, Nethermind and OpenEthereum.
+func (c Client) TraceFilter(ctx context.Context, filter TraceFilter) ([]Trace, error) {
+	body := makeRequestBody(TraceFilterMethod, []TraceFilter{filter})
+	res, err := sendRPC[[]Trace](ctx, c, body)
+
+	if err != nil {
+		return nil, fmt.Errorf("error sending rpc: %w", err)
+	}
+
+	return res.Result, nil
+}
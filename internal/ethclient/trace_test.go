@@ -0,0 +1,101 @@
+package ethclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+func TestTraceBlockByNumber(t *testing.T) {
+	var config map[string]string
+	server := newRPCServer(t, func(req rpcRequest) interface{} {
+		if req.Method != ethclient.TraceBlockByNumberMethod {
+			t.Errorf("unexpected method %s", req.Method)
+		}
+		json.Unmarshal(req.Params[1], &config)
+		return json.RawMessage(`[{
+			"txHash": "0x01",
+			"result": {
+				"type": "CALL", "from": "0xa", "to": "0xb", "value": "0x0",
+				"calls": [{"type": "CALL", "from": "0xb", "to": "0xc", "value": "0x10"}]
+			}
+		}]`)
+	})
+	client := ethclient.New(server.URL)
+
+	traces, err := client.TraceBlockByNumber(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config["tracer"] != ethclient.CallTracer {
+		t.Errorf("expected callTracer to be requested, got %v", config)
+	}
+	if len(traces) != 1 || traces[0].TxHash != "0x01" {
+		t.Fatalf("unexpected traces: %+v", traces)
+	}
+	calls := traces[0].Result.Calls
+	if len(calls) != 1 || calls[0].To != "0xc" || calls[0].Value != "0x10" {
+		t.Fatalf("unexpected nested calls: %+v", calls)
+	}
+}
+
+func TestTraceBlock(t *testing.T) {
+	server := newRPCServer(t, func(req rpcRequest) interface{} {
+		if req.Method != ethclient.TraceBlockMethod {
+			t.Errorf("unexpected method %s", req.Method)
+		}
+		return json.RawMessage(`[
+			{"type": "call", "action": {"callType": "call", "from": "0xa", "to": "0xb", "value": "0x0"},
+			 "result": {"gasUsed": "0x0", "output": "0x"}, "subtraces": 1, "traceAddress": [], "transactionHash": "0x01"},
+			{"type": "suicide", "action": {"address": "0xb", "refundAddress": "0xc", "balance": "0x10"},
+			 "result": null, "subtraces": 0, "traceAddress": [0], "transactionHash": "0x01"}
+		]`)
+	})
+	client := ethclient.New(server.URL)
+
+	traces, err := client.TraceBlock(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(traces) != 2 {
+		t.Fatalf("expected 2 traces, got %d", len(traces))
+	}
+	if traces[1].Type != "suicide" || traces[1].Action.RefundAddress != "0xc" || len(traces[1].TraceAddress) != 1 {
+		t.Fatalf("unexpected trace: %+v", traces[1])
+	}
+}
+
+func TestTraceFilter(t *testing.T) {
+	var filter map[string]interface{}
+	server := newRPCServer(t, func(req rpcRequest) interface{} {
+		if req.Method != ethclient.TraceFilterMethod {
+			t.Errorf("unexpected method %s", req.Method)
+		}
+		json.Unmarshal(req.Params[0], &filter)
+		return json.RawMessage(`[
+			{"type": "call", "action": {"callType": "call", "from": "0xa", "to": "0xb", "value": "0x1"},
+			 "traceAddress": [], "transactionHash": "0x01", "blockNumber": 5, "blockHash": "0xblock"}
+		]`)
+	})
+	client := ethclient.New(server.URL)
+
+	traces, err := client.TraceFilter(context.Background(), ethclient.TraceFilter{
+		FromBlock: "0x1",
+		ToBlock:   "0x5",
+		ToAddress: []string{"0xb"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(traces) != 1 || traces[0].BlockNumber != 5 || traces[0].TransactionHash != "0x01" {
+		t.Fatalf("unexpected traces: %+v", traces)
+	}
+	if _, ok := filter["fromAddress"]; ok {
+		t.Errorf("expected unset address filter to be omitted, got %v", filter)
+	}
+	if filter["fromBlock"] != "0x1" || filter["toBlock"] != "0x5" {
+		t.Errorf("expected block range in filter, got %v", filter)
+	}
+}
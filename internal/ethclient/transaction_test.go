@@ -0,0 +1,79 @@
+package ethclient_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+func TestTransactionJSON(t *testing.T) {
+	// a blob transaction as returned by eth_getTransactionByHash
+	const node = `{"type":"0x3","chainId":"0x1","blockNumber":"0x12a4f6e",` +
+		`"blockHash":"0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2",` +
+		`"hash":"0x5ceec39b631763ae0b45a8fb55c373f38b8fab308336ca1dc90ecd2b3cf06d00","nonce":"0x15",` +
+		`"from":"0xa7d9ddbe1f17865597fbd27ec712455208b6b76d","to":"0xf02c1c8e6114b1dbe8937a39260b5b0a374432bb",` +
+		`"value":"0x0","gas":"0x5208","gasPrice":"0x3b9aca0e","maxFeePerGas":"0x77359400","maxPriorityFeePerGas":"0xe",` +
+		`"maxFeePerBlobGas":"0x3b9aca00",` +
+		`"blobVersionedHashes":["0x01a915e4d060149eb4365960e6a7a45f334393093061116b197e3240065ff2d8"],"input":"0x",` +
+		`"accessList":[{"address":"0x0000000000000000000000000000000000000b0b",` +
+		`"storageKeys":["0x0000000000000000000000000000000000000000000000000000000000000001"]}],` +
+		`"authorizationList":null,"v":"0x1","r":"0x1f","s":"0x2e","yParity":"0x1","transactionIndex":"0x3",` +
+		`"blockTimestamp":"0x6655c1e3"}`
+
+	var tx ethclient.Transaction
+	if err := json.Unmarshal([]byte(node), &tx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, _ := tx.Type.Int(); n != ethclient.BlobTxType {
+		t.Errorf("expected blob transaction type, got %s", tx.Type)
+	}
+	if len(tx.BlobVersionedHashes) != 1 || len(tx.AccessList) != 1 || len(tx.AccessList[0].StorageKeys) != 1 {
+		t.Errorf("expected blob hashes and access list to be decoded, got %+v", tx)
+	}
+	if !tx.MaxFeePerBlobGas.IsSet() || !tx.YParity.IsSet() || !tx.R.IsSet() {
+		t.Errorf("expected fee caps and signature to be decoded, got %+v", tx)
+	}
+
+	b, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got, want map[string]interface{}
+	json.Unmarshal(b, &got)
+	json.Unmarshal([]byte(node), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %s, got %s", node, b)
+	}
+}
+
+func TestEffectiveGasPrice(t *testing.T) {
+	legacy := ethclient.Transaction{GasPrice: ethclient.IntQuantity(130)}
+	dynamic := ethclient.Transaction{
+		Type:                 ethclient.IntQuantity(ethclient.DynamicFeeTxType),
+		GasPrice:             ethclient.IntQuantity(150),
+		MaxFeePerGas:         ethclient.IntQuantity(150),
+		MaxPriorityFeePerGas: ethclient.IntQuantity(20),
+	}
+
+	tests := []struct {
+		name    string
+		tx      ethclient.Transaction
+		baseFee ethclient.Quantity
+		want    ethclient.Quantity
+	}{
+		{"legacy", legacy, ethclient.IntQuantity(100), ethclient.IntQuantity(130)},
+		{"legacy before London", legacy, ethclient.Quantity{}, ethclient.IntQuantity(130)},
+		{"base fee plus tip", dynamic, ethclient.IntQuantity(100), ethclient.IntQuantity(120)},
+		{"capped", dynamic, ethclient.IntQuantity(140), ethclient.IntQuantity(150)},
+		{"unknown base fee", dynamic, ethclient.Quantity{}, ethclient.Quantity{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tx.EffectiveGasPrice(tt.baseFee); got.Cmp(tt.want) != 0 {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
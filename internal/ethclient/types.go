@@ -0,0 +1,213 @@
+package ethclient
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrInvalidQuantity = errors.New("invalid quantity")
+	ErrInvalidHash     = errors.New("invalid hash")
+	ErrInvalidData     = errors.New("invalid data")
+)
+
+// Quantity is an unsigned integer, encoded in JSON-RPC as 0x-prefixed hex without
+// leading zeros. The zero value is unset, as for a field the node returned as null.
+type Quantity struct {
+	v *big.Int
+}
+
+// Returns a quantity holding a copy of n, or an unset quantity if n is nil
+func NewQuantity(n *big.Int) Quantity {
+	if n == nil {
+		return Quantity{}
+	}
+	return Quantity{v: new(big.Int).Set(n)}
+}
+
+// Returns a quantity holding n
+func IntQuantity(n int) Quantity {
+	return Quantity{v: big.NewInt(int64(n))}
+}
+
+// Parses a JSON-RPC quantity: 0x followed by at least one hex digit and no leading zeros
+func ParseQuantity(s string) (Quantity, error) {
+	digits, ok := strings.CutPrefix(s, "0x")
+	if !ok || digits == "" || (len(digits) > 1 && digits[0] == '0') {
+		return Quantity{}, fmt.Errorf("%w: %q", ErrInvalidQuantity, s)
+	}
+	if strings.ContainsFunc(digits, func(r rune) bool { return !isHexDigit(r) }) {
+		return Quantity{}, fmt.Errorf("%w: %q", ErrInvalidQuantity, s)
+	}
+	v, _ := new(big.Int).SetString(digits, 16)
+	return Quantity{v: v}, nil
+}
+
+func isHexDigit(r rune) bool {
+	return '0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F'
+}
+
+// Reports whether the quantity holds a value
+func (q Quantity) IsSet() bool {
+	return q.v != nil
+}
+
+// Returns a copy of the value, or nil if the quantity is unset
+func (q Quantity) Big() *big.Int {
+	if q.v == nil {
+		return nil
+	}
+	return new(big.Int).Set(q.v)
+}
+
+// Returns the value as an int, such as a block number or transaction index
+func (q Quantity) Int() (int, error) {
+	if q.v == nil {
+		return 0, fmt.Errorf("%w: unset", ErrInvalidQuantity)
+	}
+	if !q.v.IsInt64() || q.v.Int64() != int64(int(q.v.Int64())) {
+		return 0, fmt.Errorf("%w: %s overflows int", ErrInvalidQuantity, q)
+	}
+	return int(q.v.Int64()), nil
+}
+
+// Compares the quantities as x.Cmp(y) does, ordering unset before any value
+func (q Quantity) Cmp(o Quantity) int {
+	switch {
+	case q.v == nil && o.v == nil:
+		return 0
+	case q.v == nil:
+		return -1
+	case o.v == nil:
+		return 1
+	}
+	return q.v.Cmp(o.v)
+}
+
+// Returns the JSON-RPC encoding of the quantity, or "" if it is unset
+func (q Quantity) String() string {
+	if q.v == nil {
+		return ""
+	}
+	return "0x" + q.v.Text(16)
+}
+
+func (q Quantity) MarshalJSON() ([]byte, error) {
+	if q.v == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(q.String())
+}
+
+func (q *Quantity) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		*q = Quantity{}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseQuantity(s)
+	if err != nil {
+		return err
+	}
+	*q = parsed
+	return nil
+}
+
+// Hash is a 32 byte Keccak-256 hash, such as a block or transaction hash
+type Hash [32]byte
+
+// Parses a 0x-prefixed 64 digit hex hash
+func ParseHash(s string) (Hash, error) {
+	var h Hash
+	if len(s) != 66 || !strings.HasPrefix(s, "0x") {
+		return h, fmt.Errorf("%w: %q", ErrInvalidHash, s)
+	}
+	if _, err := hex.Decode(h[:], []byte(s[2:])); err != nil {
+		return h, fmt.Errorf("%w: %q", ErrInvalidHash, s)
+	}
+	return h, nil
+}
+
+// Returns the lowercase hex encoding of the hash
+func (h Hash) Hex() string {
+	return "0x" + hex.EncodeToString(h[:])
+}
+
+func (h Hash) String() string {
+	return h.Hex()
+}
+
+// Reports whether the hash is all zeros, as the parent hash of the genesis block is
+func (h Hash) IsZero() bool {
+	return h == Hash{}
+}
+
+func (h Hash) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.Hex())
+}
+
+func (h *Hash) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseHash(s)
+	if err != nil {
+		return err
+	}
+	*h = parsed
+	return nil
+}
+
+// Data is an arbitrary byte string, encoded in JSON-RPC as 0x-prefixed hex with
+// two digits per byte
+type Data []byte
+
+// Parses 0x-prefixed hex with an even number of digits
+func ParseData(s string) (Data, error) {
+	digits, ok := strings.CutPrefix(s, "0x")
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidData, s)
+	}
+	d, err := hex.DecodeString(digits)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidData, s)
+	}
+	return d, nil
+}
+
+// Returns the lowercase hex encoding of the data, "0x" if it is empty
+func (d Data) String() string {
+	return "0x" + hex.EncodeToString(d)
+}
+
+func (d Data) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Data) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseData(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
@@ -0,0 +1,167 @@
+package ethclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+		err   bool
+	}{
+		{"0x0", 0, false},
+		{"0x1", 1, false},
+		{"0x41", 65, false},
+		{"0x400", 1024, false},
+		{"0xABC", 0xabc, false},
+		{"0x", 0, true},
+		{"0x0400", 0, true},
+		{"0x00", 0, true},
+		{"ff", 0, true},
+		{"", 0, true},
+		{"0x-1", 0, true},
+		{"0xz", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			q, err := ethclient.ParseQuantity(tt.input)
+			if tt.err {
+				if !errors.Is(err, ethclient.ErrInvalidQuantity) {
+					t.Fatalf("expected ErrInvalidQuantity, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if q.Big().Int64() != tt.want {
+				t.Fatalf("expected %d, got %s", tt.want, q.Big())
+			}
+		})
+	}
+}
+
+func TestQuantityJSON(t *testing.T) {
+	var v struct {
+		A ethclient.Quantity `json:"a"`
+		B ethclient.Quantity `json:"b"`
+		C ethclient.Quantity `json:"c"`
+	}
+	if err := json.Unmarshal([]byte(`{"a":"0xde0b6b3a7640000","b":null}`), &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.A.Big().Cmp(big.NewInt(1e18)) != 0 {
+		t.Errorf("expected 1e18, got %s", v.A.Big())
+	}
+	if v.B.IsSet() || v.C.IsSet() {
+		t.Error("expected null and missing quantities to be unset")
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(b) != `{"a":"0xde0b6b3a7640000","b":null,"c":null}` {
+		t.Errorf("unexpected encoding %s", b)
+	}
+
+	if err := json.Unmarshal([]byte(`{"a":"0x01"}`), &v); !errors.Is(err, ethclient.ErrInvalidQuantity) {
+		t.Errorf("expected ErrInvalidQuantity for leading zeros, got %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"a":1}`), &v); err == nil {
+		t.Error("expected error for a JSON number")
+	}
+}
+
+func TestQuantityInt(t *testing.T) {
+	if n, err := ethclient.IntQuantity(17758000).Int(); err != nil || n != 17758000 {
+		t.Errorf("expected 17758000, got %d, %v", n, err)
+	}
+	if _, err := (ethclient.Quantity{}).Int(); err == nil {
+		t.Error("expected error for an unset quantity")
+	}
+	huge, _ := ethclient.ParseQuantity("0x10000000000000000")
+	if _, err := huge.Int(); err == nil {
+		t.Error("expected error for a quantity overflowing int")
+	}
+}
+
+func TestHashJSON(t *testing.T) {
+	const hash = "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b"
+
+	var h ethclient.Hash
+	if err := json.Unmarshal([]byte(`"`+hash+`"`), &h); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := json.Marshal(h)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(b) != `"`+hash+`"` {
+		t.Errorf("expected %s, got %s", hash, b)
+	}
+
+	for _, invalid := range []string{"", "0x", "0x01", hash[2:], hash + "00", "0x" + "zz" + hash[4:]} {
+		if _, err := ethclient.ParseHash(invalid); !errors.Is(err, ethclient.ErrInvalidHash) {
+			t.Errorf("expected ErrInvalidHash for %q, got %v", invalid, err)
+		}
+	}
+}
+
+func TestDataJSON(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		err   bool
+	}{
+		{`"0x"`, `"0x"`, false},
+		{`"0x68656c6c6f21"`, `"0x68656c6c6f21"`, false},
+		{`"0xABCD"`, `"0xabcd"`, false},
+		{`"0x123"`, "", true},
+		{`"1234"`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var d ethclient.Data
+			err := json.Unmarshal([]byte(tt.input), &d)
+			if tt.err {
+				if !errors.Is(err, ethclient.ErrInvalidData) {
+					t.Fatalf("expected ErrInvalidData, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, _ := json.Marshal(d)
+			if string(b) != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, b)
+			}
+		})
+	}
+}
+
+func TestGetCurrentBlockNumberMalformed(t *testing.T) {
+	for _, result := range []string{`"0x"`, `"12"`, `"0xzz"`, `null`, `"0x10000000000000000"`} {
+		t.Run(result, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":` + result + `}`))
+			}))
+			defer server.Close()
+
+			client := ethclient.New(server.URL, ethclient.WithRetryPolicy(ethclient.RetryPolicy{MaxAttempts: 1}))
+			if _, err := client.GetCurrentBlockNumber(context.Background()); err == nil {
+				t.Fatal("expected error for malformed block number")
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Package keccak implements the Keccak-256 hash used by Ethereum for address checksums,
+// event topics and function selectors. It is the original Keccak submission with
+// 0x01 padding, not the NIST standardised SHA3-256, which pads with 0x06.
+package keccak
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	// Size of a Keccak-256 hash in bytes
+	Size = 32
+	// bytes absorbed per permutation for a 256 bit output, 1600 bits minus twice the output size
+	rate = 136
+)
+
+var roundConstants = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808a, 0x8000000080008000,
+	0x000000000000808b, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008a, 0x0000000000000088, 0x0000000080008009, 0x000000008000000a,
+	0x000000008000808b, 0x800000000000008b, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800a, 0x800000008000000a,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+// Rotation offsets and lane positions of the combined rho and pi steps,
+// visiting the lanes in the order pi moves lane 1 through the state
+var (
+	rotations = [24]int{1, 3, 6, 10, 15, 21, 28, 36, 45, 55, 2, 14, 27, 41, 56, 8, 25, 43, 62, 18, 39, 61, 20, 44}
+	lanes     = [24]int{10, 7, 11, 17, 18, 3, 5, 16, 8, 21, 24, 4, 15, 23, 19, 13, 12, 2, 20, 14, 22, 9, 6, 1}
+)
+
+// Returns the Keccak-256 hash of data
+func Sum256(data []byte) [Size]byte {
+	return sum(data, 0x01)
+}
+
+// Hashes data with the given first padding byte, which is what tells Keccak and SHA-3 apart
+func sum(data []byte, pad byte) [Size]byte {
+	var state [25]uint64
+
+	for len(data) >= rate {
+		absorb(&state, data[:rate])
+		data = data[rate:]
+	}
+
+	// pad the last block with pad ... 0x80
+	var block [rate]byte
+	copy(block[:], data)
+	block[len(data)] ^= pad
+	block[rate-1] ^= 0x80
+	absorb(&state, block[:])
+
+	var sum [Size]byte
+	for i := 0; i < Size/8; i++ {
+		binary.LittleEndian.PutUint64(sum[i*8:], state[i])
+	}
+	return sum
+}
+
+// XORs a block of rate bytes into the state and permutes it
+func absorb(state *[25]uint64, block []byte) {
+	for i := 0; i < rate/8; i++ {
+		state[i] ^= binary.LittleEndian.Uint64(block[i*8:])
+	}
+	permute(state)
+}
+
+// Keccak-f[1600]
+func permute(a *[25]uint64) {
+	var c [5]uint64
+	for _, rc := range roundConstants {
+		// theta
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := 0; x < 5; x++ {
+			d := c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+			for y := 0; y < 25; y += 5 {
+				a[y+x] ^= d
+			}
+		}
+
+		// rho and pi
+		lane := a[1]
+		for i, j := range lanes {
+			next := a[j]
+			a[j] = bits.RotateLeft64(lane, rotations[i])
+			lane = next
+		}
+
+		// chi
+		for y := 0; y < 25; y += 5 {
+			for x := 0; x < 5; x++ {
+				c[x] = a[y+x]
+			}
+			for x := 0; x < 5; x++ {
+				a[y+x] ^= ^c[(x+1)%5] & c[(x+2)%5]
+			}
+		}
+
+		// iota
+		a[0] ^= rc
+	}
+}
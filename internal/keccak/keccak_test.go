@@ -0,0 +1,47 @@
+package keccak
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestSum256(t *testing.T) {
+	tests := []struct {
+		input string
+		hash  string
+	}{
+		{"", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{"abc", "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{"Transfer(address,address,uint256)", "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"},
+		{"transfer(address,uint256)", "a9059cbb2ab09eb219583f4a59a5d0623ade346d962bcd4e46b11da047c9049b"},
+	}
+
+	for _, tt := range tests {
+		sum := Sum256([]byte(tt.input))
+		if got := hex.EncodeToString(sum[:]); got != tt.hash {
+			t.Errorf("Sum256(%q) = %s, expected %s", tt.input, got, tt.hash)
+		}
+	}
+}
+
+// SHA3-256 only differs in padding, so its published outputs check the permutation
+// on inputs that span several blocks
+func TestPermutationAcrossBlocks(t *testing.T) {
+	tests := []struct {
+		n    int
+		hash string
+	}{
+		{135, "8094bb53c44cfb1e67b7c30447f9a1c33696d2463ecc1d9c92538913392843c9"},
+		{136, "3fc5559f14db8e453a0a3091edbd2bc25e11528d81c66fa570a4efdcc2695ee1"},
+		{137, "f8d6846cedd2ccfadf15c5879ef95af724d799eed7391fb1c91f95344e738614"},
+		{300, "8a5720b2ca0cae7b89ad399c5daab22c29f5c72bcf30ab81e807d9bda95b4580"},
+	}
+
+	for _, tt := range tests {
+		sum := sum([]byte(strings.Repeat("a", tt.n)), 0x06)
+		if got := hex.EncodeToString(sum[:]); got != tt.hash {
+			t.Errorf("SHA3-256 of %d bytes = %s, expected %s", tt.n, got, tt.hash)
+		}
+	}
+}
@@ -0,0 +1,598 @@
+package parser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Number of blocks searched per eth_getLogs or trace_filter call during a backfill.
+// Progress is saved after each range.
+const backfillRange = 1000
+
+// Delay before retrying a job that failed with a retryable error, doubling with each
+// failed attempt up to the maximum
+const (
+	backfillInitialBackoff = 5 * time.Second
+	backfillMaxBackoff     = 10 * time.Minute
+)
+
+// Status of a backfill job
+type BackfillStatus string
+
+const (
+	// waiting for the scanner to pick the first block it indexes, which ends the job's range
+	BackfillPending BackfillStatus = "pending"
+	BackfillQueued  BackfillStatus = "queued"
+	BackfillRunning BackfillStatus = "running"
+	BackfillDone    BackfillStatus = "done"
+	BackfillFailed  BackfillStatus = "failed"
+)
+
+// BackfillJob indexes the history of a new subscription from its start block up to the
+// block the scanner had reached when it was created. There is at most one job per address.
+type BackfillJob struct {
+	Address   string `json:"address"`
+	FromBlock int    `json:"fromBlock"`
+	ToBlock   int    `json:"toBlock"`
+	// first block not backfilled yet
+	NextBlock int `json:"nextBlock"`
+	// fraction of the range backfilled, from 0 to 1
+	Progress float64        `json:"progress"`
+	Status   BackfillStatus `json:"status"`
+	Error    string         `json:"error,omitempty"`
+	// failed attempts since the job last made progress, and when it is retried next
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+func newBackfillJob(address string, from, to int) BackfillJob {
+	now := time.Now().UTC()
+	return BackfillJob{
+		Address:       address,
+		FromBlock:     from,
+		ToBlock:       to,
+		NextBlock:     from,
+		Status:        BackfillQueued,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// Records that the blocks before next have been backfilled
+func (j *BackfillJob) advance(next int) {
+	j.NextBlock = next
+	j.Attempts = 0
+	j.Error = ""
+	j.Progress = float64(next-j.FromBlock) / float64(j.ToBlock-j.FromBlock+1)
+	if next > j.ToBlock {
+		j.Status = BackfillDone
+		j.Progress = 1
+	}
+	j.UpdatedAt = time.Now().UTC()
+}
+
+// Returns how long to wait before retrying a job after the given number of failed attempts
+func backfillBackoff(attempts int) time.Duration {
+	backoff := backfillInitialBackoff
+	for i := 1; i < attempts && backoff < backfillMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, backfillMaxBackoff)
+}
+
+// History of one address found in a range of blocks
+type backfillResult struct {
+	txs            []indexedTx
+	receipts       []ethclient.Receipt
+	internalTxs    []InternalTransaction
+	tokenTransfers []TokenTransfer
+	nftTransfers   []NFTTransfer
+	withdrawals    []Withdrawal
+}
+
+var errBackfillCancelled = errors.New("backfill cancelled")
+
+// Signals the backfill worker that a job was queued
+func (b *Scanner) wakeBackfills() {
+	select {
+	case b.backfillWake <- struct{}{}:
+	default:
+	}
+}
+
+// Runs backfill jobs until ctx is done, picking up new jobs as they are queued
+// and waking up when a retry is due
+func (b *Scanner) runBackfills(ctx context.Context) {
+	for {
+		next, err := b.backfill(ctx)
+		if err != nil && ctx.Err() == nil {
+			b.logger.Printf("error running backfills: %v", err)
+		}
+
+		var retry <-chan time.Time
+		var timer *time.Timer
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			retry = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-b.backfillWake:
+		case <-retry:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Runs all queued backfill jobs that are due, including jobs interrupted by a restart.
+// A job that fails with a retryable error, such as a node timing out, stays queued and
+// is retried with exponential backoff from the last saved range. Other errors fail the job.
+func (b *Scanner) Backfill(ctx context.Context) error {
+	_, err := b.backfill(ctx)
+	return err
+}
+
+// Runs the jobs that are due and returns when the next remaining retry is due,
+// or the zero time if no job is waiting for one
+func (b *Scanner) backfill(ctx context.Context) (time.Time, error) {
+	addresses, err := listKeys(b.db, backfillKeyPrefix)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sort.Strings(addresses)
+
+	var next time.Time
+	for _, address := range addresses {
+		job, err := getBackfillJob(b.db, address)
+		if errors.Is(err, datastore.KeyDoesNotExist) {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if job.Status != BackfillQueued && job.Status != BackfillRunning {
+			continue
+		}
+		if job.NextAttemptAt.After(time.Now()) {
+			if next.IsZero() || job.NextAttemptAt.Before(next) {
+				next = job.NextAttemptAt
+			}
+			continue
+		}
+
+		b.logger.Printf("Backfilling %s from block %d to %d\n", address, job.NextBlock, job.ToBlock)
+		err = b.runBackfill(ctx, &job)
+		if errors.Is(err, errBackfillCancelled) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return time.Time{}, ctx.Err()
+			}
+			now := time.Now().UTC()
+			job.Attempts++
+			job.Error = err.Error()
+			job.UpdatedAt = now
+			if ethclient.IsRetryable(err) {
+				job.Status = BackfillQueued
+				job.NextAttemptAt = now.Add(backfillBackoff(job.Attempts))
+				if next.IsZero() || job.NextAttemptAt.Before(next) {
+					next = job.NextAttemptAt
+				}
+				b.logger.Printf("error backfilling %s, retrying at %s: %v", address, job.NextAttemptAt.Format(time.RFC3339), err)
+			} else {
+				job.Status = BackfillFailed
+				b.logger.Printf("error backfilling %s: %v", address, err)
+			}
+			if err := b.saveBackfill(job, backfillResult{}); err != nil && !errors.Is(err, errBackfillCancelled) {
+				return time.Time{}, err
+			}
+		}
+	}
+	return next, nil
+}
+
+// Backfills the job's remaining ranges, saving its progress to the datastore and to job
+func (b *Scanner) runBackfill(ctx context.Context, job *BackfillJob) error {
+	job.Status = BackfillRunning
+	for job.NextBlock <= job.ToBlock {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		to := min(job.NextBlock+backfillRange-1, job.ToBlock)
+		result, err := b.backfillRange(ctx, job.Address, job.NextBlock, to)
+		if err != nil {
+			// the job resumes from the last saved range
+			return err
+		}
+
+		job.advance(to + 1)
+		if err := b.saveBackfill(*job, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Saves the history found by a backfill together with the job's progress.
+// Returns errBackfillCancelled if the job was replaced or its address unsubscribed in the meantime.
+func (b *Scanner) saveBackfill(job BackfillJob, result backfillResult) error {
+	return b.db.Batch(func(batch datastore.Batch) error {
+		current, err := getBackfillJob(batch, job.Address)
+		if errors.Is(err, datastore.KeyDoesNotExist) || (err == nil && !current.CreatedAt.Equal(job.CreatedAt)) {
+			return errBackfillCancelled
+		}
+		if err != nil {
+			return err
+		}
+
+		address := job.Address
+		if err := mergeRecords(batch, txKey(address), result.txs,
+			func(tx indexedTx) string { return tx.Hash.Hex() },
+			func(tx indexedTx) string { return tx.BlockNumber.String() },
+		); err != nil {
+			return err
+		}
+		if err := putReceipts(batch, result.receipts); err != nil {
+			return err
+		}
+		if err := mergeRecords(batch, internalKey(address), result.internalTxs,
+			func(tx InternalTransaction) string { return fmt.Sprint(tx.TransactionHash, tx.TraceAddress) },
+			func(tx InternalTransaction) string { return tx.BlockNumber },
+		); err != nil {
+			return err
+		}
+		if err := mergeRecords(batch, tokenKey(address), result.tokenTransfers,
+			func(t TokenTransfer) string { return t.TransactionHash + "/" + t.LogIndex },
+			func(t TokenTransfer) string { return t.BlockNumber },
+		); err != nil {
+			return err
+		}
+		if err := mergeRecords(batch, nftKey(address), result.nftTransfers,
+			func(t NFTTransfer) string { return t.TransactionHash + "/" + t.LogIndex },
+			func(t NFTTransfer) string { return t.BlockNumber },
+		); err != nil {
+			return err
+		}
+		if err := mergeRecords(batch, withdrawalKey(address), result.withdrawals,
+			func(w Withdrawal) string { return w.Index },
+			func(w Withdrawal) string { return w.BlockNumber },
+		); err != nil {
+			return err
+		}
+		return putBackfillJob(batch, job)
+	})
+}
+
+// Finds the history of the address in the blocks from..to
+func (b *Scanner) backfillRange(ctx context.Context, address string, from, to int) (backfillResult, error) {
+	result, err := b.findTransactions(ctx, address, from, to)
+	if err != nil {
+		return backfillResult{}, err
+	}
+	if b.receipts && len(result.txs) > 0 {
+		hashes := make([]string, 0, len(result.txs))
+		for _, tx := range result.txs {
+			hashes = append(hashes, tx.Hash.Hex())
+		}
+		if result.receipts, err = b.ethClient.GetTransactionReceipts(ctx, hashes); err != nil {
+			return backfillResult{}, err
+		}
+	}
+
+	logs, err := b.findEventLogs(ctx, address, from, to)
+	if err != nil {
+		return backfillResult{}, err
+	}
+	for _, log := range logs {
+		if transfer, ok := decodeTokenTransfer(log); ok && b.tokens {
+			result.tokenTransfers = append(result.tokenTransfers, transfer)
+		}
+		if transfer, ok := decodeNFTTransfer(log); ok && b.nfts {
+			result.nftTransfers = append(result.nftTransfers, transfer)
+		}
+	}
+	return result, nil
+}
+
+// Returns the transactions sent or received by the address in the blocks from..to, its
+// withdrawals, and its internal transactions if tracing is enabled. Searches with trace_filter
+// where the node supports it and otherwise scans the blocks, which finds no internal transactions.
+func (b *Scanner) findTransactions(
+	ctx context.Context,
+	address string,
+	from, to int,
+) (backfillResult, error) {
+	if !b.traceFilterUnsupported {
+		txs, internalTxs, err := b.traceTransactions(ctx, address, from, to)
+		if err == nil {
+			// trace_filter does not report withdrawals
+			withdrawals, err := b.findWithdrawals(ctx, address, from, to)
+			if err != nil {
+				return backfillResult{}, err
+			}
+			return backfillResult{txs: txs, internalTxs: internalTxs, withdrawals: withdrawals}, nil
+		}
+		if !errors.Is(err, ethclient.ErrMethodNotFound) {
+			return backfillResult{}, err
+		}
+		b.logger.Printf("node does not support %s, backfilling by scanning blocks without internal transactions", ethclient.TraceFilterMethod)
+		b.traceFilterUnsupported = true
+	}
+
+	result := backfillResult{txs: make([]indexedTx, 0), withdrawals: make([]Withdrawal, 0)}
+	for start := from; start <= to; start += b.prefetch {
+		numbers := make([]int, 0, b.prefetch)
+		for n := start; n <= min(start+b.prefetch-1, to); n++ {
+			numbers = append(numbers, n)
+		}
+		blocks, err := b.ethClient.GetBlocksByNumber(ctx, numbers)
+		if err != nil {
+			return backfillResult{}, err
+		}
+		for _, block := range blocks {
+			for _, tx := range indexTxs(block.Header, block.Transactions) {
+				if strings.EqualFold(tx.From.Hex(), address) || strings.EqualFold(recipient(tx.Transaction), address) {
+					result.txs = append(result.txs, tx)
+				}
+			}
+			for _, w := range blockWithdrawals(block) {
+				if strings.EqualFold(w.Address, address) {
+					result.withdrawals = append(result.withdrawals, w)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// Returns the withdrawals to the address in the blocks from..to, fetching the blocks
+// without their transactions
+func (b *Scanner) findWithdrawals(ctx context.Context, address string, from, to int) ([]Withdrawal, error) {
+	withdrawals := make([]Withdrawal, 0)
+	for start := from; start <= to; start += b.prefetch {
+		numbers := make([]int, 0, b.prefetch)
+		for n := start; n <= min(start+b.prefetch-1, to); n++ {
+			numbers = append(numbers, n)
+		}
+		blocks, err := b.ethClient.GetBlocksWithoutTransactions(ctx, numbers)
+		if err != nil {
+			return nil, err
+		}
+		for _, block := range blocks {
+			for _, w := range blockWithdrawals(block) {
+				if strings.EqualFold(w.Address, address) {
+					withdrawals = append(withdrawals, w)
+				}
+			}
+		}
+	}
+	return withdrawals, nil
+}
+
+// Finds the address's transactions and internal transactions with trace_filter
+func (b *Scanner) traceTransactions(
+	ctx context.Context,
+	address string,
+	from, to int,
+) ([]indexedTx, []InternalTransaction, error) {
+	// nodes differ in whether from and to addresses combine as union or intersection,
+	// so they are queried separately
+	var traces []ethclient.Trace
+	for _, filter := range []ethclient.TraceFilter{
+		{FromAddress: []string{address}},
+		{ToAddress: []string{address}},
+	} {
+		filter.FromBlock = fmt.Sprintf("0x%x", from)
+		filter.ToBlock = fmt.Sprintf("0x%x", to)
+		result, err := b.ethClient.TraceFilter(ctx, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		traces = append(traces, result...)
+	}
+	sort.SliceStable(traces, func(i, j int) bool {
+		if traces[i].BlockNumber != traces[j].BlockNumber {
+			return traces[i].BlockNumber < traces[j].BlockNumber
+		}
+		return traces[i].TransactionPosition < traces[j].TransactionPosition
+	})
+
+	hashes := make([]string, 0)
+	internalTxs := make([]InternalTransaction, 0)
+	seen := make(map[string]bool)
+	for _, trace := range traces {
+		if trace.TransactionHash == "" {
+			// block rewards
+			continue
+		}
+		if len(trace.TraceAddress) == 0 {
+			// the top-level call is the transaction itself, failed transactions included
+			if !seen[trace.TransactionHash] {
+				seen[trace.TransactionHash] = true
+				hashes = append(hashes, trace.TransactionHash)
+			}
+			continue
+		}
+		if !b.tracing || trace.Error != "" {
+			continue
+		}
+		tx, ok := internalTxFromTrace(trace, fmt.Sprintf("0x%x", trace.BlockNumber), trace.BlockHash)
+		if ok && (strings.EqualFold(tx.From, address) || strings.EqualFold(tx.To, address)) {
+			internalTxs = append(internalTxs, tx)
+		}
+	}
+
+	if len(hashes) == 0 {
+		return []indexedTx{}, internalTxs, nil
+	}
+	txs, err := b.ethClient.GetTransactionsByHash(ctx, hashes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the headers of the transactions' blocks, fetched once per block
+	headers := make(map[int]ethclient.Header)
+	indexed := make([]indexedTx, 0, len(txs))
+	for _, tx := range txs {
+		number, err := tx.BlockNumber.Int()
+		if err != nil {
+			return nil, nil, fmt.Errorf("transaction %s: %w", tx.Hash, err)
+		}
+		header, ok := headers[number]
+		if !ok {
+			if header, err = b.ethClient.GetHeaderByNumber(ctx, number); err != nil {
+				return nil, nil, err
+			}
+			headers[number] = header
+		}
+		indexed = append(indexed, indexTxs(header, []ethclient.Transaction{tx})...)
+	}
+	return indexed, internalTxs, nil
+}
+
+// Returns the logs of indexed events in the blocks from..to in which the address
+// appears as sender or recipient, ordered by block and log index
+func (b *Scanner) findEventLogs(ctx context.Context, address string, from, to int) ([]ethclient.Log, error) {
+	topic, ok := addressToTopic(address)
+	if !ok {
+		return nil, nil
+	}
+
+	var filters [][][]string
+	if b.tokens || b.nfts {
+		filters = append(filters,
+			[][]string{{TransferEventTopic}, {topic}},
+			[][]string{{TransferEventTopic}, nil, {topic}},
+		)
+	}
+	if b.nfts {
+		erc1155 := []string{TransferSingleEventTopic, TransferBatchEventTopic}
+		filters = append(filters,
+			[][]string{erc1155, nil, {topic}},
+			[][]string{erc1155, nil, nil, {topic}},
+		)
+	}
+
+	logs := make([]ethclient.Log, 0)
+	seen := make(map[string]bool)
+	for _, topics := range filters {
+		result, err := b.ethClient.GetLogs(ctx, ethclient.LogFilter{
+			FromBlock: fmt.Sprintf("0x%x", from),
+			ToBlock:   fmt.Sprintf("0x%x", to),
+			Topics:    topics,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, log := range result {
+			// transfers to self match both the sender and the recipient filter
+			id := log.TransactionHash + "/" + log.LogIndex
+			if log.Removed || seen[id] {
+				continue
+			}
+			seen[id] = true
+			logs = append(logs, log)
+		}
+	}
+
+	sort.SliceStable(logs, func(i, j int) bool {
+		bi, _ := ethclient.ParseHexInt(logs[i].BlockNumber)
+		bj, _ := ethclient.ParseHexInt(logs[j].BlockNumber)
+		if bi != bj {
+			return bi < bj
+		}
+		li, _ := ethclient.ParseHexInt(logs[i].LogIndex)
+		lj, _ := ethclient.ParseHexInt(logs[j].LogIndex)
+		return li < lj
+	})
+	return logs, nil
+}
+
+// Returns the backfill job of the address, or false if it has none
+func (p *Parser) GetBackfillJob(address string) (BackfillJob, bool) {
+	address, err := ethclient.NormalizeAddress(address)
+	if err != nil {
+		p.logger.Printf("failed to get backfill job: %v", err)
+		return BackfillJob{}, false
+	}
+
+	job, err := getBackfillJob(p.db, address)
+	if err != nil {
+		if !errors.Is(err, datastore.KeyDoesNotExist) {
+			p.logger.Printf("failed to get backfill job for address %s: %v", address, err)
+		}
+		return BackfillJob{}, false
+	}
+	job.Address = ethclient.ChecksumAddress(job.Address)
+	return job, true
+}
+
+// Returns all backfill jobs ordered by address
+func (p *Parser) ListBackfillJobs() ([]BackfillJob, error) {
+	addresses, err := listKeys(p.db, backfillKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(addresses)
+
+	jobs := make([]BackfillJob, 0, len(addresses))
+	for _, address := range addresses {
+		job, err := getBackfillJob(p.db, address)
+		if errors.Is(err, datastore.KeyDoesNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		job.Address = ethclient.ChecksumAddress(job.Address)
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Reads a backfill job from the datastore or a batch
+func getBackfillJob(db interface {
+	Get(key string) ([][]byte, error)
+}, address string) (BackfillJob, error) {
+	v, err := db.Get(backfillKey(address))
+	if err != nil {
+		return BackfillJob{}, err
+	}
+	if len(v) == 0 {
+		return BackfillJob{}, errors.New("empty backfill job record")
+	}
+
+	var job BackfillJob
+	if err := json.Unmarshal(v[0], &job); err != nil {
+		return BackfillJob{}, err
+	}
+	return job, nil
+}
+
+func putBackfillJob(batch datastore.Batch, job BackfillJob) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return batch.Put(backfillKey(job.Address), [][]byte{b})
+}
@@ -0,0 +1,306 @@
+package parser_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+func TestBackfill(t *testing.T) {
+	const (
+		user     = "0x00000000000000000000000000000000000005e1"
+		multisig = "0x000000000000000000000000000000000000ca5e"
+	)
+
+	for _, traceFilter := range []bool{true, false} {
+		name := "TraceFilter"
+		if !traceFilter {
+			name = "BlockScan"
+		}
+		t.Run(name, func(t *testing.T) {
+			node := newFakeNode(t, 0)
+			node.noTraceFilter = !traceFilter
+			node.emit(erc20Transfer("0x01", usdc, alice, carol, 500))
+			node.mine(newTx(1, alice, usdc, 0))
+			node.mine(newTx(2, carol, user, 1))
+			node.calls[testHash(3).Hex()] = ethclient.CallFrame{
+				Type: "CALL", From: user, To: multisig, Value: "0x0",
+				Calls: []ethclient.CallFrame{{Type: "CALL", From: multisig, To: alice, Value: "0x64"}},
+			}
+			node.mine(newTx(3, user, multisig, 0))
+			node.mine(newTx(4, carol, alice, 2))
+			for i := 0; i < 6; i++ {
+				node.mine()
+			}
+
+			p := parser.New(
+				log.New(io.Discard, "", 0),
+				node.URL(),
+				10,
+				parser.WithReceipts(true),
+				parser.WithTokenTransfers(true),
+				parser.WithInternalTransactions(true),
+			)
+			if !p.Subscribe(alice, parser.WithFromBlock(2)) {
+				t.Fatal("expected subscribe to succeed")
+			}
+
+			job, ok := p.GetBackfillJob(alice)
+			if !ok {
+				t.Fatal("expected a backfill job")
+			}
+			if job.Status != parser.BackfillQueued || job.FromBlock != 2 || job.ToBlock != 10 {
+				t.Errorf("unexpected queued job: %+v", job)
+			}
+
+			// a transaction scanned while the backfill is pending
+			node.mine(newTx(5, alice, carol, 3))
+			if err := p.ScanAll(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := p.Backfill(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			job, _ = p.GetBackfillJob(alice)
+			if job.Status != parser.BackfillDone || job.Progress != 1 || job.NextBlock != 11 {
+				t.Errorf("unexpected finished job: %+v", job)
+			}
+
+			// block 1 is before the start block
+			txs := p.GetTransactions(alice)
+			if len(txs) != 2 || txs[0].Hash != testHash(4) || txs[1].Hash != testHash(5) {
+				t.Fatalf("expected transactions 0x04 and 0x05 in block order, got %+v", txs)
+			}
+			if txs[0].Receipt == nil {
+				t.Error("expected backfilled transaction to have a receipt")
+			}
+
+			internalTxs := p.GetInternalTransactions(alice)
+			if traceFilter && (len(internalTxs) != 1 || internalTxs[0].TransactionHash != testHash(3).Hex() || internalTxs[0].Value != "0x64") {
+				t.Errorf("expected internal transaction of 0x03, got %+v", internalTxs)
+			}
+			if !traceFilter && len(internalTxs) != 0 {
+				t.Errorf("expected no internal transactions without trace_filter, got %+v", internalTxs)
+			}
+
+			// running again does not duplicate history
+			if err := p.Backfill(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if txs := p.GetTransactions(alice); len(txs) != 2 {
+				t.Errorf("expected 2 transactions after rerun, got %d", len(txs))
+			}
+		})
+	}
+}
+
+func TestBackfillEffectiveGasPrice(t *testing.T) {
+	for _, traceFilter := range []bool{true, false} {
+		name := "TraceFilter"
+		if !traceFilter {
+			name = "BlockScan"
+		}
+		t.Run(name, func(t *testing.T) {
+			node := newFakeNode(t, 0)
+			node.noTraceFilter = !traceFilter
+			node.baseFee = 100
+			node.mine(newDynamicFeeTx(1, carol, alice, 150, 20))
+			node.mine()
+
+			p := parser.New(log.New(io.Discard, "", 0), node.URL(), 2)
+			p.Subscribe(alice, parser.WithFromBlock(1))
+			if err := p.Backfill(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// without receipts the price comes from the block's base fee
+			txs := p.GetTransactions(alice)
+			if len(txs) != 1 {
+				t.Fatalf("expected 1 transaction, got %d", len(txs))
+			}
+			if price, err := txs[0].EffectiveGasPrice.Int(); err != nil || price != 120 {
+				t.Fatalf("expected effective gas price 120, got %s", txs[0].EffectiveGasPrice)
+			}
+		})
+	}
+}
+
+func TestBackfillTokenTransfers(t *testing.T) {
+	node := newFakeNode(t, 0)
+	node.emit(
+		erc20Transfer("0x01", usdc, carol, alice, 100),
+		// transfers to self match both sides of the search
+		erc20Transfer("0x01", usdc, alice, alice, 5),
+	)
+	node.mine(newTx(1, carol, usdc, 0))
+	node.mine()
+
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 2, parser.WithTokenTransfers(true))
+	p.Subscribe(alice, parser.WithFromBlock(0))
+	if err := p.Backfill(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	transfers := p.GetTokenTransfers(alice)
+	if len(transfers) != 2 || transfers[0].Amount != "0x64" || transfers[1].Amount != "0x5" {
+		t.Fatalf("expected 2 token transfers, got %+v", transfers)
+	}
+}
+
+func TestBackfillUnsubscribe(t *testing.T) {
+	node := newFakeNode(t, 0)
+	node.mine(newTx(1, carol, alice, 1))
+	for i := 0; i < 4; i++ {
+		node.mine()
+	}
+
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 5)
+	p.Subscribe(alice, parser.WithFromBlock(1))
+	p.Unsubscribe(alice)
+
+	if _, ok := p.GetBackfillJob(alice); ok {
+		t.Fatal("expected unsubscribe to remove the backfill job")
+	}
+	if err := p.Backfill(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Subscribe(alice) {
+		if txs := p.GetTransactions(alice); len(txs) != 0 {
+			t.Errorf("expected no history after unsubscribing, got %d transactions", len(txs))
+		}
+	}
+	if _, ok := p.GetBackfillJob(alice); ok {
+		t.Error("expected no backfill job for a subscription without from block")
+	}
+}
+
+func TestSubscribeWithoutFromBlock(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+	p.Subscribe(alice)
+
+	jobs, err := p.ListBackfillJobs()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(jobs) != 0 {
+		t.Fatalf("expected no backfill jobs, got %+v", jobs)
+	}
+}
+
+func TestBackfillRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		code   int
+		status parser.BackfillStatus
+	}{
+		{name: "Retryable", code: ethclient.CodeLimitExceeded, status: parser.BackfillQueued},
+		{name: "NotRetryable", code: ethclient.CodeInvalidParams, status: parser.BackfillFailed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			node := newFakeNode(t, 10)
+			node.failures = map[string]int{ethclient.TraceFilterMethod: tc.code}
+			calls := 0
+			node.onCall = func(method string) {
+				if method == ethclient.TraceFilterMethod {
+					calls++
+				}
+			}
+			p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10,
+				parser.WithClientOptions(ethclient.WithRetryPolicy(ethclient.RetryPolicy{MaxAttempts: 1})),
+			)
+			p.Subscribe(alice, parser.WithFromBlock(2))
+
+			if err := p.Backfill(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			job, _ := p.GetBackfillJob(alice)
+			if job.Status != tc.status || job.Attempts != 1 || job.Error == "" || job.NextBlock != 2 {
+				t.Fatalf("unexpected job after a failed attempt: %+v", job)
+			}
+
+			// the job is not attempted again before its backoff elapses
+			if err := p.Backfill(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if calls != 1 {
+				t.Fatalf("expected 1 trace_filter call, got %d", calls)
+			}
+			if tc.status == parser.BackfillQueued && !job.NextAttemptAt.After(job.UpdatedAt) {
+				t.Errorf("expected a retry to be scheduled, got %+v", job)
+			}
+		})
+	}
+}
+
+func TestBackfillBeforeFirstBlock(t *testing.T) {
+	const (
+		bob  = "0x0000000000000000000000000000000000000b0b"
+		dave = "0x000000000000000000000000000000000000da5e"
+	)
+
+	node := newFakeNode(t, 0)
+	node.mine(newTx(1, carol, alice, 0))
+	node.mine(newTx(2, alice, carol, 0))
+	for i := 0; i < 8; i++ {
+		node.mine()
+	}
+
+	// the scanner has not picked its first block yet
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 0)
+	p.Subscribe(alice, parser.WithFromBlock(2))
+	p.Subscribe(bob)
+	p.Subscribe(dave, parser.WithFromBlock(50))
+	if job, ok := p.GetBackfillJob(alice); !ok || job.Status != parser.BackfillPending {
+		t.Fatalf("expected a pending backfill job, got %+v", job)
+	}
+
+	// the scanner starts at the head, block 10
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	job, _ := p.GetBackfillJob(alice)
+	if job.Status != parser.BackfillQueued || job.FromBlock != 2 || job.ToBlock != 9 {
+		t.Fatalf("expected the job to cover the blocks before the first scanned one, got %+v", job)
+	}
+	if err := p.Backfill(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if txs := p.GetTransactions(alice); len(txs) != 1 || txs[0].Hash != testHash(2) {
+		t.Fatalf("expected the transaction from block 2, got %+v", txs)
+	}
+
+	for address, want := range map[string]int{alice: 2, bob: 10, dave: 10} {
+		if subscription, _ := p.GetSubscription(address); subscription.StartBlock != want {
+			t.Errorf("expected %s to start at block %d, got %d", address, want, subscription.StartBlock)
+		}
+	}
+	if _, ok := p.GetBackfillJob(dave); ok {
+		t.Error("expected no backfill job for a from block after the first scanned block")
+	}
+}
+
+func TestSubscribeFromBlockAhead(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+
+	// the scanner indexes block 11 next
+	if p.Subscribe(alice, parser.WithFromBlock(12)) {
+		t.Fatal("expected a from block ahead of the scanner to be rejected")
+	}
+	if _, ok := p.GetSubscription(alice); ok {
+		t.Fatal("expected no subscription")
+	}
+	if !p.Subscribe(alice, parser.WithFromBlock(11)) {
+		t.Fatal("expected the next block to be accepted")
+	}
+	if subscription, _ := p.GetSubscription(alice); subscription.StartBlock != 11 {
+		t.Fatalf("expected start block 11, got %d", subscription.StartBlock)
+	}
+}
@@ -0,0 +1,44 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+)
+
+// Checkpoint is the last block the scanner fully processed
+type Checkpoint struct {
+	Number int    `json:"number"`
+	Hash   string `json:"hash"`
+}
+
+// Returns the stored checkpoint, or false if the datastore or batch has none
+func loadCheckpoint(db interface {
+	Get(key string) ([][]byte, error)
+}) (Checkpoint, bool, error) {
+	v, err := db.Get(checkpointKey)
+	if errors.Is(err, datastore.KeyDoesNotExist) {
+		return Checkpoint{}, false, nil
+	}
+	if err != nil {
+		return Checkpoint{}, false, err
+	}
+	if len(v) != 1 {
+		return Checkpoint{}, false, errors.New("invalid checkpoint")
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(v[0], &checkpoint); err != nil {
+		return Checkpoint{}, false, err
+	}
+	return checkpoint, true, nil
+}
+
+func putCheckpoint(batch datastore.Batch, checkpoint Checkpoint) error {
+	b, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return batch.Put(checkpointKey, [][]byte{b})
+}
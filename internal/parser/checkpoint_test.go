@@ -0,0 +1,112 @@
+package parser_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore/filedb"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	const (
+		alice = "0x00000000000000000000000000000000000a11ce"
+		bob   = "0x0000000000000000000000000000000000000b0b"
+	)
+	logger := log.New(io.Discard, "", 0)
+	dir := t.TempDir()
+	node := newFakeNode(t, 10)
+
+	db, err := filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := parser.New(logger, node.URL(), 10, parser.WithDataStore(db))
+	p.Subscribe(alice)
+	p.Subscribe(bob)
+
+	node.mine(newTx(1, alice, bob, 0))
+	node.mine(newTx(2, alice, bob, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.Close()
+
+	// Blocks produced while the parser is down
+	node.mine(newTx(3, bob, alice, 0))
+	node.mine()
+
+	db, err = filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+
+	// The initial block is ignored once the datastore has a checkpoint
+	p = parser.New(logger, node.URL(), 0, parser.WithDataStore(db))
+	if p.GetCurrentBlock() != 12 {
+		t.Fatalf("expected to resume from block 12, got %d", p.GetCurrentBlock())
+	}
+
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.GetCurrentBlock() != 14 {
+		t.Fatalf("expected current block 14, got %d", p.GetCurrentBlock())
+	}
+
+	txs := p.GetTransactions(alice)
+	want := []ethclient.Hash{testHash(1), testHash(2), testHash(3)}
+	if len(txs) != len(want) {
+		t.Fatalf("expected %d transactions, got %d", len(want), len(txs))
+	}
+	for i, tx := range txs {
+		if tx.Hash != want[i] {
+			t.Errorf("expected transaction %d to be %s, got %s", i, want[i], tx.Hash)
+		}
+	}
+}
+
+func TestResumeDetectsReorgAcrossRestart(t *testing.T) {
+	const alice = "0x00000000000000000000000000000000000a11ce"
+	logger := log.New(io.Discard, "", 0)
+	dir := t.TempDir()
+	node := newFakeNode(t, 10)
+
+	db, err := filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := parser.New(logger, node.URL(), 10, parser.WithDataStore(db))
+	p.Subscribe(alice)
+	node.mine(newTx(1, alice, alice, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.Close()
+
+	// Block 11 is replaced while the parser is down
+	node.fork(10)
+	node.mine()
+	node.mine()
+
+	db, err = filedb.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+	p = parser.New(logger, node.URL(), 0, parser.WithDataStore(db))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if txs := p.GetTransactions(alice); len(txs) != 0 {
+		t.Fatalf("expected orphaned transactions to be removed, got %d", len(txs))
+	}
+	if p.GetCurrentBlock() != 12 {
+		t.Fatalf("expected current block 12, got %d", p.GetCurrentBlock())
+	}
+}
@@ -0,0 +1,497 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Binary record layout of a stored transaction:
+//
+//	version | (uvarint field | kind | payload)...
+//
+// Empty fields are left out and fields unknown to the reader are skipped, so fields
+// can be added without a new version. The version changes only if existing fields
+// change meaning. Records written before the binary format are JSON objects, which
+// never start with a version byte.
+const txRecordVersion byte = 1
+
+// How a field's payload is encoded
+const (
+	// uvarint length | bytes, such as a hash, address or input data
+	kindBytes byte = iota + 1
+	// uvarint length | big-endian bytes without leading zeros
+	kindQuantity
+	// uvarint length | bytes, a hex string the field's type could not hold when it was
+	// written. No longer written, but read back by parsing it as the field's type.
+	kindString
+)
+
+var errCorruptRecord = errors.New("corrupt transaction record")
+
+// A transaction as stored in an address's history, with the context of its block
+type indexedTx struct {
+	ethclient.Transaction
+	// base fee of the block, unset before the London fork or if the block was not fetched
+	BaseFeePerGas ethclient.Quantity
+}
+
+// Pairs transactions with the header of the block they were included in, filling in
+// the block's timestamp where the node left it out
+func indexTxs(header ethclient.Header, txs []ethclient.Transaction) []indexedTx {
+	indexed := make([]indexedTx, 0, len(txs))
+	for _, tx := range txs {
+		if !tx.BlockTimestamp.IsSet() {
+			tx.BlockTimestamp = header.Timestamp
+		}
+		indexed = append(indexed, indexedTx{Transaction: tx, BaseFeePerGas: header.BaseFeePerGas})
+	}
+	return indexed
+}
+
+// A field of a stored transaction
+type txField struct {
+	// field number in the binary format, never reused
+	number uint64
+	// field name in the legacy JSON format, empty for fields added since
+	name string
+	kind byte
+	// returns the field's payload, or false if the field is unset
+	get func(tx *indexedTx) ([]byte, bool)
+	set func(tx *indexedTx, payload []byte) error
+}
+
+var txFields = []txField{
+	hashField(1, "hash", func(tx *indexedTx) *ethclient.Hash { return &tx.Hash }),
+	hashField(2, "blockHash", func(tx *indexedTx) *ethclient.Hash { return &tx.BlockHash }),
+	quantityField(3, "blockNumber", func(tx *indexedTx) *ethclient.Quantity { return &tx.BlockNumber }),
+	quantityField(4, "transactionIndex", func(tx *indexedTx) *ethclient.Quantity { return &tx.TransactionIndex }),
+	addressField(5, "from", func(tx *indexedTx) *ethclient.Address { return &tx.From }),
+	{
+		number: 6,
+		name:   "to",
+		kind:   kindBytes,
+		get: func(tx *indexedTx) ([]byte, bool) {
+			if tx.To == nil {
+				return nil, false
+			}
+			return tx.To[:], true
+		},
+		set: func(tx *indexedTx, payload []byte) error {
+			var to ethclient.Address
+			if len(payload) != len(to) {
+				return fmt.Errorf("%w: to address of %d bytes", errCorruptRecord, len(payload))
+			}
+			copy(to[:], payload)
+			tx.To = &to
+			return nil
+		},
+	},
+	quantityField(7, "value", func(tx *indexedTx) *ethclient.Quantity { return &tx.Value }),
+	quantityField(8, "nonce", func(tx *indexedTx) *ethclient.Quantity { return &tx.Nonce }),
+	quantityField(9, "gas", func(tx *indexedTx) *ethclient.Quantity { return &tx.Gas }),
+	quantityField(10, "gasPrice", func(tx *indexedTx) *ethclient.Quantity { return &tx.GasPrice }),
+	{
+		number: 11,
+		name:   "input",
+		kind:   kindBytes,
+		get: func(tx *indexedTx) ([]byte, bool) {
+			return tx.Input, len(tx.Input) > 0
+		},
+		set: func(tx *indexedTx, payload []byte) error {
+			tx.Input = bytes.Clone(payload)
+			return nil
+		},
+	},
+	quantityField(12, "chainId", func(tx *indexedTx) *ethclient.Quantity { return &tx.ChainID }),
+	quantityField(13, "", func(tx *indexedTx) *ethclient.Quantity { return &tx.Type }),
+	quantityField(14, "", func(tx *indexedTx) *ethclient.Quantity { return &tx.MaxFeePerGas }),
+	quantityField(15, "", func(tx *indexedTx) *ethclient.Quantity { return &tx.MaxPriorityFeePerGas }),
+	quantityField(16, "", func(tx *indexedTx) *ethclient.Quantity { return &tx.MaxFeePerBlobGas }),
+	{
+		number: 17,
+		kind:   kindBytes,
+		get: func(tx *indexedTx) ([]byte, bool) {
+			if tx.BlobVersionedHashes == nil {
+				return nil, false
+			}
+			b := make([]byte, 0, len(tx.BlobVersionedHashes)*len(ethclient.Hash{}))
+			for _, h := range tx.BlobVersionedHashes {
+				b = append(b, h[:]...)
+			}
+			return b, true
+		},
+		set: func(tx *indexedTx, payload []byte) error {
+			hashes, err := readHashes(payload, len(payload)/len(ethclient.Hash{}))
+			if err != nil || len(hashes)*len(ethclient.Hash{}) != len(payload) {
+				return fmt.Errorf("%w: blob hashes of %d bytes", errCorruptRecord, len(payload))
+			}
+			tx.BlobVersionedHashes = hashes
+			return nil
+		},
+	},
+	{
+		number: 18,
+		kind:   kindBytes,
+		get: func(tx *indexedTx) ([]byte, bool) {
+			if tx.AccessList == nil {
+				return nil, false
+			}
+			return encodeAccessList(tx.AccessList), true
+		},
+		set: func(tx *indexedTx, payload []byte) error {
+			list, err := decodeAccessList(payload)
+			if err != nil {
+				return err
+			}
+			tx.AccessList = list
+			return nil
+		},
+	},
+	{
+		number: 19,
+		kind:   kindBytes,
+		get: func(tx *indexedTx) ([]byte, bool) {
+			if tx.AuthorizationList == nil {
+				return nil, false
+			}
+			return encodeAuthorizations(tx.AuthorizationList), true
+		},
+		set: func(tx *indexedTx, payload []byte) error {
+			list, err := decodeAuthorizations(payload)
+			if err != nil {
+				return err
+			}
+			tx.AuthorizationList = list
+			return nil
+		},
+	},
+	quantityField(20, "", func(tx *indexedTx) *ethclient.Quantity { return &tx.V }),
+	quantityField(21, "", func(tx *indexedTx) *ethclient.Quantity { return &tx.R }),
+	quantityField(22, "", func(tx *indexedTx) *ethclient.Quantity { return &tx.S }),
+	quantityField(23, "", func(tx *indexedTx) *ethclient.Quantity { return &tx.YParity }),
+	quantityField(24, "", func(tx *indexedTx) *ethclient.Quantity { return &tx.BaseFeePerGas }),
+	quantityField(25, "", func(tx *indexedTx) *ethclient.Quantity { return &tx.BlockTimestamp }),
+}
+
+// A zero hash is left out and read back as zero
+func hashField(number uint64, name string, field func(tx *indexedTx) *ethclient.Hash) txField {
+	return txField{
+		number: number,
+		name:   name,
+		kind:   kindBytes,
+		get: func(tx *indexedTx) ([]byte, bool) {
+			h := field(tx)
+			return h[:], !h.IsZero()
+		},
+		set: func(tx *indexedTx, payload []byte) error {
+			h := field(tx)
+			if len(payload) != len(h) {
+				return fmt.Errorf("%w: %s of %d bytes", errCorruptRecord, name, len(payload))
+			}
+			copy(h[:], payload)
+			return nil
+		},
+	}
+}
+
+// A zero address is left out and read back as zero
+func addressField(number uint64, name string, field func(tx *indexedTx) *ethclient.Address) txField {
+	return txField{
+		number: number,
+		name:   name,
+		kind:   kindBytes,
+		get: func(tx *indexedTx) ([]byte, bool) {
+			a := field(tx)
+			return a[:], *a != ethclient.Address{}
+		},
+		set: func(tx *indexedTx, payload []byte) error {
+			a := field(tx)
+			if len(payload) != len(a) {
+				return fmt.Errorf("%w: %s of %d bytes", errCorruptRecord, name, len(payload))
+			}
+			copy(a[:], payload)
+			return nil
+		},
+	}
+}
+
+func quantityField(number uint64, name string, field func(tx *indexedTx) *ethclient.Quantity) txField {
+	return txField{
+		number: number,
+		name:   name,
+		kind:   kindQuantity,
+		get: func(tx *indexedTx) ([]byte, bool) {
+			q := field(tx)
+			return quantityBytes(*q), q.IsSet()
+		},
+		set: func(tx *indexedTx, payload []byte) error {
+			*field(tx) = ethclient.NewQuantity(new(big.Int).SetBytes(payload))
+			return nil
+		},
+	}
+}
+
+func encodeTxn(tx indexedTx) []byte {
+	b := []byte{txRecordVersion}
+	for _, f := range txFields {
+		payload, ok := f.get(&tx)
+		if !ok {
+			continue
+		}
+		b = binary.AppendUvarint(b, f.number)
+		b = append(b, f.kind)
+		b = appendBytes(b, payload)
+	}
+	return b
+}
+
+func decodeTxn(b []byte, tx *indexedTx) error {
+	*tx = indexedTx{}
+	if len(b) > 0 && b[0] == '{' {
+		// written before the binary format
+		return decodeLegacyTxn(b, tx)
+	}
+	if len(b) == 0 || b[0] != txRecordVersion {
+		return fmt.Errorf("%w: unknown version", errCorruptRecord)
+	}
+
+	rest := b[1:]
+	for len(rest) > 0 {
+		number, n := binary.Uvarint(rest)
+		if n <= 0 || len(rest) < n+1 {
+			return errCorruptRecord
+		}
+		kind := rest[n]
+		payload, remaining, err := readBytes(rest[n+1:])
+		if err != nil {
+			return err
+		}
+		rest = remaining
+
+		if kind != kindBytes && kind != kindQuantity && kind != kindString {
+			return fmt.Errorf("%w: unknown field kind %d", errCorruptRecord, kind)
+		}
+		for _, f := range txFields {
+			if f.number != number {
+				continue
+			}
+			if kind == kindString {
+				if payload, err = legacyPayload(f, string(payload)); err != nil {
+					return err
+				}
+			}
+			if err := f.set(tx, payload); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// Decodes a record of the JSON format, which held every field as the node's string
+func decodeLegacyTxn(b []byte, tx *indexedTx) error {
+	var values map[string]string
+	if err := json.Unmarshal(b, &values); err != nil {
+		return err
+	}
+	for _, f := range txFields {
+		s := values[f.name]
+		if f.name == "" || s == "" {
+			continue
+		}
+		payload, err := legacyPayload(f, s)
+		if err != nil {
+			return err
+		}
+		if err := f.set(tx, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Converts a field's hex string to its payload. Quantities may have leading zeros,
+// as some nodes return them.
+func legacyPayload(f txField, s string) ([]byte, error) {
+	digits, ok := strings.CutPrefix(s, "0x")
+	if !ok {
+		return nil, fmt.Errorf("%w: %s %q", errCorruptRecord, f.name, s)
+	}
+	if f.kind == kindQuantity {
+		n, ok := new(big.Int).SetString(digits, 16)
+		if !ok || n.Sign() < 0 {
+			return nil, fmt.Errorf("%w: %s %q", errCorruptRecord, f.name, s)
+		}
+		return n.Bytes(), nil
+	}
+	payload, err := hex.DecodeString(digits)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s %q", errCorruptRecord, f.name, s)
+	}
+	return payload, nil
+}
+
+// Access list layout: (address | uvarint key count | keys)...
+func encodeAccessList(list []ethclient.AccessListEntry) []byte {
+	var b []byte
+	for _, entry := range list {
+		b = append(b, entry.Address[:]...)
+		b = binary.AppendUvarint(b, uint64(len(entry.StorageKeys)))
+		for _, key := range entry.StorageKeys {
+			b = append(b, key[:]...)
+		}
+	}
+	return b
+}
+
+func decodeAccessList(b []byte) ([]ethclient.AccessListEntry, error) {
+	list := make([]ethclient.AccessListEntry, 0)
+	for len(b) > 0 {
+		var entry ethclient.AccessListEntry
+		if len(b) < len(entry.Address) {
+			return nil, fmt.Errorf("%w: access list", errCorruptRecord)
+		}
+		b = b[copy(entry.Address[:], b):]
+		count, n := binary.Uvarint(b)
+		if n <= 0 || count > uint64(len(b)-n)/uint64(len(ethclient.Hash{})) {
+			return nil, fmt.Errorf("%w: access list", errCorruptRecord)
+		}
+		b = b[n:]
+		keys, err := readHashes(b, int(count))
+		if err != nil {
+			return nil, err
+		}
+		entry.StorageKeys = keys
+		b = b[len(keys)*len(ethclient.Hash{}):]
+		list = append(list, entry)
+	}
+	return list, nil
+}
+
+// Authorization list layout: (chain ID | address | nonce | y parity | r | s)..., with
+// each quantity as uvarint length | big-endian bytes
+func encodeAuthorizations(list []ethclient.Authorization) []byte {
+	var b []byte
+	for _, auth := range list {
+		b = appendBytes(b, quantityBytes(auth.ChainID))
+		b = append(b, auth.Address[:]...)
+		for _, q := range []ethclient.Quantity{auth.Nonce, auth.YParity, auth.R, auth.S} {
+			b = appendBytes(b, quantityBytes(q))
+		}
+	}
+	return b
+}
+
+func decodeAuthorizations(b []byte) ([]ethclient.Authorization, error) {
+	list := make([]ethclient.Authorization, 0)
+	readQuantity := func(q *ethclient.Quantity) error {
+		payload, rest, err := readBytes(b)
+		if err != nil {
+			return err
+		}
+		*q = ethclient.NewQuantity(new(big.Int).SetBytes(payload))
+		b = rest
+		return nil
+	}
+	for len(b) > 0 {
+		var auth ethclient.Authorization
+		if err := readQuantity(&auth.ChainID); err != nil {
+			return nil, err
+		}
+		if len(b) < len(auth.Address) {
+			return nil, fmt.Errorf("%w: authorization list", errCorruptRecord)
+		}
+		b = b[copy(auth.Address[:], b):]
+		for _, q := range []*ethclient.Quantity{&auth.Nonce, &auth.YParity, &auth.R, &auth.S} {
+			if err := readQuantity(q); err != nil {
+				return nil, err
+			}
+		}
+		list = append(list, auth)
+	}
+	return list, nil
+}
+
+// Returns the big-endian bytes of the quantity, none if it is zero or unset
+func quantityBytes(q ethclient.Quantity) []byte {
+	if !q.IsSet() {
+		return nil
+	}
+	return q.Big().Bytes()
+}
+
+// Reads count consecutive hashes from the start of b
+func readHashes(b []byte, count int) ([]ethclient.Hash, error) {
+	if count*len(ethclient.Hash{}) > len(b) {
+		return nil, errCorruptRecord
+	}
+	hashes := make([]ethclient.Hash, count)
+	for i := range hashes {
+		b = b[copy(hashes[i][:], b):]
+	}
+	return hashes, nil
+}
+
+func appendBytes(b []byte, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// Reads a uvarint length-prefixed byte slice and returns it with the remaining input
+func readBytes(b []byte) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(b)
+	if n <= 0 || length > uint64(len(b)-n) {
+		return nil, nil, errCorruptRecord
+	}
+	b = b[n:]
+	return b[:length], b[length:], nil
+}
+
+// Rewrites transaction histories stored as JSON records in the binary format
+func migrateTxEncoding(db datastore.DataStore) error {
+	addresses, err := listKeys(db, txKeyPrefix)
+	if err != nil {
+		return err
+	}
+
+	return db.Batch(func(batch datastore.Batch) error {
+		for _, address := range addresses {
+			key := txKeyPrefix + address
+			values, err := batch.Get(key)
+			if err != nil {
+				return err
+			}
+
+			legacy := false
+			for _, v := range values {
+				legacy = legacy || (len(v) > 0 && v[0] == '{')
+			}
+			if !legacy {
+				continue
+			}
+
+			txs, err := deserializeTxn(values)
+			if err != nil {
+				return fmt.Errorf("error decoding transactions of %s: %w", address, err)
+			}
+			values, err = serializeTxn(txs)
+			if err != nil {
+				return err
+			}
+			if err := batch.Put(key, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,104 @@
+package parser_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+func TestTransactionStatus(t *testing.T) {
+	const (
+		alice = "0x00000000000000000000000000000000000a11ce"
+		bob   = "0x0000000000000000000000000000000000000b0b"
+	)
+
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithConfirmations(3))
+	p.Subscribe(alice)
+	p.Subscribe(bob)
+
+	node.mine(newTx(1, alice, bob, 0))
+	node.mine(newTx(2, alice, bob, 0))
+	node.mine(newTx(3, alice, bob, 0))
+	node.mine()
+	node.finalized = 11
+
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[ethclient.Hash]struct {
+		confirmations int
+		status        parser.TxStatus
+	}{
+		testHash(1): {4, parser.StatusFinalized},
+		testHash(2): {3, parser.StatusSettled},
+		testHash(3): {2, parser.StatusSeen},
+	}
+
+	txs := p.GetTransactions(alice)
+	if len(txs) != len(want) {
+		t.Fatalf("expected %d transactions, got %d", len(want), len(txs))
+	}
+	for _, tx := range txs {
+		w := want[tx.Hash]
+		if tx.Confirmations != w.confirmations {
+			t.Errorf("expected %s to have %d confirmations, got %d", tx.Hash, w.confirmations, tx.Confirmations)
+		}
+		if tx.Status != w.status {
+			t.Errorf("expected %s to be %s, got %s", tx.Hash, w.status, tx.Status)
+		}
+	}
+}
+
+func TestFollowHeadTag(t *testing.T) {
+	node := newFakeNode(t, 20)
+	node.safe = 15
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithHeadTag(ethclient.SafeTag))
+
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.GetCurrentBlock() != 15 {
+		t.Fatalf("expected scanner to stop at safe block 15, got %d", p.GetCurrentBlock())
+	}
+}
+
+// Reads the scanner's position and the status of transactions while blocks are scanned,
+// as API handlers do. Meant to be run with -race.
+func TestStatusWhileScanning(t *testing.T) {
+	const bob = "0x0000000000000000000000000000000000000b0b"
+
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithConfirmations(3))
+	p.Subscribe(alice)
+	for i := 1; i <= 20; i++ {
+		node.mine(newTx(i, alice, carol, 0))
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- p.ScanAll(context.Background())
+	}()
+	for scanning := true; scanning; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			scanning = false
+		default:
+			p.GetCurrentBlock()
+			p.GetTransactions(alice)
+			p.Subscribe(bob)
+		}
+	}
+
+	if p.GetCurrentBlock() != 30 {
+		t.Fatalf("expected current block 30, got %d", p.GetCurrentBlock())
+	}
+}
@@ -0,0 +1,635 @@
+package parser_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/websocket"
+)
+
+// fakeNode is an in-memory JSON-RPC node serving a chain that tests can extend and fork.
+type fakeNode struct {
+	mu     sync.Mutex
+	blocks []ethclient.Block
+	// blocks replaced by forks, still served by hash as nodes keep recent side chains
+	orphans []ethclient.Block
+	// fork counter mixed into block hashes so that blocks on a new branch get new hashes
+	forks int
+	// block numbers reported for the "safe" and "finalized" tags
+	safe      int
+	finalized int
+	server    *httptest.Server
+	// open newHeads subscriptions by their connection, and the number of subscriptions made
+	subscribers map[*websocket.Conn]string
+	subscribes  int
+	// close newHeads subscriptions right after confirming them, like a misbehaving node
+	dropSubscriptions bool
+	// hashes of transactions whose receipts report a revert
+	reverted map[string]bool
+	// answer eth_getBlockReceipts with "method not found", like nodes without it do
+	noBlockReceipts bool
+	// logs emitted into the next mined block, and the logs of mined blocks by block hash
+	pendingLogs []ethclient.Log
+	// withdrawals paid by the next mined block
+	pendingWithdrawals []ethclient.Withdrawal
+	logs               map[string][]ethclient.Log
+	// call trees of transactions by hash, and whether to answer debug_traceBlockByNumber
+	// with "method not found" like nodes that only offer trace_block
+	calls        map[string]ethclient.CallFrame
+	noDebugTrace bool
+	// answer trace_filter with "method not found", like nodes without the trace API
+	noTraceFilter bool
+	// base fee of blocks mined from now on, none as before the London fork if 0
+	baseFee int
+	// called with the method of every request before it is answered, if set
+	onCall func(method string)
+	// JSON-RPC error codes to answer methods with instead of their result
+	failures map[string]int
+}
+
+// Returns the hash numbered n, standing in for a transaction hash in fixtures
+func testHash(n int) ethclient.Hash {
+	var h ethclient.Hash
+	binary.BigEndian.PutUint64(h[24:], uint64(n))
+	return h
+}
+
+// Returns the hash of the block numbered n, distinct from any testHash
+func blockHash(n int) ethclient.Hash {
+	h := testHash(n)
+	h[0] = 0xb1
+	return h
+}
+
+// Returns the time of the block numbered n, produced every 12 seconds
+func blockTime(n int) time.Time {
+	return time.Unix(1700000000+12*int64(n), 0).UTC()
+}
+
+// Returns a transaction numbered n sending value wei between the addresses
+func newTx(n int, from, to string, value int) ethclient.Transaction {
+	tx := ethclient.Transaction{
+		Hash:  testHash(n),
+		From:  mustAddress(from),
+		Value: ethclient.IntQuantity(value),
+	}
+	recipient := mustAddress(to)
+	tx.To = &recipient
+	return tx
+}
+
+// Returns a dynamic fee transaction numbered n with the given fee cap and priority fee
+func newDynamicFeeTx(n int, from, to string, maxFee, tip int) ethclient.Transaction {
+	tx := newTx(n, from, to, 0)
+	tx.Type = ethclient.IntQuantity(ethclient.DynamicFeeTxType)
+	tx.MaxFeePerGas = ethclient.IntQuantity(maxFee)
+	tx.MaxPriorityFeePerGas = ethclient.IntQuantity(tip)
+	tx.AccessList = []ethclient.AccessListEntry{}
+	return tx
+}
+
+func mustAddress(s string) ethclient.Address {
+	a, err := ethclient.ParseAddress(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Starts a fake node whose chain contains blocks 0 through head without transactions.
+func newFakeNode(t *testing.T, head int) *fakeNode {
+	n := &fakeNode{
+		subscribers: make(map[*websocket.Conn]string),
+		reverted:    make(map[string]bool),
+		logs:        make(map[string][]ethclient.Log),
+		calls:       make(map[string]ethclient.CallFrame),
+	}
+	for i := 0; i <= head; i++ {
+		n.mine()
+	}
+	n.server = httptest.NewServer(http.HandlerFunc(n.handleRPC))
+	t.Cleanup(n.server.Close)
+	return n
+}
+
+func (n *fakeNode) URL() string {
+	return n.server.URL
+}
+
+func (n *fakeNode) WebSocketURL() string {
+	return "ws" + strings.TrimPrefix(n.server.URL, "http")
+}
+
+// Appends a block containing the given transactions to the canonical chain
+// and notifies newHeads subscribers.
+func (n *fakeNode) mine(txs ...ethclient.Transaction) ethclient.Block {
+	block := n.appendBlock(txs)
+	n.notifyHead(block.Header)
+	return block
+}
+
+func (n *fakeNode) appendBlock(txs []ethclient.Transaction) ethclient.Block {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	number := len(n.blocks)
+	header := ethclient.Header{
+		Number:    ethclient.IntQuantity(number),
+		Hash:      blockHash(n.forks<<32 | number),
+		Timestamp: ethclient.IntQuantity(int(blockTime(number).Unix())),
+	}
+	if number > 0 {
+		header.ParentHash = n.blocks[number-1].Hash
+	}
+	if n.baseFee > 0 {
+		header.BaseFeePerGas = ethclient.IntQuantity(n.baseFee)
+	}
+
+	for i := range txs {
+		txs[i].BlockNumber = header.Number
+		txs[i].BlockHash = header.Hash
+		txs[i].TransactionIndex = ethclient.IntQuantity(i)
+	}
+
+	for i, log := range n.pendingLogs {
+		log.BlockNumber = header.Number.String()
+		log.BlockHash = header.Hash.Hex()
+		log.LogIndex = fmt.Sprintf("0x%x", i)
+		n.logs[log.BlockHash] = append(n.logs[log.BlockHash], log)
+	}
+	n.pendingLogs = nil
+
+	block := ethclient.Block{Header: header, Transactions: txs, Withdrawals: n.pendingWithdrawals}
+	n.pendingWithdrawals = nil
+	n.blocks = append(n.blocks, block)
+	return block
+}
+
+// Adds withdrawals to the next mined block
+func (n *fakeNode) withdraw(withdrawals ...ethclient.Withdrawal) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pendingWithdrawals = append(n.pendingWithdrawals, withdrawals...)
+}
+
+// Adds logs to the next mined block
+func (n *fakeNode) emit(logs ...ethclient.Log) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pendingLogs = append(n.pendingLogs, logs...)
+}
+
+// Drops every block after the given block number so that subsequently mined blocks form a new branch.
+func (n *fakeNode) fork(at int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.orphans = append(n.orphans, n.blocks[at+1:]...)
+	n.blocks = n.blocks[:at+1]
+	n.forks++
+}
+
+// Resolves a block number or tag to a block on the canonical chain.
+func (n *fakeNode) resolveTag(tag string) (int, bool) {
+	switch tag {
+	case ethclient.LatestTag:
+		return len(n.blocks) - 1, true
+	case ethclient.SafeTag:
+		return n.safe, true
+	case ethclient.FinalizedTag:
+		return n.finalized, true
+	}
+	number, err := strconv.ParseInt(tag[2:], 16, 64)
+	if err != nil || int(number) >= len(n.blocks) {
+		return 0, false
+	}
+	return int(number), true
+}
+
+func (n *fakeNode) notifyHead(header ethclient.Header) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for conn, id := range n.subscribers {
+		b, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  ethclient.SubscriptionMethod,
+			"params":  map[string]interface{}{"subscription": id, "result": header},
+		})
+		conn.WriteMessage(b)
+	}
+}
+
+// Closes every WebSocket connection, as if the node dropped them.
+func (n *fakeNode) dropSubscribers() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for conn := range n.subscribers {
+		conn.Close()
+		delete(n.subscribers, conn)
+	}
+}
+
+func (n *fakeNode) subscribeCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.subscribes
+}
+
+func (n *fakeNode) subscriberCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.subscribers)
+}
+
+// Serves eth_subscribe("newHeads") over a WebSocket connection
+func (n *fakeNode) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	message, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+	var req struct {
+		ID     int      `json:"id"`
+		Method string   `json:"method"`
+		Params []string `json:"params"`
+	}
+	json.Unmarshal(message, &req)
+	if req.Method != ethclient.SubscribeMethod || len(req.Params) == 0 || req.Params[0] != ethclient.NewHeadsEvent {
+		return
+	}
+
+	id := fmt.Sprintf("0x%x", req.ID)
+	n.mu.Lock()
+	b, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": id})
+	conn.WriteMessage(b)
+	n.subscribes++
+	if n.dropSubscriptions {
+		n.mu.Unlock()
+		return
+	}
+	n.subscribers[conn] = id
+	n.mu.Unlock()
+
+	// hold the connection until the client or dropSubscribers closes it
+	for {
+		if _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	n.mu.Lock()
+	delete(n.subscribers, conn)
+	n.mu.Unlock()
+}
+
+func (n *fakeNode) handleRPC(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		n.handleWebSocket(w, r)
+		return
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(body) > 0 && body[0] == '[' {
+		var reqs []rpcRequest
+		json.Unmarshal(body, &reqs)
+		responses := make([]map[string]interface{}, 0, len(reqs))
+		for _, req := range reqs {
+			responses = append(responses, n.call(req))
+		}
+		json.NewEncoder(w).Encode(responses)
+		return
+	}
+
+	var req rpcRequest
+	json.Unmarshal(body, &req)
+	json.NewEncoder(w).Encode(n.call(req))
+}
+
+type rpcRequest struct {
+	ID     int               `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// Answers a single JSON-RPC call. Must be called with the lock held.
+func (n *fakeNode) call(req rpcRequest) map[string]interface{} {
+	response := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	if n.onCall != nil {
+		n.onCall(req.Method)
+	}
+	if code, ok := n.failures[req.Method]; ok {
+		response["error"] = map[string]interface{}{"code": code, "message": "request failed"}
+		return response
+	}
+
+	var result interface{}
+	switch req.Method {
+	case ethclient.GetCurrentBlocknumberMethod:
+		result = fmt.Sprintf("0x%x", len(n.blocks)-1)
+	case ethclient.GetBlockByNumberMethod:
+		var tag string
+		var full bool
+		json.Unmarshal(req.Params[0], &tag)
+		json.Unmarshal(req.Params[1], &full)
+		number, ok := n.resolveTag(tag)
+		if !ok {
+			break
+		}
+		result = blockResult(n.blocks[number], full)
+	case ethclient.GetBlockByHashMethod:
+		var hash ethclient.Hash
+		var full bool
+		json.Unmarshal(req.Params[0], &hash)
+		json.Unmarshal(req.Params[1], &full)
+		for _, blocks := range [][]ethclient.Block{n.blocks, n.orphans} {
+			for _, block := range blocks {
+				if block.Hash == hash {
+					result = blockResult(block, full)
+				}
+			}
+		}
+	case ethclient.GetBlockReceiptsMethod:
+		if n.noBlockReceipts {
+			response["error"] = map[string]interface{}{"code": ethclient.CodeMethodNotFound, "message": "method not found"}
+			return response
+		}
+		var tag string
+		json.Unmarshal(req.Params[0], &tag)
+		number, ok := n.resolveTag(tag)
+		if !ok {
+			break
+		}
+		receipts := make([]ethclient.Receipt, 0)
+		for _, tx := range n.blocks[number].Transactions {
+			receipts = append(receipts, n.receipt(tx))
+		}
+		result = receipts
+	case ethclient.GetTransactionReceiptMethod:
+		var hash string
+		json.Unmarshal(req.Params[0], &hash)
+		for _, block := range n.blocks {
+			for _, tx := range block.Transactions {
+				if tx.Hash.Hex() == hash {
+					result = n.receipt(tx)
+				}
+			}
+		}
+	case ethclient.GetLogsMethod:
+		var filter ethclient.LogFilter
+		json.Unmarshal(req.Params[0], &filter)
+		hashes := []string{filter.BlockHash}
+		if filter.BlockHash == "" {
+			hashes = nil
+			for _, block := range n.blockRange(filter.FromBlock, filter.ToBlock) {
+				hashes = append(hashes, block.Hash.Hex())
+			}
+		}
+		logs := make([]ethclient.Log, 0)
+		for _, hash := range hashes {
+			for _, log := range n.logs[hash] {
+				if matchTopics(log, filter.Topics) {
+					logs = append(logs, log)
+				}
+			}
+		}
+		result = logs
+	case ethclient.GetTransactionByHashMethod:
+		var hash string
+		json.Unmarshal(req.Params[0], &hash)
+		for _, block := range n.blocks {
+			for _, tx := range block.Transactions {
+				if tx.Hash.Hex() == hash {
+					result = tx
+				}
+			}
+		}
+	case ethclient.TraceBlockByNumberMethod:
+		if n.noDebugTrace {
+			response["error"] = map[string]interface{}{"code": ethclient.CodeMethodNotFound, "message": "method not found"}
+			return response
+		}
+		var tag string
+		json.Unmarshal(req.Params[0], &tag)
+		number, ok := n.resolveTag(tag)
+		if !ok {
+			break
+		}
+		traces := make([]ethclient.TransactionTrace, 0)
+		for _, tx := range n.blocks[number].Transactions {
+			traces = append(traces, ethclient.TransactionTrace{TxHash: tx.Hash.Hex(), Result: n.callFrame(tx)})
+		}
+		result = traces
+	case ethclient.TraceBlockMethod:
+		var tag string
+		json.Unmarshal(req.Params[0], &tag)
+		number, ok := n.resolveTag(tag)
+		if !ok {
+			break
+		}
+		traces := make([]ethclient.Trace, 0)
+		for _, tx := range n.blocks[number].Transactions {
+			traces = appendFlatTraces(traces, n.blocks[number], tx.Hash.Hex(), n.callFrame(tx), []int{})
+		}
+		result = traces
+	case ethclient.TraceFilterMethod:
+		if n.noTraceFilter {
+			response["error"] = map[string]interface{}{"code": ethclient.CodeMethodNotFound, "message": "method not found"}
+			return response
+		}
+		var filter ethclient.TraceFilter
+		json.Unmarshal(req.Params[0], &filter)
+		traces := make([]ethclient.Trace, 0)
+		for _, block := range n.blockRange(filter.FromBlock, filter.ToBlock) {
+			var blockTraces []ethclient.Trace
+			for _, tx := range block.Transactions {
+				blockTraces = appendFlatTraces(blockTraces, block, tx.Hash.Hex(), n.callFrame(tx), []int{})
+			}
+			for _, trace := range blockTraces {
+				from, to := trace.Action.From, trace.Action.To
+				if trace.Type == "suicide" {
+					from, to = trace.Action.Address, trace.Action.RefundAddress
+				} else if trace.Type == "create" {
+					to = trace.Result.Address
+				}
+				if (len(filter.FromAddress) == 0 || slices.Contains(filter.FromAddress, from)) &&
+					(len(filter.ToAddress) == 0 || slices.Contains(filter.ToAddress, to)) {
+					traces = append(traces, trace)
+				}
+			}
+		}
+		result = traces
+	default:
+		response["error"] = map[string]interface{}{"code": ethclient.CodeMethodNotFound, "message": "method not found"}
+		return response
+	}
+
+	response["result"] = result
+	return response
+}
+
+// Returns the block as eth_getBlockByNumber and eth_getBlockByHash return it, with only
+// the hashes of its transactions unless full
+func blockResult(block ethclient.Block, full bool) interface{} {
+	if full {
+		return block
+	}
+	hashes := make([]ethclient.Hash, 0, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		hashes = append(hashes, tx.Hash)
+	}
+	return struct {
+		ethclient.Block
+		Transactions []ethclient.Hash `json:"transactions"`
+	}{block, hashes}
+}
+
+// Returns the canonical blocks from..to, given as hex block numbers. Must be called with the lock held.
+func (n *fakeNode) blockRange(from, to string) []ethclient.Block {
+	start, ok := n.resolveTag(from)
+	if !ok {
+		return nil
+	}
+	end, ok := n.resolveTag(to)
+	if !ok {
+		end = len(n.blocks) - 1
+	}
+	return n.blocks[start : end+1]
+}
+
+func (n *fakeNode) receipt(tx ethclient.Transaction) ethclient.Receipt {
+	status := "0x1"
+	if n.reverted[tx.Hash.Hex()] {
+		status = "0x0"
+	}
+	var to string
+	if tx.To != nil {
+		to = tx.To.Hex()
+	}
+	price := "0x3b9aca00"
+	if number, err := tx.BlockNumber.Int(); err == nil && number < len(n.blocks) {
+		if p := tx.EffectiveGasPrice(n.blocks[number].BaseFeePerGas); p.IsSet() {
+			price = p.String()
+		}
+	}
+	receipt := ethclient.Receipt{
+		TransactionHash:   tx.Hash.Hex(),
+		BlockHash:         tx.BlockHash.Hex(),
+		BlockNumber:       tx.BlockNumber.String(),
+		From:              tx.From.Hex(),
+		To:                to,
+		Status:            status,
+		GasUsed:           "0x5208",
+		EffectiveGasPrice: price,
+		Logs:              n.txLogs(tx),
+	}
+	if len(tx.BlobVersionedHashes) > 0 {
+		// each blob uses 2^17 blob gas
+		receipt.BlobGasUsed = fmt.Sprintf("0x%x", len(tx.BlobVersionedHashes)<<17)
+		receipt.BlobGasPrice = "0x1"
+	}
+	return receipt
+}
+
+func (n *fakeNode) txLogs(tx ethclient.Transaction) []ethclient.Log {
+	logs := make([]ethclient.Log, 0)
+	for _, log := range n.logs[tx.BlockHash.Hex()] {
+		if log.TransactionHash == tx.Hash.Hex() {
+			logs = append(logs, log)
+		}
+	}
+	return logs
+}
+
+// Returns the call tree of the transaction, a plain call without subcalls unless set in calls
+func (n *fakeNode) callFrame(tx ethclient.Transaction) ethclient.CallFrame {
+	if frame, ok := n.calls[tx.Hash.Hex()]; ok {
+		return frame
+	}
+	frame := ethclient.CallFrame{Type: "CALL", From: tx.From.Hex(), Value: tx.Value.String()}
+	if tx.To != nil {
+		frame.To = tx.To.Hex()
+	}
+	return frame
+}
+
+// Flattens a call tree into trace_block traces
+func appendFlatTraces(
+	traces []ethclient.Trace,
+	block ethclient.Block,
+	txHash string,
+	frame ethclient.CallFrame,
+	traceAddress []int,
+) []ethclient.Trace {
+	number, _ := block.Number.Int()
+	trace := ethclient.Trace{
+		Error:           frame.Error,
+		Subtraces:       len(frame.Calls),
+		TraceAddress:    traceAddress,
+		TransactionHash: txHash,
+		BlockHash:       block.Hash.Hex(),
+		BlockNumber:     number,
+		TransactionPosition: slices.IndexFunc(block.Transactions, func(tx ethclient.Transaction) bool {
+			return tx.Hash.Hex() == txHash
+		}),
+	}
+	switch frame.Type {
+	case "CREATE", "CREATE2":
+		trace.Type = "create"
+		trace.Action = ethclient.TraceAction{From: frame.From, Value: frame.Value}
+		trace.Result = &ethclient.TraceResult{Address: frame.To}
+	case "SELFDESTRUCT":
+		trace.Type = "suicide"
+		trace.Action = ethclient.TraceAction{Address: frame.From, RefundAddress: frame.To, Balance: frame.Value}
+	default:
+		trace.Type = "call"
+		trace.Action = ethclient.TraceAction{
+			CallType: strings.ToLower(frame.Type),
+			From:     frame.From,
+			To:       frame.To,
+			Value:    frame.Value,
+		}
+		trace.Result = &ethclient.TraceResult{}
+	}
+
+	traces = append(traces, trace)
+	for i, call := range frame.Calls {
+		traces = appendFlatTraces(traces, block, txHash, call, append(slices.Clone(traceAddress), i))
+	}
+	return traces
+}
+
+// Reports whether the log matches an eth_getLogs topic filter
+func matchTopics(log ethclient.Log, topics [][]string) bool {
+	for i, options := range topics {
+		if len(options) == 0 {
+			continue
+		}
+		if i >= len(log.Topics) || !slices.Contains(options, log.Topics[i]) {
+			return false
+		}
+	}
+	return true
+}
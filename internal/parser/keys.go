@@ -0,0 +1,154 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+)
+
+// Datastore key layout. Keys are namespaced by prefix so that different kinds of
+// records can share one datastore. Addresses are keyed in lowercase, so that lookups
+// match regardless of the case, and checksum, an address is given in.
+const (
+	// subscription metadata of a subscribed address
+	subKeyPrefix = "sub/"
+	// transaction history of a subscribed address
+	txKeyPrefix = "tx/"
+	// ERC-20 transfers to or from a subscribed address
+	tokenKeyPrefix = "token/"
+	// ERC-721 and ERC-1155 transfers to or from a subscribed address
+	nftKeyPrefix = "nft/"
+	// internal value transfers to or from a subscribed address
+	internalKeyPrefix = "internal/"
+	// beacon chain withdrawals to a subscribed address
+	withdrawalKeyPrefix = "withdrawal/"
+	// receipt of an indexed transaction, by transaction hash
+	receiptKeyPrefix = "receipt/"
+	// backfill job of a subscribed address
+	backfillKeyPrefix = "backfill/"
+	// webhook delivery waiting to be sent, by delivery ID
+	outboxKeyPrefix = "webhook/outbox/"
+	// webhook delivery that ran out of attempts, by delivery ID
+	deadLetterKeyPrefix = "webhook/dead/"
+	// last block fully processed by the scanner
+	checkpointKey = "scanner/checkpoint"
+)
+
+func subKey(address string) string {
+	return subKeyPrefix + strings.ToLower(address)
+}
+
+func txKey(address string) string {
+	return txKeyPrefix + strings.ToLower(address)
+}
+
+func tokenKey(address string) string {
+	return tokenKeyPrefix + strings.ToLower(address)
+}
+
+func nftKey(address string) string {
+	return nftKeyPrefix + strings.ToLower(address)
+}
+
+func internalKey(address string) string {
+	return internalKeyPrefix + strings.ToLower(address)
+}
+
+func withdrawalKey(address string) string {
+	return withdrawalKeyPrefix + strings.ToLower(address)
+}
+
+func receiptKey(hash string) string {
+	return receiptKeyPrefix + hash
+}
+
+func backfillKey(address string) string {
+	return backfillKeyPrefix + strings.ToLower(address)
+}
+
+func outboxKey(id string) string {
+	return outboxKeyPrefix + id
+}
+
+func deadLetterKey(id string) string {
+	return deadLetterKeyPrefix + id
+}
+
+// Returns the keys with the given prefix, with the prefix stripped
+func listKeys(db datastore.DataStore, prefix string) ([]string, error) {
+	keys, err := db.List()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			result = append(result, strings.TrimPrefix(key, prefix))
+		}
+	}
+	return result, nil
+}
+
+// Moves records keyed by a mixed-case address, as stored before addresses were normalized,
+// to the lowercase key. Histories found under both keys are concatenated; of two
+// subscription or backfill records the lowercase one is kept.
+func migrateAddressKeys(db datastore.DataStore) error {
+	keys, err := db.List()
+	if err != nil {
+		return err
+	}
+
+	return db.Batch(func(batch datastore.Batch) error {
+		for _, key := range keys {
+			lower := strings.ToLower(key)
+			if lower == key {
+				continue
+			}
+
+			v, err := batch.Get(key)
+			if err != nil {
+				return err
+			}
+			switch {
+			case strings.HasPrefix(key, subKeyPrefix):
+				if !batch.Has(lower) {
+					subscription, err := decodeSubscription(v)
+					if err != nil {
+						return err
+					}
+					subscription.Address = strings.ToLower(subscription.Address)
+					if err := putSubscription(batch, subscription); err != nil {
+						return err
+					}
+				}
+			case strings.HasPrefix(key, backfillKeyPrefix):
+				if !batch.Has(lower) {
+					if err := batch.Put(lower, v); err != nil {
+						return err
+					}
+				}
+			case strings.HasPrefix(key, txKeyPrefix),
+				strings.HasPrefix(key, tokenKeyPrefix),
+				strings.HasPrefix(key, nftKeyPrefix),
+				strings.HasPrefix(key, internalKeyPrefix),
+				strings.HasPrefix(key, withdrawalKeyPrefix):
+				existing, err := batch.Get(lower)
+				if err != nil && !errors.Is(err, datastore.KeyDoesNotExist) {
+					return err
+				}
+				if err := batch.Put(lower, append(existing, v...)); err != nil {
+					return err
+				}
+			default:
+				continue
+			}
+
+			if err := batch.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
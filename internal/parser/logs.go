@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"context"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Topics of the event logs the scanner indexes, depending on its options
+func (b *Scanner) eventTopics() []string {
+	topics := make([]string, 0, 3)
+	if b.tokens || b.nfts {
+		topics = append(topics, TransferEventTopic)
+	}
+	if b.nfts {
+		topics = append(topics, TransferSingleEventTopic, TransferBatchEventTopic)
+	}
+	return topics
+}
+
+// Returns the block's logs of indexed events. Logs are selected by block hash so they
+// always belong to the block being indexed. Returns nil without calling the node if
+// no events are indexed.
+func (b *Scanner) fetchEventLogs(ctx context.Context, blockHash string) ([]ethclient.Log, error) {
+	topics := b.eventTopics()
+	if len(topics) == 0 {
+		return nil, nil
+	}
+
+	logs, err := b.ethClient.GetLogs(ctx, ethclient.LogFilter{
+		BlockHash: blockHash,
+		Topics:    [][]string{topics},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ethclient.Log, 0, len(logs))
+	for _, log := range logs {
+		if !log.Removed {
+			result = append(result, log)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"context"
+	"time"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Backoff between attempts to reconnect the new heads subscription
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// Keeps a newHeads subscription open and signals wake for every new head.
+// Reconnects with exponential backoff whenever the subscription drops; while it
+// is down wsConnected is false and StartScan falls back to polling. StartScan also
+// polls while no head arrived for a scan interval, in case the node stops notifying.
+// The backoff is only reset once a subscription delivered a head, so a node that
+// accepts subscriptions and drops them straight away is not redialed in a loop.
+func (b *Scanner) followNewHeads(ctx context.Context, wake chan<- struct{}) {
+	backoff := minReconnectBackoff
+	for ctx.Err() == nil {
+		sub, err := ethclient.SubscribeNewHeads(ctx, b.wsEndpoint)
+		if err != nil {
+			b.logger.Printf("error subscribing to new heads, retrying in %v: %v", backoff, err)
+			if !sleep(ctx, backoff) {
+				return
+			}
+			backoff = min(2*backoff, maxReconnectBackoff)
+			continue
+		}
+
+		b.logger.Println("Subscribed to new heads on " + b.wsEndpoint)
+		b.lastHeadAt.Store(time.Now().UnixNano())
+		b.wsConnected.Store(true)
+		// catch up on blocks produced while the subscription was down
+		notify(wake)
+
+		for range sub.Heads() {
+			backoff = minReconnectBackoff
+			b.lastHeadAt.Store(time.Now().UnixNano())
+			notify(wake)
+		}
+
+		b.wsConnected.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		b.logger.Printf("new heads subscription dropped, falling back to polling and reconnecting in %v: %v", backoff, sub.Err())
+		if !sleep(ctx, backoff) {
+			return
+		}
+		backoff = min(2*backoff, maxReconnectBackoff)
+	}
+}
+
+// Signals ch without blocking. A pending signal already covers the new one.
+func notify(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Waits for d, returning false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
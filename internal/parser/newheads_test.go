@@ -0,0 +1,152 @@
+package parser_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+// Waits until the scanner reaches the given block or the timeout expires
+func waitForBlock(t *testing.T, p *parser.Parser, blockNumber int, timeout time.Duration) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if p.Stats().LastBlock >= blockNumber {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestScanOnNewHeads(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(
+		log.New(io.Discard, "", 0),
+		node.URL(),
+		10,
+		parser.WithWebSocketEndpoint(node.WebSocketURL()),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// polling alone would not pick up new blocks within the test
+		p.StartScan(ctx, time.Hour)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for node.subscriberCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if node.subscriberCount() == 0 {
+		t.Fatal("expected scanner to subscribe to new heads")
+	}
+
+	node.mine()
+	node.mine()
+	if !waitForBlock(t, p, 12, time.Second) {
+		t.Fatalf("expected scanner to process new heads, at block %d", p.Stats().LastBlock)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestNewHeadsFallbackToPolling(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(
+		log.New(io.Discard, "", 0),
+		node.URL(),
+		10,
+		parser.WithWebSocketEndpoint(node.WebSocketURL()),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.StartScan(ctx, 20*time.Millisecond)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for node.subscriberCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Blocks mined while the socket is down are picked up by polling
+	node.dropSubscribers()
+	node.appendBlock(nil)
+	if !waitForBlock(t, p, 11, 500*time.Millisecond) {
+		t.Fatalf("expected polling to pick up new blocks, at block %d", p.Stats().LastBlock)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestNewHeadsStalled(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(
+		log.New(io.Discard, "", 0),
+		node.URL(),
+		10,
+		parser.WithWebSocketEndpoint(node.WebSocketURL()),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.StartScan(ctx, 20*time.Millisecond)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for node.subscriberCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the subscription stays up but the node stops notifying new heads
+	node.appendBlock(nil)
+	if !waitForBlock(t, p, 11, 500*time.Millisecond) {
+		t.Fatalf("expected polling to pick up new blocks, at block %d", p.Stats().LastBlock)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestNewHeadsReconnectBackoff(t *testing.T) {
+	node := newFakeNode(t, 10)
+	node.dropSubscriptions = true
+	p := parser.New(
+		log.New(io.Discard, "", 0),
+		node.URL(),
+		10,
+		parser.WithWebSocketEndpoint(node.WebSocketURL()),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.StartScan(ctx, time.Hour)
+	}()
+
+	// subscriptions dropped right away are redialed after 1s, then 2s
+	time.Sleep(1500 * time.Millisecond)
+	cancel()
+	<-done
+	if n := node.subscribeCount(); n != 2 {
+		t.Fatalf("expected 2 subscriptions within 1.5s, got %d", n)
+	}
+}
@@ -0,0 +1,265 @@
+package parser
+
+import (
+	"encoding/hex"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Topics of the ERC-1155 TransferSingle(address,address,address,uint256,uint256) and
+// TransferBatch(address,address,address,uint256[],uint256[]) events
+const (
+	TransferSingleEventTopic = "0xc3d58168c5ae7397731d063d5bbf3d657854427343f4c083240f7aacaa2d0f62"
+	TransferBatchEventTopic  = "0x4a39dc06d4c0dbc64b70af90fd698a233a518aa5d07e595d983b8c0526c8f7fb"
+)
+
+// Token standard of an NFT transfer
+type NFTStandard string
+
+const (
+	ERC721  NFTStandard = "erc721"
+	ERC1155 NFTStandard = "erc1155"
+)
+
+// NFTTransfer is an ERC-721 or ERC-1155 transfer decoded from an event log.
+// TokenIDs and Amounts are parallel; ERC-721 transfers move a single token with amount 1
+// and ERC-1155 TransferBatch events move several.
+type NFTTransfer struct {
+	Standard NFTStandard `json:"standard"`
+	// address of the contract that emitted the event
+	Collection string `json:"collection"`
+	// account that initiated an ERC-1155 transfer, empty for ERC-721
+	Operator        string   `json:"operator,omitempty"`
+	From            string   `json:"from"`
+	To              string   `json:"to"`
+	TokenIDs        []string `json:"tokenIds"`
+	Amounts         []string `json:"amounts"`
+	TransactionHash string   `json:"transactionHash"`
+	BlockNumber     string   `json:"blockNumber"`
+	BlockHash       string   `json:"blockHash"`
+	LogIndex        string   `json:"logIndex"`
+}
+
+// NFTHolding is a token an address currently holds according to its indexed transfers
+type NFTHolding struct {
+	Standard   NFTStandard `json:"standard"`
+	Collection string      `json:"collection"`
+	TokenID    string      `json:"tokenId"`
+	// hex quantity, always 0x1 for ERC-721
+	Amount string `json:"amount"`
+}
+
+// Decodes an ERC-721 Transfer, ERC-1155 TransferSingle or TransferBatch log.
+// Returns false for any other log.
+func decodeNFTTransfer(log ethclient.Log) (NFTTransfer, bool) {
+	if len(log.Topics) != 4 {
+		return NFTTransfer{}, false
+	}
+
+	transfer := NFTTransfer{
+		Collection:      strings.ToLower(log.Address),
+		TransactionHash: log.TransactionHash,
+		BlockNumber:     log.BlockNumber,
+		BlockHash:       log.BlockHash,
+		LogIndex:        log.LogIndex,
+	}
+
+	var from, to string
+	var ok bool
+	switch log.Topics[0] {
+	case TransferEventTopic:
+		// ERC-721 indexes the token ID, ERC-20 transfers have only three topics
+		transfer.Standard = ERC721
+		tokenID, ok := wordQuantity(log.Topics[3])
+		if !ok {
+			return NFTTransfer{}, false
+		}
+		transfer.TokenIDs = []string{tokenID}
+		transfer.Amounts = []string{"0x1"}
+		from, to = log.Topics[1], log.Topics[2]
+	case TransferSingleEventTopic, TransferBatchEventTopic:
+		transfer.Standard = ERC1155
+		if transfer.Operator, ok = topicAddress(log.Topics[1]); !ok {
+			return NFTTransfer{}, false
+		}
+		words, ok := dataWords(log.Data)
+		if !ok {
+			return NFTTransfer{}, false
+		}
+		if log.Topics[0] == TransferSingleEventTopic {
+			if len(words) != 2 {
+				return NFTTransfer{}, false
+			}
+			transfer.TokenIDs = []string{quantity(words[0])}
+			transfer.Amounts = []string{quantity(words[1])}
+		} else {
+			if transfer.TokenIDs, ok = decodeUintArray(words, 0); !ok {
+				return NFTTransfer{}, false
+			}
+			if transfer.Amounts, ok = decodeUintArray(words, 1); !ok {
+				return NFTTransfer{}, false
+			}
+			if len(transfer.TokenIDs) != len(transfer.Amounts) {
+				return NFTTransfer{}, false
+			}
+		}
+		from, to = log.Topics[2], log.Topics[3]
+	default:
+		return NFTTransfer{}, false
+	}
+
+	if transfer.From, ok = topicAddress(from); !ok {
+		return NFTTransfer{}, false
+	}
+	if transfer.To, ok = topicAddress(to); !ok {
+		return NFTTransfer{}, false
+	}
+	return transfer, true
+}
+
+// Splits ABI encoded log data into 32 byte words
+func dataWords(data string) ([]*big.Int, bool) {
+	b, err := hex.DecodeString(strings.TrimPrefix(data, "0x"))
+	if err != nil || len(b)%32 != 0 {
+		return nil, false
+	}
+	words := make([]*big.Int, 0, len(b)/32)
+	for i := 0; i < len(b); i += 32 {
+		words = append(words, new(big.Int).SetBytes(b[i:i+32]))
+	}
+	return words, true
+}
+
+// Decodes the dynamic uint256[] argument at the given position of the ABI encoded words
+func decodeUintArray(words []*big.Int, position int) ([]string, bool) {
+	if position >= len(words) || !words[position].IsInt64() || words[position].Int64()%32 != 0 {
+		return nil, false
+	}
+	start := int(words[position].Int64() / 32)
+	if start >= len(words) || !words[start].IsInt64() {
+		return nil, false
+	}
+	length := int(words[start].Int64())
+	if length > len(words)-start-1 {
+		return nil, false
+	}
+
+	values := make([]string, 0, length)
+	for _, word := range words[start+1 : start+1+length] {
+		values = append(values, quantity(word))
+	}
+	return values, true
+}
+
+func quantity(n *big.Int) string {
+	return "0x" + n.Text(16)
+}
+
+// Returns the NFT transfers among the logs that involve a subscribed address
+func (b *Scanner) filterNFTTransfers(logs []ethclient.Log) []NFTTransfer {
+	transfers := make([]NFTTransfer, 0)
+	for _, log := range logs {
+		transfer, ok := decodeNFTTransfer(log)
+		if !ok {
+			continue
+		}
+		if b.isSubscribed(transfer.From) || b.isSubscribed(transfer.To) {
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers
+}
+
+// Saves NFT transfers under each subscribed counterparty
+func saveNFTTransfers(batch datastore.Batch, transfers []NFTTransfer) error {
+	return saveToCounterparties(batch, nftKey, transfers, func(transfer NFTTransfer) (string, string) {
+		return transfer.From, transfer.To
+	})
+}
+
+func removeNFTTransfers(
+	batch datastore.Batch,
+	addresses []string,
+	remove func(transfer NFTTransfer) bool,
+) error {
+	return removeRecords(batch, nftKey, addresses, remove)
+}
+
+// Returns the ERC-721 and ERC-1155 transfers to or from the address, with their addresses
+// in their EIP-55 checksum encoding
+func (p *Parser) GetNFTTransfers(address string) []NFTTransfer {
+	address, err := ethclient.NormalizeAddress(address)
+	if err != nil {
+		p.logger.Printf("failed to get nft transfers: %v", err)
+		return nil
+	}
+
+	transfers, err := getRecords[NFTTransfer](p.db, nftKey(address))
+	if err != nil {
+		p.logger.Printf("failed to get nft transfers for address %s: %v", address, err)
+		return nil
+	}
+	for i := range transfers {
+		transfers[i].Collection = ethclient.ChecksumAddress(transfers[i].Collection)
+		transfers[i].Operator = ethclient.ChecksumAddress(transfers[i].Operator)
+		transfers[i].From = ethclient.ChecksumAddress(transfers[i].From)
+		transfers[i].To = ethclient.ChecksumAddress(transfers[i].To)
+	}
+	return transfers
+}
+
+// Returns the NFTs the address currently holds, rebuilt from its transfer history.
+// Tokens received before the address was subscribed are not known.
+func (p *Parser) GetNFTHoldings(address string) []NFTHolding {
+	type token struct {
+		collection string
+		tokenID    string
+	}
+	balances := make(map[token]*big.Int)
+	standards := make(map[token]NFTStandard)
+
+	for _, transfer := range p.GetNFTTransfers(address) {
+		for i, tokenID := range transfer.TokenIDs {
+			amount, ok := new(big.Int).SetString(strings.TrimPrefix(transfer.Amounts[i], "0x"), 16)
+			if !ok {
+				continue
+			}
+			key := token{transfer.Collection, tokenID}
+			if balances[key] == nil {
+				balances[key] = new(big.Int)
+			}
+			standards[key] = transfer.Standard
+			// a transfer to self leaves the balance unchanged
+			if strings.EqualFold(transfer.To, address) {
+				balances[key].Add(balances[key], amount)
+			}
+			if strings.EqualFold(transfer.From, address) {
+				balances[key].Sub(balances[key], amount)
+			}
+		}
+	}
+
+	holdings := make([]NFTHolding, 0)
+	for key, balance := range balances {
+		if balance.Sign() <= 0 {
+			continue
+		}
+		holdings = append(holdings, NFTHolding{
+			Standard:   standards[key],
+			Collection: key.collection,
+			TokenID:    key.tokenID,
+			Amount:     quantity(balance),
+		})
+	}
+	sort.Slice(holdings, func(i, j int) bool {
+		if holdings[i].Collection != holdings[j].Collection {
+			return holdings[i].Collection < holdings[j].Collection
+		}
+		return holdings[i].TokenID < holdings[j].TokenID
+	})
+	return holdings
+}
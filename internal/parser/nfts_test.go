@@ -0,0 +1,162 @@
+package parser_test
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/keccak"
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+const (
+	erc721Collection  = "0x00000000000000000000000000000000000e0721"
+	erc1155Collection = "0x00000000000000000000000000000000000e1155"
+)
+
+func TestEventTopics(t *testing.T) {
+	for signature, topic := range map[string]string{
+		"Transfer(address,address,uint256)":                          parser.TransferEventTopic,
+		"TransferSingle(address,address,address,uint256,uint256)":    parser.TransferSingleEventTopic,
+		"TransferBatch(address,address,address,uint256[],uint256[])": parser.TransferBatchEventTopic,
+	} {
+		hash := keccak.Sum256([]byte(signature))
+		if expected := "0x" + hex.EncodeToString(hash[:]); topic != expected {
+			t.Errorf("expected topic of %s to be %s, got %s", signature, expected, topic)
+		}
+	}
+}
+
+func erc721Transfer(txHash, from, to string, tokenID int) ethclient.Log {
+	return ethclient.Log{
+		Address:         erc721Collection,
+		Topics:          []string{parser.TransferEventTopic, addressTopic(from), addressTopic(to), word(tokenID)},
+		TransactionHash: txHash,
+	}
+}
+
+func erc1155TransferSingle(txHash, from, to string, tokenID, amount int) ethclient.Log {
+	return ethclient.Log{
+		Address:         erc1155Collection,
+		Topics:          []string{parser.TransferSingleEventTopic, addressTopic(from), addressTopic(from), addressTopic(to)},
+		Data:            word(tokenID) + strings.TrimPrefix(word(amount), "0x"),
+		TransactionHash: txHash,
+	}
+}
+
+func erc1155TransferBatch(txHash, from, to string, tokenIDs, amounts []int) ethclient.Log {
+	// two dynamic uint256[] arguments: offsets, then length and elements of each array
+	words := []int{64, 64 + 32*(len(tokenIDs)+1), len(tokenIDs)}
+	words = append(words, tokenIDs...)
+	words = append(words, len(amounts))
+	words = append(words, amounts...)
+
+	data := "0x"
+	for _, w := range words {
+		data += strings.TrimPrefix(word(w), "0x")
+	}
+	return ethclient.Log{
+		Address:         erc1155Collection,
+		Topics:          []string{parser.TransferBatchEventTopic, addressTopic(from), addressTopic(from), addressTopic(to)},
+		Data:            data,
+		TransactionHash: txHash,
+	}
+}
+
+func TestNFTTransfers(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(
+		log.New(io.Discard, "", 0),
+		node.URL(),
+		10,
+		parser.WithNFTTransfers(true),
+		parser.WithTokenTransfers(true),
+	)
+	p.Subscribe(alice)
+
+	node.emit(
+		erc721Transfer("0x01", carol, alice, 7),
+		erc1155TransferSingle("0x02", carol, alice, 1, 10),
+		erc1155TransferBatch("0x03", carol, alice, []int{2, 3}, []int{5, 1}),
+		erc20Transfer("0x04", usdc, carol, alice, 100),
+	)
+	node.mine()
+	node.emit(
+		erc721Transfer("0x05", alice, carol, 7),
+		erc1155TransferSingle("0x06", alice, carol, 1, 4),
+	)
+	node.mine()
+
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	transfers := p.GetNFTTransfers(alice)
+	if len(transfers) != 5 {
+		t.Fatalf("expected 5 nft transfers, got %d: %+v", len(transfers), transfers)
+	}
+
+	first := transfers[0]
+	if first.Standard != parser.ERC721 || first.Collection != ethclient.ChecksumAddress(erc721Collection) ||
+		first.From != ethclient.ChecksumAddress(carol) || first.To != ethclient.ChecksumAddress(alice) || first.TokenIDs[0] != "0x7" || first.Amounts[0] != "0x1" {
+		t.Errorf("unexpected erc721 transfer: %+v", first)
+	}
+	batch := transfers[2]
+	if batch.Standard != parser.ERC1155 || batch.Operator != ethclient.ChecksumAddress(carol) ||
+		strings.Join(batch.TokenIDs, ",") != "0x2,0x3" || strings.Join(batch.Amounts, ",") != "0x5,0x1" {
+		t.Errorf("unexpected erc1155 batch transfer: %+v", batch)
+	}
+
+	// the ERC-20 transfer shares the Transfer signature but is not an NFT
+	if tokens := p.GetTokenTransfers(alice); len(tokens) != 1 {
+		t.Errorf("expected 1 token transfer, got %d", len(tokens))
+	}
+
+	want := []parser.NFTHolding{
+		{Standard: parser.ERC1155, Collection: ethclient.ChecksumAddress(erc1155Collection), TokenID: "0x1", Amount: "0x6"},
+		{Standard: parser.ERC1155, Collection: ethclient.ChecksumAddress(erc1155Collection), TokenID: "0x2", Amount: "0x5"},
+		{Standard: parser.ERC1155, Collection: ethclient.ChecksumAddress(erc1155Collection), TokenID: "0x3", Amount: "0x1"},
+	}
+	// the address matches in its checksum encoding as well
+	for _, address := range []string{alice, ethclient.ChecksumAddress(alice)} {
+		holdings := p.GetNFTHoldings(address)
+		if len(holdings) != len(want) {
+			t.Fatalf("expected %d holdings for %s, got %+v", len(want), address, holdings)
+		}
+		for i := range want {
+			if holdings[i] != want[i] {
+				t.Errorf("expected holding %+v, got %+v", want[i], holdings[i])
+			}
+		}
+	}
+}
+
+func TestNFTTransfersReorg(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithNFTTransfers(true))
+	p.Subscribe(alice)
+
+	node.emit(erc721Transfer("0x01", carol, alice, 1))
+	node.mine()
+	node.emit(erc721Transfer("0x02", carol, alice, 2))
+	node.mine()
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	node.fork(11)
+	node.mine()
+	node.mine()
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	holdings := p.GetNFTHoldings(alice)
+	if len(holdings) != 1 || holdings[0].TokenID != "0x1" {
+		t.Fatalf("expected only the token received before the fork, got %+v", holdings)
+	}
+}
@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+type options struct {
+	confirmations   int
+	headTag         string
+	db              datastore.DataStore
+	workers         int
+	prefetch        int
+	clientOpts      []ethclient.Option
+	wsEndpoint      string
+	receipts        bool
+	tokens          bool
+	nfts            bool
+	tracing         bool
+	webhookSecret   string
+	webhookRetry    WebhookRetryPolicy
+	privateWebhooks bool
+}
+
+// Option configures optional behaviour of the Parser and its Scanner.
+type Option func(*options)
+
+func defaultOptions() options {
+	return options{
+		confirmations: 0,
+		headTag:       ethclient.LatestTag,
+		workers:       4,
+		prefetch:      16,
+		webhookRetry:  DefaultWebhookRetryPolicy,
+	}
+}
+
+func applyOptions(opts []Option) options {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Number of confirmations after which a transaction counts as settled.
+func WithConfirmations(confirmations int) Option {
+	return func(o *options) {
+		o.confirmations = confirmations
+	}
+}
+
+// Block tag the scanner follows as chain head: "latest", "safe" or "finalized".
+// Following "safe" or "finalized" only indexes blocks the node considers settled.
+func WithHeadTag(tag string) Option {
+	return func(o *options) {
+		o.headTag = tag
+	}
+}
+
+// DataStore used by the parser. Defaults to an in-memory datastore.
+func WithDataStore(db datastore.DataStore) Option {
+	return func(o *options) {
+		o.db = db
+	}
+}
+
+// Number of blocks fetched concurrently while catching up with the chain head.
+func WithWorkers(workers int) Option {
+	return func(o *options) {
+		o.workers = workers
+	}
+}
+
+// Maximum number of blocks fetched ahead of the block being processed.
+func WithPrefetch(prefetch int) Option {
+	return func(o *options) {
+		o.prefetch = prefetch
+	}
+}
+
+// Options for the Ethereum JSON-RPC client, such as its retry policy and rate limit.
+func WithClientOptions(opts ...ethclient.Option) Option {
+	return func(o *options) {
+		o.clientOpts = append(o.clientOpts, opts...)
+	}
+}
+
+// WebSocket endpoint to subscribe to new heads on. The scanner then scans as soon as a
+// new block arrives and only polls while the subscription is down.
+func WithWebSocketEndpoint(url string) Option {
+	return func(o *options) {
+		o.wsEndpoint = url
+	}
+}
+
+// Fetch the receipts of subscribed transactions while scanning, so that transactions
+// are returned with their execution status, gas used and logs.
+func WithReceipts(enabled bool) Option {
+	return func(o *options) {
+		o.receipts = enabled
+	}
+}
+
+// Index ERC-20 Transfer events to or from subscribed addresses, fetched with eth_getLogs.
+func WithTokenTransfers(enabled bool) Option {
+	return func(o *options) {
+		o.tokens = enabled
+	}
+}
+
+// Index ERC-721 Transfer and ERC-1155 TransferSingle and TransferBatch events to or from
+// subscribed addresses, fetched with eth_getLogs.
+func WithNFTTransfers(enabled bool) Option {
+	return func(o *options) {
+		o.nfts = enabled
+	}
+}
+
+// Trace every block to index internal value transfers made by contract calls to or from
+// subscribed addresses. Needs a node with the debug or trace API.
+func WithInternalTransactions(enabled bool) Option {
+	return func(o *options) {
+		o.tracing = enabled
+	}
+}
+
+// Secret to sign webhook deliveries with. Each delivery then carries an HMAC-SHA256 signature
+// of its timestamp and body, so that receivers can check it was sent by the parser.
+func WithWebhookSecret(secret string) Option {
+	return func(o *options) {
+		o.webhookSecret = secret
+	}
+}
+
+// Retry policy for failed webhook deliveries. Defaults to DefaultWebhookRetryPolicy.
+func WithWebhookRetryPolicy(policy WebhookRetryPolicy) Option {
+	return func(o *options) {
+		o.webhookRetry = policy
+	}
+}
+
+// Allow webhooks on loopback, private and link-local addresses. By default they are rejected,
+// so that subscribers cannot have the parser send requests into its own network.
+func WithPrivateWebhooks(allowed bool) Option {
+	return func(o *options) {
+		o.privateWebhooks = allowed
+	}
+}
@@ -1,6 +1,10 @@
 package parser
 
 import (
+	"encoding/json"
+	"errors"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
 	"github.com/zihaolam/ethereum-parser/internal/datastore/memorydb"
 	"github.com/zihaolam/ethereum-parser/internal/ethclient"
 	"github.com/zihaolam/ethereum-parser/internal/logging"
@@ -9,14 +13,137 @@ import (
 type Parser struct {
 	ethClient *ethclient.Client
 	logger    logging.Logger
-	db        *memorydb.MemoryDB
+	db        datastore.DataStore
 	*Scanner
 }
 
-func New(logger logging.Logger, ethEndpoint string, initialBlockNumber int) *Parser {
-	db := memorydb.New()
-	ethClient := ethclient.New(ethEndpoint)
-	scanner := NewScanner(db, ethClient, logger, initialBlockNumber)
+// Settlement status of an indexed transaction
+type TxStatus string
+
+const (
+	// Included in a block with fewer confirmations than required
+	StatusSeen TxStatus = "seen"
+	// Included in a block with at least the required number of confirmations
+	StatusSettled TxStatus = "settled"
+	// Included in a block the node reports as finalized
+	StatusFinalized TxStatus = "finalized"
+)
+
+// Transaction is an indexed transaction annotated with its settlement status, the
+// fee it paid and, if receipts are fetched, its receipt
+type Transaction struct {
+	ethclient.Transaction
+	Confirmations int      `json:"confirmations"`
+	Status        TxStatus `json:"status"`
+	// price paid per gas, from the receipt or else computed from the block's base fee.
+	// Unset if neither is known.
+	EffectiveGasPrice ethclient.Quantity `json:"effectiveGasPrice"`
+	// fee paid in wei including blob gas, unset without a receipt
+	EffectiveFee ethclient.Quantity `json:"effectiveFee"`
+	Receipt      *ethclient.Receipt `json:"receipt,omitempty"`
+}
+
+// Encodes the transaction as earlier versions did, which passed on the node's null
+// recipient of a contract creation and chain ID of a legacy transaction as empty strings.
+// The sender, recipient and the addresses in the receipt and its logs are encoded in their
+// EIP-55 checksum encoding.
+func (t Transaction) MarshalJSON() ([]byte, error) {
+	type transaction Transaction
+	return json.Marshal(struct {
+		transaction
+		From    string             `json:"from"`
+		To      string             `json:"to"`
+		ChainID string             `json:"chainId"`
+		Receipt *ethclient.Receipt `json:"receipt,omitempty"`
+	}{
+		transaction: transaction(t),
+		From:        t.From.String(),
+		To:          ethclient.ChecksumAddress(recipient(t.Transaction)),
+		ChainID:     t.ChainID.String(),
+		Receipt:     checksumReceipt(t.Receipt),
+	})
+}
+
+// Returns a copy of the receipt with its addresses and those of its logs in their
+// EIP-55 checksum encoding
+func checksumReceipt(receipt *ethclient.Receipt) *ethclient.Receipt {
+	if receipt == nil {
+		return nil
+	}
+	r := *receipt
+	r.From = ethclient.ChecksumAddress(r.From)
+	r.To = ethclient.ChecksumAddress(r.To)
+	r.ContractAddress = ethclient.ChecksumAddress(r.ContractAddress)
+	if r.Logs != nil {
+		r.Logs = make([]ethclient.Log, len(receipt.Logs))
+		for i, log := range receipt.Logs {
+			log.Address = ethclient.ChecksumAddress(log.Address)
+			r.Logs[i] = log
+		}
+	}
+	return &r
+}
+
+// Block is a block as returned by the node, whose transactions are encoded like Transaction
+type Block struct {
+	ethclient.Block
+}
+
+func (b Block) MarshalJSON() ([]byte, error) {
+	type block ethclient.Block
+	txs := make([]nodeTransaction, 0, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		txs = append(txs, nodeTransaction(tx))
+	}
+	return json.Marshal(struct {
+		block
+		Transactions []nodeTransaction `json:"transactions"`
+	}{
+		block:        block(b.Block),
+		Transactions: txs,
+	})
+}
+
+// A transaction as returned by the node, encoded like Transaction without its annotations
+type nodeTransaction ethclient.Transaction
+
+func (t nodeTransaction) MarshalJSON() ([]byte, error) {
+	type transaction ethclient.Transaction
+	return json.Marshal(struct {
+		transaction
+		From    string `json:"from"`
+		To      string `json:"to"`
+		ChainID string `json:"chainId"`
+	}{
+		transaction: transaction(t),
+		From:        t.From.String(),
+		To:          ethclient.ChecksumAddress(recipient(ethclient.Transaction(t))),
+		ChainID:     t.ChainID.String(),
+	})
+}
+
+func New(
+	logger logging.Logger,
+	ethEndpoint string,
+	initialBlockNumber int,
+	opts ...Option,
+) *Parser {
+	o := applyOptions(opts)
+	var db datastore.DataStore = memorydb.New()
+	if o.db != nil {
+		db = o.db
+	}
+	ethClient := ethclient.New(ethEndpoint, o.clientOpts...)
+	if err := migrateAddressKeys(db); err != nil {
+		logger.Printf("failed to migrate address keys: %v", err)
+	}
+	if err := migrateSubscriptions(db); err != nil {
+		logger.Printf("failed to migrate subscriptions: %v", err)
+	}
+	if err := migrateTxEncoding(db); err != nil {
+		logger.Printf("failed to migrate transaction encoding: %v", err)
+	}
+	scanner := NewScanner(db, ethClient, logger, initialBlockNumber, opts...)
 	return &Parser{
 		ethClient: ethClient,
 		db:        db,
@@ -26,32 +153,84 @@ func New(logger logging.Logger, ethEndpoint string, initialBlockNumber int) *Par
 }
 
 func (p *Parser) GetCurrentBlock() int {
-	return p.lastBlockNumber
+	return int(p.lastBlockNumber.Load())
 }
 
-func (p *Parser) Subscribe(address string) bool {
-	err := p.db.Put(address, [][]byte{})
+func (p *Parser) GetTransactions(address string) []Transaction {
+	address, err := ethclient.NormalizeAddress(address)
 	if err != nil {
-		p.logger.Printf("failed to subscribe to address %s: %v", address, err)
-		return false
+		p.logger.Printf("failed to get transactions: %v", err)
+		return nil
 	}
-	return true
-}
 
-func (p *Parser) GetTransactions(address string) []ethclient.Transaction {
-	v, err := p.db.Get(address)
+	v, err := p.db.Get(txKey(address))
+	if errors.Is(err, datastore.KeyDoesNotExist) {
+		// no transactions indexed for the address yet
+		return []Transaction{}
+	}
 	if err != nil {
 		p.logger.Printf("failed to get transactions for address %s: %v", address, err)
 		return nil
 	}
 	txs, err := deserializeTxn(v)
-	return txs
+	if err != nil {
+		p.logger.Printf("failed to get transactions for address %s: %v", address, err)
+		return nil
+	}
+
+	result := make([]Transaction, 0, len(txs))
+	for _, tx := range txs {
+		result = append(result, p.withReceipt(p.withStatus(tx)))
+	}
+	return result
 }
 
-func (p *Parser) GetSubscriptions() ([]string, error) {
-	addresses, err := p.db.List()
+// Annotates a transaction with its confirmation count, settlement status and the gas
+// price it paid as far as its block tells
+func (b *Scanner) withStatus(tx indexedTx) Transaction {
+	result := Transaction{
+		Transaction:       tx.Transaction,
+		Status:            StatusSeen,
+		EffectiveGasPrice: tx.EffectiveGasPrice(tx.BaseFeePerGas),
+	}
+
+	blockNumber, err := tx.BlockNumber.Int()
 	if err != nil {
-		return nil, err
+		return result
+	}
+
+	head := max(int(b.latestBlockNumber.Load()), int(b.lastBlockNumber.Load()))
+	finalized := int(b.finalizedBlockNumber.Load())
+	result.Confirmations = max(head-blockNumber+1, 0)
+
+	switch {
+	case finalized > 0 && blockNumber <= finalized:
+		result.Status = StatusFinalized
+	case result.Confirmations > 0 && result.Confirmations >= b.confirmations:
+		result.Status = StatusSettled
+	}
+	return result
+}
+
+// Attaches the stored receipt, if any, to the transaction along with the fee it paid
+func (p *Parser) withReceipt(tx Transaction) Transaction {
+	receipt, err := getReceipt(p.db, tx.Hash.Hex())
+	if err != nil {
+		p.logger.Printf("failed to get receipt for transaction %s: %v", tx.Hash, err)
+		return tx
+	}
+	return attachReceipt(tx, receipt)
+}
+
+// Sets the transaction's receipt, which may be nil, and the gas price and fee it reports
+func attachReceipt(tx Transaction, receipt *ethclient.Receipt) Transaction {
+	tx.Receipt = receipt
+	if receipt == nil {
+		return tx
+	}
+	if price, err := ethclient.ParseQuantity(receipt.EffectiveGasPrice); err == nil {
+		tx.EffectiveGasPrice = price
 	}
-	return addresses, nil
+	tx.EffectiveFee = receipt.Fee()
+	return tx
 }
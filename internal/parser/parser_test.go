@@ -2,7 +2,9 @@ package parser_test
 
 import (
 	"context"
+	"encoding/json"
 	"log"
+	"reflect"
 	"testing"
 
 	"github.com/zihaolam/ethereum-parser/internal/ethclient"
@@ -30,14 +32,14 @@ func TestParser(t *testing.T) {
 	})
 
 	t.Run("GetSubscriptions", func(t *testing.T) {
-		p.Subscribe("0xAddress")
+		p.Subscribe("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed")
 		subscribers, err := p.GetSubscriptions()
 		if err != nil {
 			t.Errorf("Error getting subscribers: %v", err)
 		}
 
 		for _, subscriber := range subscribers {
-			if subscriber == "0xAddress" {
+			if subscriber == "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed" {
 				return
 			}
 		}
@@ -45,9 +47,10 @@ func TestParser(t *testing.T) {
 	})
 
 	t.Run("Subscribe", func(t *testing.T) {
-		result := p.Subscribe("0xAddress")
-		if !result {
-			t.Error("Expected subscription to succeed")
+		// Already subscribed by the previous subtest
+		result := p.Subscribe("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed")
+		if result {
+			t.Error("Expected subscribing again to report the address as already subscribed")
 		}
 
 		// Verify that the address exists in the database
@@ -56,7 +59,7 @@ func TestParser(t *testing.T) {
 			t.Errorf("Error getting subscribers: %v", err)
 		}
 		for _, subscriber := range subscribers {
-			if subscriber == "0xAddress" {
+			if subscriber == "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed" {
 				return
 			}
 		}
@@ -89,8 +92,10 @@ func TestParser(t *testing.T) {
 		}
 
 		for _, tx := range block.Transactions {
-			txMap[tx.From] = append(txMap[tx.From], tx)
-			txMap[tx.To] = append(txMap[tx.To], tx)
+			txMap[tx.From.Hex()] = append(txMap[tx.From.Hex()], tx)
+			if tx.To != nil {
+				txMap[tx.To.Hex()] = append(txMap[tx.To.Hex()], tx)
+			}
 		}
 
 		p.SaveTxs(block.Transactions)
@@ -120,9 +125,11 @@ func TestParser(t *testing.T) {
 		txMap = make(map[string][]ethclient.Transaction)
 		var oneAddr string
 		for _, tx := range block.Transactions {
-			txMap[tx.From] = append(txMap[tx.From], tx)
-			txMap[tx.To] = append(txMap[tx.To], tx)
-			oneAddr = tx.From
+			txMap[tx.From.Hex()] = append(txMap[tx.From.Hex()], tx)
+			if tx.To != nil {
+				txMap[tx.To.Hex()] = append(txMap[tx.To.Hex()], tx)
+			}
+			oneAddr = tx.From.Hex()
 		}
 		p.Subscribe(oneAddr)
 		p.SaveTxsToSubscribers(block.Transactions)
@@ -132,3 +139,145 @@ func TestParser(t *testing.T) {
 		}
 	})
 }
+
+func TestTransactionJSON(t *testing.T) {
+	// fields of later transaction types and newer nodes, null on a legacy transaction
+	// without a signature
+	const legacyFields = `"blockTimestamp":null,"type":null,"maxFeePerGas":null,"maxPriorityFeePerGas":null,"maxFeePerBlobGas":null,` +
+		`"blobVersionedHashes":null,"accessList":null,"authorizationList":null,"v":null,"r":null,"s":null,"yParity":null,`
+
+	tests := []struct {
+		name string
+		node string
+		want string
+	}{
+		{
+			name: "transfer",
+			node: `{"chainId":"0x1","blockNumber":"0x5daf3b","blockHash":"0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2",` +
+				`"hash":"0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b","nonce":"0x15",` +
+				`"from":"0xa7d9ddbe1f17865597fbd27ec712455208b6b76d","to":"0xf02c1c8e6114b1dbe8937a39260b5b0a374432bb",` +
+				`"value":"0xf3dbb76162000","gas":"0xc350","gasPrice":"0x4a817c800","input":"0x68656c6c6f21","transactionIndex":"0x41"}`,
+			want: `{"chainId":"0x1","blockNumber":"0x5daf3b","blockHash":"0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2",` +
+				`"hash":"0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b","nonce":"0x15",` +
+				`"from":"0xa7d9ddBE1f17865597fBD27EC712455208B6B76d","to":"0xF02c1c8e6114b1Dbe8937a39260b5b0a374432bB",` +
+				`"value":"0xf3dbb76162000","gas":"0xc350","gasPrice":"0x4a817c800","input":"0x68656c6c6f21","transactionIndex":"0x41",` +
+				legacyFields + `"confirmations":0,"status":"seen","effectiveGasPrice":null,"effectiveFee":null}`,
+		},
+		{
+			// reported as empty strings, as before quantities were typed
+			name: "legacy contract creation",
+			node: `{"chainId":null,"blockNumber":"0x5daf3b","blockHash":"0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2",` +
+				`"hash":"0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b","nonce":"0x0",` +
+				`"from":"0xa7d9ddbe1f17865597fbd27ec712455208b6b76d","to":null,` +
+				`"value":"0x0","gas":"0xc350","gasPrice":"0x4a817c800","input":"0x6080","transactionIndex":"0x0"}`,
+			want: `{"chainId":"","blockNumber":"0x5daf3b","blockHash":"0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2",` +
+				`"hash":"0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b","nonce":"0x0",` +
+				`"from":"0xa7d9ddBE1f17865597fBD27EC712455208B6B76d","to":"",` +
+				`"value":"0x0","gas":"0xc350","gasPrice":"0x4a817c800","input":"0x6080","transactionIndex":"0x0",` +
+				legacyFields + `"confirmations":0,"status":"seen","effectiveGasPrice":null,"effectiveFee":null}`,
+		},
+		{
+			name: "dynamic fee",
+			node: `{"type":"0x2","chainId":"0x1","blockNumber":"0x5daf3b","blockHash":"0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2",` +
+				`"hash":"0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b","nonce":"0x15",` +
+				`"from":"0xa7d9ddbe1f17865597fbd27ec712455208b6b76d","to":"0xf02c1c8e6114b1dbe8937a39260b5b0a374432bb",` +
+				`"value":"0x0","gas":"0xc350","gasPrice":"0x3b9aca0e","maxFeePerGas":"0x77359400","maxPriorityFeePerGas":"0xe",` +
+				`"input":"0x","accessList":[{"address":"0x0000000000000000000000000000000000000b0b","storageKeys":[]}],` +
+				`"v":"0x1","r":"0x1f","s":"0x2e","yParity":"0x1","transactionIndex":"0x41"}`,
+			want: `{"type":"0x2","chainId":"0x1","blockNumber":"0x5daf3b","blockHash":"0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2",` +
+				`"hash":"0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b","nonce":"0x15",` +
+				`"from":"0xa7d9ddBE1f17865597fBD27EC712455208B6B76d","to":"0xF02c1c8e6114b1Dbe8937a39260b5b0a374432bB",` +
+				`"value":"0x0","gas":"0xc350","gasPrice":"0x3b9aca0e","maxFeePerGas":"0x77359400","maxPriorityFeePerGas":"0xe",` +
+				`"maxFeePerBlobGas":null,"blobVersionedHashes":null,"authorizationList":null,"blockTimestamp":null,` +
+				`"input":"0x","accessList":[{"address":"0x0000000000000000000000000000000000000b0b","storageKeys":[]}],` +
+				`"v":"0x1","r":"0x1f","s":"0x2e","yParity":"0x1","transactionIndex":"0x41",` +
+				`"confirmations":0,"status":"seen","effectiveGasPrice":null,"effectiveFee":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tx ethclient.Transaction
+			if err := json.Unmarshal([]byte(tt.node), &tx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := json.Marshal(parser.Transaction{Transaction: tx, Status: parser.StatusSeen})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got, want map[string]interface{}
+			json.Unmarshal(b, &got)
+			json.Unmarshal([]byte(tt.want), &want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("expected %s, got %s", tt.want, b)
+			}
+		})
+	}
+}
+
+func TestTransactionJSONReceipt(t *testing.T) {
+	const (
+		from      = "0xa7d9ddbe1f17865597fbd27ec712455208b6b76d"
+		to        = "0xf02c1c8e6114b1dbe8937a39260b5b0a374432bb"
+		fromEIP55 = "0xa7d9ddBE1f17865597fBD27EC712455208B6B76d"
+		toEIP55   = "0xF02c1c8e6114b1Dbe8937a39260b5b0a374432bB"
+	)
+	receipt := &ethclient.Receipt{
+		From: from,
+		To:   to,
+		Logs: []ethclient.Log{{Address: to}},
+	}
+	b, err := json.Marshal(parser.Transaction{Receipt: receipt})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got struct {
+		Receipt ethclient.Receipt `json:"receipt"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Receipt.From != fromEIP55 || got.Receipt.To != toEIP55 || got.Receipt.ContractAddress != "" ||
+		len(got.Receipt.Logs) != 1 || got.Receipt.Logs[0].Address != toEIP55 {
+		t.Fatalf("expected the receipt's addresses in their checksum encoding, got %s", b)
+	}
+	if receipt.From != from || receipt.Logs[0].Address != to {
+		t.Fatalf("expected the receipt to be left unchanged, got %+v", receipt)
+	}
+}
+
+func TestBlockJSON(t *testing.T) {
+	node := `{"number":"0x5daf3b","hash":"0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2","transactions":[` +
+		`{"chainId":null,"hash":"0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b",` +
+		`"from":"0xa7d9ddbe1f17865597fbd27ec712455208b6b76d","to":null,"value":"0x0","input":"0x6080"}]}`
+	var block ethclient.Block
+	if err := json.Unmarshal([]byte(node), &block); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := json.Marshal(parser.Block{Block: block})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got struct {
+		Number       string                   `json:"number"`
+		Transactions []map[string]interface{} `json:"transactions"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Number != "0x5daf3b" || len(got.Transactions) != 1 {
+		t.Fatalf("unexpected block: %s", b)
+	}
+	// the transaction is encoded like parser.Transaction, without its annotations
+	tx := got.Transactions[0]
+	if tx["to"] != "" || tx["chainId"] != "" || tx["hash"] != block.Transactions[0].Hash.Hex() {
+		t.Errorf("expected null recipient and chain ID as empty strings, got %s", b)
+	}
+	if tx["from"] != "0xa7d9ddBE1f17865597fBD27EC712455208B6B76d" {
+		t.Errorf("expected the sender in its checksum encoding, got %s", b)
+	}
+	if _, ok := tx["status"]; ok {
+		t.Errorf("expected no status on a node transaction, got %s", b)
+	}
+}
@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"context"
+	"sync"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+type fetchResult struct {
+	number int
+	block  ethclient.Block
+	err    error
+}
+
+type fetchJob struct {
+	number int
+	result chan<- fetchResult
+}
+
+// Fetches blocks from..to with a bounded pool of workers and delivers them in block order.
+// At most prefetch blocks are fetched ahead of the consumer. Fetching stops when ctx is
+// cancelled; the consumer must cancel ctx if it stops reading before the channel is closed.
+func (b *Scanner) fetchBlocks(ctx context.Context, from, to int) <-chan fetchResult {
+	jobs := make(chan fetchJob)
+	// result channels in block order, its capacity bounds the prefetch window
+	pending := make(chan chan fetchResult, b.prefetch)
+	ordered := make(chan fetchResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < b.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				block, err := b.ScanBlock(ctx, job.number)
+				job.result <- fetchResult{number: job.number, block: block, err: err}
+			}
+		}()
+	}
+
+	// dispatch jobs in block order
+	go func() {
+		defer close(jobs)
+		defer close(pending)
+		for n := from; n <= to; n++ {
+			result := make(chan fetchResult, 1)
+			select {
+			case pending <- result:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- fetchJob{number: n, result: result}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// deliver results in the order the jobs were dispatched
+	go func() {
+		defer close(ordered)
+		defer wg.Wait()
+		for result := range pending {
+			var r fetchResult
+			select {
+			case r = <-result:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case ordered <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ordered
+}
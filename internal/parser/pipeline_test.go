@@ -0,0 +1,69 @@
+package parser_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+func TestParallelBackfill(t *testing.T) {
+	const (
+		alice = "0x00000000000000000000000000000000000a11ce"
+		bob   = "0x0000000000000000000000000000000000000b0b"
+	)
+
+	node := newFakeNode(t, 0)
+	var want []ethclient.Hash
+	for i := 1; i <= 200; i++ {
+		node.mine(newTx(i, alice, bob, 0))
+		want = append(want, testHash(i))
+	}
+
+	p := parser.New(
+		log.New(io.Discard, "", 0),
+		node.URL(),
+		1,
+		parser.WithWorkers(8),
+		parser.WithPrefetch(4),
+	)
+	p.Subscribe(alice)
+	p.Subscribe(bob)
+
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Blocks are committed in order even though they are fetched concurrently
+	txs := p.GetTransactions(alice)
+	if len(txs) != len(want)-1 {
+		t.Fatalf("expected %d transactions, got %d", len(want)-1, len(txs))
+	}
+	for i, tx := range txs {
+		if tx.Hash != want[i+1] {
+			t.Fatalf("expected transaction %d to be %s, got %s", i, want[i+1], tx.Hash)
+		}
+	}
+
+	stats := p.Stats()
+	if stats.LastBlock != 200 || stats.HeadBlock != 200 || stats.Lag != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.BlocksPerSecond <= 0 {
+		t.Errorf("expected positive throughput, got %f", stats.BlocksPerSecond)
+	}
+}
+
+func TestParallelBackfillCancel(t *testing.T) {
+	node := newFakeNode(t, 100)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 1, parser.WithWorkers(4))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.ScanAll(ctx); err == nil {
+		t.Fatal("expected error for cancelled context")
+	}
+}
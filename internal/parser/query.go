@@ -0,0 +1,340 @@
+package parser
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+const (
+	// Number of transactions in a page if the query sets no limit
+	DefaultPageSize = 100
+	// Largest page a query may ask for
+	MaxPageSize = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Direction of a transaction relative to the queried address
+type Direction string
+
+const (
+	DirectionIn  Direction = "in"
+	DirectionOut Direction = "out"
+)
+
+// Order of a page of transactions, by block number and transaction index
+type SortOrder string
+
+const (
+	OrderAsc  SortOrder = "asc"
+	OrderDesc SortOrder = "desc"
+)
+
+// TransactionQuery selects a page of an address's transactions.
+// Zero values leave a filter unset.
+type TransactionQuery struct {
+	// NextCursor of the previous page, empty for the first page
+	Cursor string
+	// maximum number of transactions in the page, DefaultPageSize if 0
+	Limit int
+	// inclusive block range, a ToBlock of 0 means no upper bound
+	FromBlock int
+	ToBlock   int
+	// inclusive range of block times, to the second. Transactions stored without
+	// their block's timestamp match neither bound.
+	FromTime  time.Time
+	ToTime    time.Time
+	Direction Direction
+	// inclusive bounds on the value transferred, in wei
+	MinValue *big.Int
+	MaxValue *big.Int
+	// address on the other side of the transaction
+	Counterparty string
+	// OrderAsc if empty
+	Order SortOrder
+}
+
+// TransactionPage is a page of an address's transactions
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	// cursor of the next page, empty if this is the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Position of a transaction in the chain, which orders an address's history
+type txPosition struct {
+	block int
+	index int
+}
+
+func (p txPosition) less(o txPosition) bool {
+	if p.block != o.block {
+		return p.block < o.block
+	}
+	return p.index < o.index
+}
+
+func positionOf(tx ethclient.Transaction) txPosition {
+	block, _ := tx.BlockNumber.Int()
+	// records stored before the index was kept share index 0 and fall back to stored order
+	index, _ := tx.TransactionIndex.Int()
+	return txPosition{block: block, index: index}
+}
+
+// A cursor is the position and hash of the last transaction of a page. The hash
+// tells apart transactions stored without an index, which share a position.
+type txCursor struct {
+	position txPosition
+	hash     string
+}
+
+func (c txCursor) encode() string {
+	s := fmt.Sprintf("%d:%d:%s", c.position.block, c.position.index, c.hash)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func decodeCursor(s string) (txCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return txCursor{}, ErrInvalidCursor
+	}
+	var c txCursor
+	if _, err := fmt.Sscanf(string(b), "%d:%d:%s", &c.position.block, &c.position.index, &c.hash); err != nil {
+		return txCursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Returns a page of the address's transactions matching the query.
+// History is kept ordered by position, so the page is located by binary search and
+// only the transactions it scans are read from the datastore. Returns an error wrapping
+// ethclient.ErrInvalidAddress or ethclient.ErrInvalidChecksum if the address is invalid.
+func (p *Parser) GetTransactionsPage(address string, query TransactionQuery) (TransactionPage, error) {
+	address, err := ethclient.NormalizeAddress(address)
+	if err != nil {
+		return TransactionPage{}, err
+	}
+	page := TransactionPage{Transactions: []Transaction{}}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	var cursor *txCursor
+	if query.Cursor != "" {
+		c, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return TransactionPage{}, err
+		}
+		cursor = &c
+	}
+
+	key := txKey(address)
+	n, err := p.db.Len(key)
+	if errors.Is(err, datastore.KeyDoesNotExist) {
+		// no transactions indexed for the address yet
+		return page, nil
+	}
+	if err != nil {
+		return TransactionPage{}, err
+	}
+
+	// bounds of the positions to scan, lo inclusive and hi exclusive
+	lo, err := p.searchTxs(key, n, txPosition{block: query.FromBlock})
+	if err != nil {
+		return TransactionPage{}, err
+	}
+	hi := n
+	if query.ToBlock > 0 {
+		if hi, err = p.searchTxs(key, n, txPosition{block: query.ToBlock + 1}); err != nil {
+			return TransactionPage{}, err
+		}
+	}
+	if !query.FromTime.IsZero() {
+		start, err := p.searchTxsByTime(key, n, query.FromTime.Unix())
+		if err != nil {
+			return TransactionPage{}, err
+		}
+		lo = max(lo, start)
+	}
+	if !query.ToTime.IsZero() {
+		end, err := p.searchTxsByTime(key, n, query.ToTime.Unix()+1)
+		if err != nil {
+			return TransactionPage{}, err
+		}
+		hi = min(hi, end)
+	}
+	desc := query.Order == OrderDesc
+	if cursor != nil {
+		// resume at the cursor's position, skipping what the previous page returned below
+		if desc {
+			next := txPosition{block: cursor.position.block, index: cursor.position.index + 1}
+			end, err := p.searchTxs(key, n, next)
+			if err != nil {
+				return TransactionPage{}, err
+			}
+			hi = min(hi, end)
+		} else {
+			start, err := p.searchTxs(key, n, cursor.position)
+			if err != nil {
+				return TransactionPage{}, err
+			}
+			lo = max(lo, start)
+		}
+	}
+
+	skipping := cursor != nil
+	var last indexedTx
+	for scanned := 0; scanned < hi-lo; {
+		// read in chunks of the page size from the end the order starts at
+		start, end := lo+scanned, min(lo+scanned+limit, hi)
+		if desc {
+			start, end = max(hi-scanned-limit, lo), hi-scanned
+		}
+		values, err := p.db.GetRange(key, start, end)
+		if err != nil {
+			return TransactionPage{}, err
+		}
+		txs, err := deserializeTxn(values)
+		if err != nil {
+			return TransactionPage{}, err
+		}
+		if desc {
+			for i, j := 0, len(txs)-1; i < j; i, j = i+1, j-1 {
+				txs[i], txs[j] = txs[j], txs[i]
+			}
+		}
+		scanned += end - start
+
+		for _, tx := range txs {
+			if skipping && positionOf(tx.Transaction) == cursor.position {
+				// the cursor's transaction is the last one skipped
+				skipping = tx.Hash.Hex() != cursor.hash
+				continue
+			}
+			skipping = false
+
+			if !query.matches(address, tx.Transaction) {
+				continue
+			}
+			if len(page.Transactions) == limit {
+				// another match exists, so there is a next page
+				page.NextCursor = txCursor{position: positionOf(last.Transaction), hash: last.Hash.Hex()}.encode()
+				return page, nil
+			}
+			page.Transactions = append(page.Transactions, p.withReceipt(p.withStatus(tx)))
+			last = tx
+		}
+	}
+	return page, nil
+}
+
+// Returns the first of the n positions stored under key at or after the given position
+func (p *Parser) searchTxs(key string, n int, position txPosition) (int, error) {
+	var err error
+	i := sort.Search(n, func(i int) bool {
+		if err != nil {
+			return true
+		}
+		var values [][]byte
+		if values, err = p.db.GetRange(key, i, i+1); err != nil {
+			return true
+		}
+		var txs []indexedTx
+		if txs, err = deserializeTxn(values); err != nil || len(txs) == 0 {
+			return true
+		}
+		return !positionOf(txs[0].Transaction).less(position)
+	})
+	return i, err
+}
+
+// Returns the first of the n transactions stored under key whose block time is at or
+// after the given unix time. Block times grow with the position in the history except
+// where transactions were stored without one, so each probe compares the first
+// transaction with a timestamp at or after it.
+func (p *Parser) searchTxsByTime(key string, n int, unix int64) (int, error) {
+	var err error
+	// position from which on no transaction has a timestamp, as far as probed
+	untimed := n
+	i := sort.Search(n, func(i int) bool {
+		for j := i; j < untimed && err == nil; j++ {
+			var values [][]byte
+			if values, err = p.db.GetRange(key, j, j+1); err != nil {
+				break
+			}
+			var txs []indexedTx
+			if txs, err = deserializeTxn(values); err != nil || len(txs) == 0 {
+				break
+			}
+			if ts := txs[0].BlockTimestamp; ts.IsSet() {
+				return ts.Big().Cmp(big.NewInt(unix)) >= 0
+			}
+		}
+		if err == nil {
+			untimed = i
+		}
+		return true
+	})
+	return i, err
+}
+
+// Reports whether the transaction of the address passes the query's filters
+func (q TransactionQuery) matches(address string, tx ethclient.Transaction) bool {
+	in := strings.EqualFold(recipient(tx), address)
+	out := strings.EqualFold(tx.From.Hex(), address)
+	switch q.Direction {
+	case DirectionIn:
+		if !in {
+			return false
+		}
+	case DirectionOut:
+		if !out {
+			return false
+		}
+	}
+
+	if q.Counterparty != "" {
+		// a transaction to self has the address as its own counterparty
+		if !(out && strings.EqualFold(recipient(tx), q.Counterparty)) && !(in && strings.EqualFold(tx.From.Hex(), q.Counterparty)) {
+			return false
+		}
+	}
+
+	if !q.FromTime.IsZero() || !q.ToTime.IsZero() {
+		if !tx.BlockTimestamp.IsSet() {
+			return false
+		}
+		ts := tx.BlockTimestamp.Big()
+		if !q.FromTime.IsZero() && ts.Cmp(big.NewInt(q.FromTime.Unix())) < 0 {
+			return false
+		}
+		if !q.ToTime.IsZero() && ts.Cmp(big.NewInt(q.ToTime.Unix())) > 0 {
+			return false
+		}
+	}
+
+	if q.MinValue != nil || q.MaxValue != nil {
+		value := tx.Value.Big()
+		if value == nil {
+			return false
+		}
+		if q.MinValue != nil && value.Cmp(q.MinValue) < 0 {
+			return false
+		}
+		if q.MaxValue != nil && value.Cmp(q.MaxValue) > 0 {
+			return false
+		}
+	}
+	return true
+}
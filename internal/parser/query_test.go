@@ -0,0 +1,230 @@
+package parser_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore/memorydb"
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+// Mines blocks 11 through 15 with two transactions each: carol pays alice i wei
+// and alice pays back 100 * i wei
+func newHistory(t *testing.T) *parser.Parser {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+	p.Subscribe(alice)
+
+	for i := 1; i <= 5; i++ {
+		node.mine(
+			newTx(i<<4|1, carol, alice, i),
+			newTx(i<<4|2, alice, usdc, 100*i),
+		)
+	}
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return p
+}
+
+// Returns the numbers of the testHash hashes of the transactions, in hex
+func hashes(txs []parser.Transaction) []string {
+	result := make([]string, 0, len(txs))
+	for _, tx := range txs {
+		result = append(result, fmt.Sprintf("0x%02x", binary.BigEndian.Uint64(tx.Hash[24:])))
+	}
+	return result
+}
+
+func TestTransactionsPagination(t *testing.T) {
+	p := newHistory(t)
+
+	for _, order := range []parser.SortOrder{parser.OrderAsc, parser.OrderDesc} {
+		t.Run(string(order), func(t *testing.T) {
+			var seen []string
+			query := parser.TransactionQuery{Limit: 3, Order: order}
+			for pages := 0; ; pages++ {
+				if pages > 4 {
+					t.Fatal("expected pagination to end")
+				}
+				page, err := p.GetTransactionsPage(alice, query)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(page.Transactions) > 3 {
+					t.Fatalf("expected at most 3 transactions per page, got %d", len(page.Transactions))
+				}
+				seen = append(seen, hashes(page.Transactions)...)
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+
+			expected := []string{"0x11", "0x12", "0x21", "0x22", "0x31", "0x32", "0x41", "0x42", "0x51", "0x52"}
+			if order == parser.OrderDesc {
+				for i, j := 0, len(expected)-1; i < j; i, j = i+1, j-1 {
+					expected[i], expected[j] = expected[j], expected[i]
+				}
+			}
+			if fmt.Sprint(seen) != fmt.Sprint(expected) {
+				t.Fatalf("expected %v, got %v", expected, seen)
+			}
+		})
+	}
+
+	// a page that ends exactly at the last match has no next page
+	page, err := p.GetTransactionsPage(alice, parser.TransactionQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page.Transactions) != 10 || page.NextCursor != "" {
+		t.Errorf("expected a single full page, got %d transactions and cursor %q", len(page.Transactions), page.NextCursor)
+	}
+
+	if _, err := p.GetTransactionsPage(alice, parser.TransactionQuery{Cursor: "not a cursor"}); !errors.Is(err, parser.ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestTransactionsFilters(t *testing.T) {
+	p := newHistory(t)
+
+	tests := []struct {
+		name     string
+		query    parser.TransactionQuery
+		expected []string
+	}{
+		{"BlockRange", parser.TransactionQuery{FromBlock: 12, ToBlock: 13}, []string{"0x21", "0x22", "0x31", "0x32"}},
+		{"FromBlock", parser.TransactionQuery{FromBlock: 15}, []string{"0x51", "0x52"}},
+		{"In", parser.TransactionQuery{Direction: parser.DirectionIn, ToBlock: 12}, []string{"0x11", "0x21"}},
+		{"Out", parser.TransactionQuery{Direction: parser.DirectionOut, FromBlock: 14}, []string{"0x42", "0x52"}},
+		{"MinValue", parser.TransactionQuery{MinValue: big.NewInt(300)}, []string{"0x32", "0x42", "0x52"}},
+		{"ValueRange", parser.TransactionQuery{MinValue: big.NewInt(2), MaxValue: big.NewInt(100)}, []string{"0x12", "0x21", "0x31", "0x41", "0x51"}},
+		{"Counterparty", parser.TransactionQuery{Counterparty: usdc, ToBlock: 12}, []string{"0x12", "0x22"}},
+		{"TimeRange", parser.TransactionQuery{FromTime: blockTime(12), ToTime: blockTime(13)}, []string{"0x21", "0x22", "0x31", "0x32"}},
+		{"FromTime", parser.TransactionQuery{FromTime: blockTime(14).Add(-time.Second)}, []string{"0x41", "0x42", "0x51", "0x52"}},
+		{"ToTime", parser.TransactionQuery{ToTime: blockTime(12).Add(time.Second), Direction: parser.DirectionOut}, []string{"0x12", "0x22"}},
+		{"EmptyTimeRange", parser.TransactionQuery{FromTime: blockTime(16)}, []string{}},
+		{
+			"Combined",
+			parser.TransactionQuery{Direction: parser.DirectionIn, FromBlock: 12, Order: parser.OrderDesc, Limit: 2},
+			[]string{"0x51", "0x41"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := p.GetTransactionsPage(alice, tt.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := hashes(page.Transactions); fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestTransactionsByTimeWithoutTimestamps(t *testing.T) {
+	node := newFakeNode(t, 10)
+
+	// records stored before block timestamps were kept
+	db := memorydb.New()
+	db.Put("tx/"+alice, [][]byte{
+		[]byte(`{"hash":"` + testHash(1).Hex() + `","blockNumber":"0x1","to":"` + alice + `"}`),
+		[]byte(`{"hash":"` + testHash(2).Hex() + `","blockNumber":"0x2","to":"` + alice + `"}`),
+	})
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithDataStore(db))
+	node.mine(newTx(3, carol, alice, 0))
+	node.mine(newTx(4, carol, alice, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		query    parser.TransactionQuery
+		expected []string
+	}{
+		{"FromTime", parser.TransactionQuery{FromTime: blockTime(0)}, []string{"0x03", "0x04"}},
+		{"ToTime", parser.TransactionQuery{ToTime: blockTime(11)}, []string{"0x03"}},
+		{"Desc", parser.TransactionQuery{ToTime: blockTime(12), Order: parser.OrderDesc, Limit: 1}, []string{"0x04"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := p.GetTransactionsPage(alice, tt.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := hashes(page.Transactions); fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	txs := p.GetTransactions(alice)
+	if len(txs) != 4 || txs[0].BlockTimestamp.IsSet() {
+		t.Fatalf("expected 4 transactions, the first without timestamp, got %+v", txs)
+	}
+	if ts, err := txs[2].BlockTimestamp.Int(); err != nil || int64(ts) != blockTime(11).Unix() {
+		t.Errorf("expected timestamp of block 11, got %s", txs[2].BlockTimestamp)
+	}
+}
+
+func TestTransactionsPageWithoutHistory(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+
+	page, err := p.GetTransactionsPage(alice, parser.TransactionQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if page.Transactions == nil || len(page.Transactions) != 0 || page.NextCursor != "" {
+		t.Fatalf("expected an empty last page, got %+v", page)
+	}
+}
+
+func TestTransactionsPaginationWithoutIndex(t *testing.T) {
+	node := newFakeNode(t, 10)
+
+	// records stored before transaction indexes were kept share a position
+	db := memorydb.New()
+	db.Put("tx/"+alice, [][]byte{
+		[]byte(`{"hash":"` + testHash(1).Hex() + `","blockNumber":"0x1","to":"` + alice + `"}`),
+		[]byte(`{"hash":"` + testHash(2).Hex() + `","blockNumber":"0x1","to":"` + alice + `"}`),
+		[]byte(`{"hash":"` + testHash(3).Hex() + `","blockNumber":"0x1","to":"` + alice + `"}`),
+	})
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithDataStore(db))
+
+	for _, order := range []parser.SortOrder{parser.OrderAsc, parser.OrderDesc} {
+		var seen []string
+		query := parser.TransactionQuery{Limit: 1, Order: order}
+		for {
+			page, err := p.GetTransactionsPage(alice, query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			seen = append(seen, hashes(page.Transactions)...)
+			if page.NextCursor == "" || len(seen) > 3 {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+
+		expected := "[0x01 0x02 0x03]"
+		if order == parser.OrderDesc {
+			expected = "[0x03 0x02 0x01]"
+		}
+		if fmt.Sprint(seen) != expected {
+			t.Errorf("expected %s in %s order, got %v", expected, order, seen)
+		}
+	}
+}
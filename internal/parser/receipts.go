@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Returns the receipts of the given transactions of a block. Uses eth_getBlockReceipts,
+// falling back to batched eth_getTransactionReceipt calls if the node does not support it.
+// Returns nil without calling the node if receipts are not fetched.
+func (b *Scanner) fetchReceipts(
+	ctx context.Context,
+	blockNumber int,
+	txs []ethclient.Transaction,
+) ([]ethclient.Receipt, error) {
+	if !b.receipts || len(txs) == 0 {
+		return nil, nil
+	}
+
+	if !b.blockReceiptsUnsupported {
+		receipts, err := b.ethClient.GetBlockReceipts(ctx, blockNumber)
+		if err == nil {
+			return filterReceipts(receipts, txs), nil
+		}
+		if !errors.Is(err, ethclient.ErrMethodNotFound) {
+			return nil, err
+		}
+		b.logger.Printf("node does not support %s, fetching receipts per transaction", ethclient.GetBlockReceiptsMethod)
+		b.blockReceiptsUnsupported = true
+	}
+
+	hashes := make([]string, 0, len(txs))
+	for _, tx := range txs {
+		hashes = append(hashes, tx.Hash.Hex())
+	}
+	return b.ethClient.GetTransactionReceipts(ctx, hashes)
+}
+
+// Returns the receipts belonging to the given transactions
+func filterReceipts(receipts []ethclient.Receipt, txs []ethclient.Transaction) []ethclient.Receipt {
+	wanted := make(map[string]bool, len(txs))
+	for _, tx := range txs {
+		wanted[tx.Hash.Hex()] = true
+	}
+
+	result := make([]ethclient.Receipt, 0, len(txs))
+	for _, receipt := range receipts {
+		if wanted[receipt.TransactionHash] {
+			result = append(result, receipt)
+		}
+	}
+	return result
+}
+
+func putReceipts(batch datastore.Batch, receipts []ethclient.Receipt) error {
+	for _, receipt := range receipts {
+		b, err := json.Marshal(receipt)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(receiptKey(receipt.TransactionHash), [][]byte{b}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the stored receipt of the transaction with the given hash, or nil if there is none
+func getReceipt(db datastore.DataStore, hash string) (*ethclient.Receipt, error) {
+	v, err := db.Get(receiptKey(hash))
+	if errors.Is(err, datastore.KeyDoesNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+
+	var receipt ethclient.Receipt
+	if err := json.Unmarshal(v[0], &receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// Deletes the receipts of the address's transactions, keeping those of transactions that
+// are also in the history of the other party
+func removeReceipts(batch datastore.Batch, address string) error {
+	txs, err := getIndexedTxs(batch, address)
+	if err != nil {
+		return err
+	}
+
+	// hashes in the histories of the other parties, read once per party
+	others := make(map[string]map[string]bool)
+	shared := func(party, hash string) (bool, error) {
+		if party == address {
+			return false, nil
+		}
+		hashes, ok := others[party]
+		if !ok {
+			partyTxs, err := getIndexedTxs(batch, party)
+			if err != nil {
+				return false, err
+			}
+			hashes = make(map[string]bool, len(partyTxs))
+			for _, tx := range partyTxs {
+				hashes[tx.Hash.Hex()] = true
+			}
+			others[party] = hashes
+		}
+		return hashes[hash], nil
+	}
+
+	for _, tx := range txs {
+		hash := tx.Hash.Hex()
+		parties := []string{tx.From.Hex()}
+		if tx.To != nil {
+			parties = append(parties, tx.To.Hex())
+		}
+		keep := false
+		for _, party := range parties {
+			if keep, err = shared(party, hash); err != nil {
+				return err
+			}
+			if keep {
+				break
+			}
+		}
+		if keep {
+			continue
+		}
+		if err := batch.Delete(receiptKey(hash)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the transaction history of the address from a batch, empty if there is none
+func getIndexedTxs(batch datastore.Batch, address string) ([]indexedTx, error) {
+	v, err := batch.Get(txKey(address))
+	if errors.Is(err, datastore.KeyDoesNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return deserializeTxn(v)
+}
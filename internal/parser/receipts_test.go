@@ -0,0 +1,203 @@
+package parser_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+func TestTransactionReceipts(t *testing.T) {
+	const (
+		alice = "0x00000000000000000000000000000000000a11ce"
+		bob   = "0x0000000000000000000000000000000000000b0b"
+	)
+
+	for _, blockReceipts := range []bool{true, false} {
+		name := "BlockReceipts"
+		if !blockReceipts {
+			name = "TransactionReceipts"
+		}
+		t.Run(name, func(t *testing.T) {
+			node := newFakeNode(t, 10)
+			node.noBlockReceipts = !blockReceipts
+			node.reverted[testHash(2).Hex()] = true
+
+			p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithReceipts(true))
+			p.Subscribe(alice)
+			p.Subscribe(bob)
+
+			node.mine(
+				newTx(1, alice, bob, 0),
+				newTx(255, "0x00000000000000000000000000000000000ca201", "0x000000000000000000000000000000000000da7e", 0),
+			)
+			node.mine(newTx(2, bob, alice, 0))
+
+			if err := p.ScanAll(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			txs := p.GetTransactions(alice)
+			if len(txs) != 2 {
+				t.Fatalf("expected 2 transactions, got %d", len(txs))
+			}
+			for _, tx := range txs {
+				if tx.Receipt == nil {
+					t.Fatalf("expected receipt for %s", tx.Hash)
+				}
+				if tx.Receipt.GasUsed != "0x5208" || tx.Receipt.EffectiveGasPrice != "0x3b9aca00" {
+					t.Errorf("unexpected receipt for %s: %+v", tx.Hash, tx.Receipt)
+				}
+				if succeeded := tx.Hash != testHash(2); tx.Receipt.Succeeded() != succeeded {
+					t.Errorf("expected %s succeeded to be %v", tx.Hash, succeeded)
+				}
+			}
+		})
+	}
+}
+
+func TestEffectiveFee(t *testing.T) {
+	const (
+		alice = "0x00000000000000000000000000000000000a11ce"
+		bob   = "0x0000000000000000000000000000000000000b0b"
+	)
+
+	blobTx := newDynamicFeeTx(4, alice, bob, 150, 10)
+	blobTx.Type = ethclient.IntQuantity(ethclient.BlobTxType)
+	blobTx.MaxFeePerBlobGas = ethclient.IntQuantity(5)
+	blobTx.BlobVersionedHashes = []ethclient.Hash{testHash(40)}
+
+	legacyTx := newTx(3, alice, bob, 0)
+	legacyTx.GasPrice = ethclient.IntQuantity(130)
+
+	tests := []struct {
+		tx ethclient.Transaction
+		// effective gas price from the base fee of 100, and fee for 21000 gas plus blob gas
+		price int
+		fee   int
+	}{
+		{newDynamicFeeTx(1, alice, bob, 150, 20), 120, 21000 * 120},
+		{newDynamicFeeTx(2, alice, bob, 110, 20), 110, 21000 * 110},
+		{legacyTx, 130, 21000 * 130},
+		{blobTx, 110, 21000*110 + 1<<17},
+	}
+
+	for _, receipts := range []bool{false, true} {
+		name := "BaseFee"
+		if receipts {
+			name = "Receipts"
+		}
+		t.Run(name, func(t *testing.T) {
+			node := newFakeNode(t, 10)
+			node.baseFee = 100
+			p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithReceipts(receipts))
+			p.Subscribe(alice)
+
+			txs := make([]ethclient.Transaction, 0, len(tests))
+			for _, tt := range tests {
+				txs = append(txs, tt.tx)
+			}
+			node.mine(txs...)
+			if err := p.ScanAll(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := p.GetTransactions(alice)
+			if len(got) != len(tests) {
+				t.Fatalf("expected %d transactions, got %d", len(tests), len(got))
+			}
+			for i, tt := range tests {
+				tx := got[i]
+				if price, err := tx.EffectiveGasPrice.Int(); err != nil || price != tt.price {
+					t.Errorf("expected %s to pay %d per gas, got %s", tx.Hash, tt.price, tx.EffectiveGasPrice)
+				}
+				if !receipts {
+					if tx.EffectiveFee.IsSet() {
+						t.Errorf("expected no fee for %s without a receipt, got %s", tx.Hash, tx.EffectiveFee)
+					}
+					continue
+				}
+				if fee, err := tx.EffectiveFee.Int(); err != nil || fee != tt.fee {
+					t.Errorf("expected %s to pay %d, got %s", tx.Hash, tt.fee, tx.EffectiveFee)
+				}
+			}
+			if got[3].Type.Cmp(ethclient.IntQuantity(ethclient.BlobTxType)) != 0 || len(got[3].BlobVersionedHashes) != 1 {
+				t.Errorf("expected blob transaction fields to be stored, got %+v", got[3].Transaction)
+			}
+		})
+	}
+}
+
+func TestEffectiveFeeBeforeLondon(t *testing.T) {
+	const (
+		alice = "0x00000000000000000000000000000000000a11ce"
+		bob   = "0x0000000000000000000000000000000000000b0b"
+	)
+
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+	p.Subscribe(alice)
+	node.mine(newDynamicFeeTx(1, alice, bob, 150, 20))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	txs := p.GetTransactions(alice)
+	if len(txs) != 1 || txs[0].EffectiveGasPrice.IsSet() {
+		t.Fatalf("expected 1 transaction without a price from a block without base fee, got %+v", txs)
+	}
+}
+
+func TestReceiptsDisabled(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+	p.Subscribe("0x00000000000000000000000000000000000a11ce")
+	p.Subscribe("0x0000000000000000000000000000000000000b0b")
+
+	node.mine(newTx(1, "0x00000000000000000000000000000000000a11ce", "0x0000000000000000000000000000000000000b0b", 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	txs := p.GetTransactions("0x00000000000000000000000000000000000a11ce")
+	if len(txs) != 1 || txs[0].Receipt != nil {
+		t.Fatalf("expected 1 transaction without receipt, got %+v", txs)
+	}
+}
+
+func TestReorgRemovesReceipts(t *testing.T) {
+	const (
+		alice = "0x00000000000000000000000000000000000a11ce"
+		bob   = "0x0000000000000000000000000000000000000b0b"
+	)
+
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithReceipts(true))
+	p.Subscribe(alice)
+	p.Subscribe(bob)
+
+	node.mine(newTx(1, alice, bob, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The transaction is included again on the new branch, where it reverts
+	node.fork(10)
+	node.mine()
+	node.reverted[testHash(1).Hex()] = true
+	node.mine(newTx(1, alice, bob, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	txs := p.GetTransactions(alice)
+	if len(txs) != 1 {
+		t.Fatalf("expected 1 transaction, got %d", len(txs))
+	}
+	if txs[0].Receipt == nil || txs[0].Receipt.Succeeded() || txs[0].Receipt.BlockNumber != "0xc" {
+		t.Fatalf("expected receipt from the new branch, got %+v", txs[0].Receipt)
+	}
+}
@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Appends each record under the key of each of its subscribed counterparties
+func saveToCounterparties[T any](
+	batch datastore.Batch,
+	key func(address string) string,
+	records []T,
+	counterparties func(record T) (string, string),
+) error {
+	byAddress := make(map[string][]T)
+	for _, record := range records {
+		from, to := counterparties(record)
+		byAddress[from] = append(byAddress[from], record)
+		if to != from {
+			byAddress[to] = append(byAddress[to], record)
+		}
+	}
+
+	for addr, records := range byAddress {
+		if !batch.Has(subKey(addr)) {
+			continue
+		}
+		if err := appendRecords(batch, key(addr), records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merges records into those stored under key, skipping records whose id is already stored,
+// and keeps the result ordered by block number. Used where records may arrive out of order,
+// such as history found by a backfill while the scanner indexes new blocks.
+func mergeRecords[T any](
+	batch datastore.Batch,
+	key string,
+	records []T,
+	id func(record T) string,
+	blockNumber func(record T) string,
+) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	old, err := batch.Get(key)
+	if err != nil && !errors.Is(err, datastore.KeyDoesNotExist) {
+		return err
+	}
+	merged, err := deserializeRecords[T](old)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(merged)+len(records))
+	for _, record := range merged {
+		seen[id(record)] = true
+	}
+	for _, record := range records {
+		if !seen[id(record)] {
+			seen[id(record)] = true
+			merged = append(merged, record)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		bi, _ := ethclient.ParseHexInt(blockNumber(merged[i]))
+		bj, _ := ethclient.ParseHexInt(blockNumber(merged[j]))
+		return bi < bj
+	})
+
+	values, err := serializeRecords(merged)
+	if err != nil {
+		return err
+	}
+	return batch.Put(key, values)
+}
+
+// Removes the records matching the predicate stored under the keys of the given addresses
+func removeRecords[T any](
+	batch datastore.Batch,
+	key func(address string) string,
+	addresses []string,
+	remove func(record T) bool,
+) error {
+	for _, addr := range addresses {
+		if err := filterRecords(batch, key(addr), remove); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the records stored under key, or none if the key does not exist
+func getRecords[T any](db datastore.DataStore, key string) ([]T, error) {
+	v, err := db.Get(key)
+	if errors.Is(err, datastore.KeyDoesNotExist) {
+		return []T{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return deserializeRecords[T](v)
+}
@@ -0,0 +1,173 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Number of recent block hashes kept for reorg detection. A reorg deeper than this
+// is followed back through the parent hashes of the orphaned blocks.
+const maxReorgDepth = 64
+
+// Records the hash of an indexed block and forgets hashes older than maxReorgDepth.
+func (b *Scanner) rememberBlock(blockNumber int, hash string) {
+	b.blockHashes[blockNumber] = hash
+	delete(b.blockHashes, blockNumber-maxReorgDepth)
+}
+
+// Walks back from the given block number until the indexed block's hash matches the canonical
+// chain. Returns the common ancestor's block number and hash, and the hashes of the indexed
+// blocks after it. Past the tracked blocks, the indexed chain is followed through the parent
+// hashes of orphaned headers fetched from the node.
+func (b *Scanner) findCommonAncestor(ctx context.Context, from int) (int, string, map[string]bool, error) {
+	orphaned := make(map[string]bool)
+	for blockNumber, hash := range b.blockHashes {
+		if blockNumber > from {
+			orphaned[hash] = true
+		}
+	}
+
+	hash, ok := b.blockHashes[from]
+	if !ok {
+		return 0, "", nil, fmt.Errorf("block %d is not tracked", from)
+	}
+	for blockNumber := from; blockNumber >= 0; blockNumber-- {
+		header, err := b.ethClient.GetHeaderByNumber(ctx, blockNumber)
+		if err != nil {
+			return 0, "", nil, err
+		}
+		if header.Hash.Hex() == hash {
+			return blockNumber, hash, orphaned, nil
+		}
+		orphaned[hash] = true
+
+		parentHash, ok := b.blockHashes[blockNumber-1]
+		if !ok {
+			orphan, err := b.ethClient.GetHeaderByHash(ctx, hash)
+			if err != nil {
+				return 0, "", nil, fmt.Errorf("error fetching orphaned block %d: %w", blockNumber, err)
+			}
+			parentHash = orphan.ParentHash.Hex()
+		}
+		hash = parentHash
+	}
+	return 0, "", nil, errors.New("no common ancestor with the canonical chain")
+}
+
+// Removes transactions from blocks after the common ancestor and rewinds the scanner to it.
+func (b *Scanner) handleReorg(ctx context.Context, from int) error {
+	ancestor, ancestorHash, orphaned, err := b.findCommonAncestor(ctx, from)
+	if err != nil {
+		return err
+	}
+
+	addresses, err := listKeys(b.db, txKeyPrefix)
+	if err != nil {
+		return err
+	}
+	tokenAddresses, err := listKeys(b.db, tokenKeyPrefix)
+	if err != nil {
+		return err
+	}
+	nftAddresses, err := listKeys(b.db, nftKeyPrefix)
+	if err != nil {
+		return err
+	}
+	internalAddresses, err := listKeys(b.db, internalKeyPrefix)
+	if err != nil {
+		return err
+	}
+	withdrawalAddresses, err := listKeys(b.db, withdrawalKeyPrefix)
+	if err != nil {
+		return err
+	}
+	deliveryIDs, err := listKeys(b.db, outboxKeyPrefix)
+	if err != nil {
+		return err
+	}
+
+	// Roll back the transactions together with the checkpoint
+	checkpoint := Checkpoint{Number: ancestor, Hash: ancestorHash}
+	if err := b.db.Batch(func(batch datastore.Batch) error {
+		if err := removeTxs(batch, addresses, func(tx ethclient.Transaction) bool {
+			return orphaned[tx.BlockHash.Hex()]
+		}); err != nil {
+			return err
+		}
+		if err := removeTokenTransfers(batch, tokenAddresses, func(transfer TokenTransfer) bool {
+			return orphaned[transfer.BlockHash]
+		}); err != nil {
+			return err
+		}
+		if err := removeNFTTransfers(batch, nftAddresses, func(transfer NFTTransfer) bool {
+			return orphaned[transfer.BlockHash]
+		}); err != nil {
+			return err
+		}
+		if err := removeInternalTxs(batch, internalAddresses, func(tx InternalTransaction) bool {
+			return orphaned[tx.BlockHash]
+		}); err != nil {
+			return err
+		}
+		if err := removeWithdrawals(batch, withdrawalAddresses, func(w Withdrawal) bool {
+			return orphaned[w.BlockHash]
+		}); err != nil {
+			return err
+		}
+		// pending webhooks of orphaned transactions, which are delivered again if included again
+		if err := removeDeliveries(batch, outboxKeyPrefix, deliveryIDs, func(d WebhookDelivery) bool {
+			return orphaned[d.BlockHash]
+		}); err != nil {
+			return err
+		}
+		return putCheckpoint(batch, checkpoint)
+	}); err != nil {
+		return err
+	}
+
+	for blockNumber := range b.blockHashes {
+		if blockNumber > ancestor {
+			delete(b.blockHashes, blockNumber)
+		}
+	}
+	// the ancestor may be older than the tracked blocks
+	b.blockHashes[ancestor] = ancestorHash
+
+	b.logger.Printf("Rolled back %d orphaned blocks to block %d\n", len(orphaned), ancestor)
+	b.lastBlockNumber.Store(int64(ancestor))
+	return nil
+}
+
+func removeTxs(
+	batch datastore.Batch,
+	addresses []string,
+	remove func(tx ethclient.Transaction) bool,
+) error {
+	removed := make(map[string]bool)
+	for _, addr := range addresses {
+		if err := batch.Update(txKey(addr), func(oldTxs [][]byte) ([][]byte, error) {
+			return filterDBTxns(oldTxs, func(tx indexedTx) bool {
+				if remove(tx.Transaction) {
+					removed[tx.Hash.Hex()] = true
+					return true
+				}
+				return false
+			})
+		}); err != nil {
+			return err
+		}
+	}
+
+	// receipts of removed transactions are stale, a transaction included again gets a new one
+	for hash := range removed {
+		if err := batch.Delete(receiptKey(hash)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
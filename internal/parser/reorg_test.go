@@ -0,0 +1,94 @@
+package parser_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+func TestScanAllReorg(t *testing.T) {
+	const (
+		alice = "0x00000000000000000000000000000000000a11ce"
+		bob   = "0x0000000000000000000000000000000000000b0b"
+	)
+
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+	p.Subscribe(alice)
+	p.Subscribe(bob)
+
+	node.mine(newTx(1, alice, bob, 0))
+	orphaned := node.mine(newTx(2, alice, bob, 0))
+	node.mine(newTx(3, bob, alice, 0))
+
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if txs := p.GetTransactions(alice); len(txs) != 3 {
+		t.Fatalf("expected 3 transactions before reorg, got %d", len(txs))
+	}
+
+	// Replace blocks 12 and 13 with a longer branch.
+	node.fork(11)
+	node.mine(newTx(4, bob, alice, 0))
+	node.mine()
+	node.mine(newTx(5, alice, bob, 0))
+
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if p.GetCurrentBlock() != 14 {
+		t.Fatalf("expected current block 14, got %d", p.GetCurrentBlock())
+	}
+
+	txs := p.GetTransactions(alice)
+	want := []ethclient.Hash{testHash(1), testHash(4), testHash(5)}
+	if len(txs) != len(want) {
+		t.Fatalf("expected %d transactions after reorg, got %d", len(want), len(txs))
+	}
+	for i, tx := range txs {
+		if tx.Hash != want[i] {
+			t.Errorf("expected transaction %d to be %s, got %s", i, want[i], tx.Hash)
+		}
+		if tx.BlockHash == orphaned.Hash {
+			t.Errorf("transaction %s from orphaned block was not removed", tx.Hash)
+		}
+	}
+}
+
+func TestScanAllDeepReorg(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+	p.Subscribe(alice)
+
+	node.mine(newTx(1, carol, alice, 0))
+	for i := 0; i < 80; i++ {
+		node.mine()
+	}
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the branch replaces more blocks than the scanner tracks
+	node.fork(10)
+	node.mine(newTx(2, carol, alice, 0))
+	for i := 0; i < 81; i++ {
+		node.mine()
+	}
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	txs := p.GetTransactions(alice)
+	if len(txs) != 1 || txs[0].Hash != testHash(2) {
+		t.Fatalf("expected only the transaction from the new branch, got %+v", txs)
+	}
+	if p.GetCurrentBlock() != 92 {
+		t.Fatalf("expected current block 92, got %d", p.GetCurrentBlock())
+	}
+}
@@ -2,6 +2,10 @@ package parser
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/zihaolam/ethereum-parser/internal/datastore"
@@ -10,10 +14,63 @@ import (
 )
 
 type Scanner struct {
-	db              datastore.DataStore
-	logger          logging.Logger
-	ethClient       *ethclient.Client
-	lastBlockNumber int
+	db        datastore.DataStore
+	logger    logging.Logger
+	ethClient *ethclient.Client
+	// last block processed, read by API handlers while the scanner advances it
+	lastBlockNumber atomic.Int64
+	// hashes of recently indexed blocks by block number, used to detect reorgs
+	blockHashes map[int]string
+
+	confirmations int
+	headTag       string
+	// latest and finalized block numbers last seen on the node, used to report settlement
+	latestBlockNumber    atomic.Int64
+	finalizedBlockNumber atomic.Int64
+
+	// incremented whenever an address is subscribed, so that a block being indexed
+	// can tell that its subscribed transactions are incomplete
+	subscriptionsVersion atomic.Int64
+
+	// number of concurrent block fetches and how many blocks may be fetched ahead
+	workers  int
+	prefetch int
+	stats    scanStats
+
+	// WebSocket endpoint for new head notifications, polling only if empty
+	wsEndpoint  string
+	wsConnected atomic.Bool
+	// unix time in nanoseconds the subscription last delivered a head, or connected
+	lastHeadAt atomic.Int64
+
+	// whether to fetch receipts of subscribed transactions, and whether the node
+	// turned out not to support eth_getBlockReceipts
+	receipts                 bool
+	blockReceiptsUnsupported bool
+	// whether to index ERC-20 and NFT transfers
+	tokens bool
+	nfts   bool
+
+	// whether to trace blocks for internal transactions, and whether the node
+	// turned out not to support debug_traceBlockByNumber
+	tracing               bool
+	debugTraceUnsupported bool
+
+	// signals the backfill worker that a job was queued, and whether the node
+	// turned out not to support trace_filter
+	backfillWake           chan struct{}
+	traceFilterUnsupported bool
+
+	// signs and retries webhook deliveries, and signals the webhook worker that
+	// deliveries were queued
+	webhookSecret   string
+	webhookRetry    WebhookRetryPolicy
+	privateWebhooks bool
+	webhookClient   *http.Client
+	webhookWake     chan struct{}
+	// webhook URLs a sender is delivering to
+	webhookMutex   sync.Mutex
+	webhookSending map[string]bool
 }
 
 func NewScanner(
@@ -21,34 +78,78 @@ func NewScanner(
 	ethClient *ethclient.Client,
 	logger logging.Logger,
 	initialBlockNumber int,
+	opts ...Option,
 ) *Scanner {
-	return &Scanner{
+	o := applyOptions(opts)
+	scanner := &Scanner{
 		db:              db,
 		ethClient:       ethClient,
 		logger:          logger,
-		lastBlockNumber: initialBlockNumber,
+		blockHashes:     make(map[int]string),
+		confirmations:   o.confirmations,
+		headTag:         o.headTag,
+		workers:         max(o.workers, 1),
+		prefetch:        max(o.prefetch, 1),
+		wsEndpoint:      o.wsEndpoint,
+		receipts:        o.receipts,
+		tokens:          o.tokens,
+		nfts:            o.nfts,
+		tracing:         o.tracing,
+		backfillWake:    make(chan struct{}, 1),
+		webhookSecret:   o.webhookSecret,
+		webhookRetry:    o.webhookRetry,
+		privateWebhooks: o.privateWebhooks,
+		webhookClient:   newWebhookClient(o.privateWebhooks),
+		webhookWake:     make(chan struct{}, 1),
+		webhookSending:  make(map[string]bool),
+	}
+
+	scanner.lastBlockNumber.Store(int64(initialBlockNumber))
+
+	// Resume from the checkpoint, initialBlockNumber only applies to a fresh datastore
+	checkpoint, ok, err := loadCheckpoint(db)
+	if err != nil {
+		logger.Printf("error loading checkpoint, starting from block %d: %v", initialBlockNumber, err)
+	}
+	if ok {
+		logger.Printf("Resuming from checkpoint block %d\n", checkpoint.Number)
+		scanner.lastBlockNumber.Store(int64(checkpoint.Number))
+		if checkpoint.Hash != "" {
+			scanner.blockHashes[checkpoint.Number] = checkpoint.Hash
+		}
 	}
+
+	return scanner
 }
 
-// Save transactions to the datastore
+// Save transactions to the datastore, without the context of their blocks
 func (b *Scanner) SaveTxs(txs []ethclient.Transaction) error {
-	txMap := make(map[string][]ethclient.Transaction)
+	return b.db.Batch(func(batch datastore.Batch) error {
+		return saveTxs(batch, indexTxs(ethclient.Header{}, txs))
+	})
+}
+
+func saveTxs(batch datastore.Batch, txs []indexedTx) error {
+	txMap := make(map[string][]indexedTx)
 
 	for _, tx := range txs {
-		if _, ok := txMap[tx.From]; !ok {
-			txMap[tx.From] = make([]ethclient.Transaction, 0)
+		from, to := tx.From.Hex(), recipient(tx.Transaction)
+		if _, ok := txMap[from]; !ok {
+			txMap[from] = make([]indexedTx, 0)
 		}
-		if _, ok := txMap[tx.To]; !ok {
-			txMap[tx.To] = make([]ethclient.Transaction, 0)
+		if _, ok := txMap[to]; !ok {
+			txMap[to] = make([]indexedTx, 0)
 		}
-		txMap[tx.From] = append(txMap[tx.From], tx)
-		txMap[tx.To] = append(txMap[tx.To], tx)
+		txMap[from] = append(txMap[from], tx)
+		txMap[to] = append(txMap[to], tx)
 	}
 
 	for addr, txs := range txMap {
-		if err := b.db.Update(addr, func(oldTxs [][]byte) ([][]byte, error) {
-			return appendDBTxns(oldTxs, txs)
-		}); err != nil {
+		// only subscribed addresses keep a history, created with their first transaction
+		if !batch.Has(subKey(addr)) {
+			continue
+		}
+		if err := appendRecords(batch, txKey(addr), txs); err != nil {
 			return err
 		}
 	}
@@ -60,7 +161,7 @@ func (b *Scanner) SaveTxs(txs []ethclient.Transaction) error {
 func (b *Scanner) FilterSubscribedTxs(txs []ethclient.Transaction) []ethclient.Transaction {
 	subscribedTxs := make([]ethclient.Transaction, 0)
 	for _, tx := range txs {
-		if b.db.Has(tx.To) || b.db.Has(tx.From) {
+		if b.isSubscribed(recipient(tx)) || b.isSubscribed(tx.From.Hex()) {
 			subscribedTxs = append(subscribedTxs, tx)
 		}
 	}
@@ -68,29 +169,86 @@ func (b *Scanner) FilterSubscribedTxs(txs []ethclient.Transaction) []ethclient.T
 	return subscribedTxs
 }
 
+// Returns the address the transaction is sent to, or "" for a contract creation
+func recipient(tx ethclient.Transaction) string {
+	if tx.To == nil {
+		return ""
+	}
+	return tx.To.Hex()
+}
+
+// Reports whether the address is subscribed to
+func (b *Scanner) isSubscribed(address string) bool {
+	a, err := ethclient.ParseAddress(address)
+	if err != nil {
+		// such as the empty recipient of a contract creation
+		return false
+	}
+	return b.db.Has(subKey(a.Hex()))
+}
+
 // Save transactions to subscribers
 func (b *Scanner) SaveTxsToSubscribers(txs []ethclient.Transaction) error {
 	subscribedTxs := b.FilterSubscribedTxs(txs)
 	return b.SaveTxs(subscribedTxs)
 }
 
+// Returns the number of the block the scanner follows as chain head
+func (b *Scanner) getHeadBlockNumber(ctx context.Context) (int, error) {
+	if b.headTag == ethclient.LatestTag {
+		return b.ethClient.GetCurrentBlockNumber(ctx)
+	}
+	return b.ethClient.GetBlockNumberByTag(ctx, b.headTag)
+}
+
+// Refreshes the latest and finalized block numbers used to compute confirmations
+func (b *Scanner) updateChainHeads(ctx context.Context) error {
+	latest, err := b.ethClient.GetCurrentBlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	b.latestBlockNumber.Store(int64(latest))
+
+	finalized, err := b.ethClient.GetBlockNumberByTag(ctx, ethclient.FinalizedTag)
+	if err != nil {
+		// Nodes without finality support still report confirmations
+		b.logger.Printf("error getting finalized block number: %v", err)
+		return nil
+	}
+	b.finalizedBlockNumber.Store(int64(finalized))
+	return nil
+}
+
 // Returns 0 if there are no more new blocks else returns the next block number
 func (b *Scanner) GetNextBlock(ctx context.Context) (int, error) {
-	currBlockNumber, err := b.ethClient.GetCurrentBlockNumber(ctx)
+	from, _, err := b.getNextBlockRange(ctx)
+	return from, err
+}
+
+// Returns the range of blocks between the last processed block and the chain head.
+// Returns 0 for both if there are no new blocks.
+func (b *Scanner) getNextBlockRange(ctx context.Context) (int, int, error) {
+	currBlockNumber, err := b.getHeadBlockNumber(ctx)
 	if err != nil {
 		b.logger.Printf("error getting current block number: %v", err)
-		return 0, err
+		return 0, 0, err
 	}
 
-	if b.lastBlockNumber == currBlockNumber {
-		return 0, nil
+	lastBlockNumber := int(b.lastBlockNumber.Load())
+	if lastBlockNumber >= currBlockNumber {
+		return 0, 0, nil
 	}
 
-	if b.lastBlockNumber == 0 {
-		return currBlockNumber, nil
+	if lastBlockNumber == 0 {
+		return currBlockNumber, currBlockNumber, nil
 	}
 
-	return b.lastBlockNumber + 1, nil
+	return lastBlockNumber + 1, currBlockNumber, nil
+}
+
+// Returns the scanner's progress and throughput
+func (b *Scanner) Stats() Stats {
+	return b.stats.get()
 }
 
 // Expose scanblock method
@@ -100,57 +258,202 @@ func (b *Scanner) ScanBlock(ctx context.Context, blockNumber int) (ethclient.Blo
 
 // Scan checks for new blocks and saves transactions to the datastore.
 func (b *Scanner) ScanAll(ctx context.Context) error {
-	// scan and save new blocks
+	if err := b.updateChainHeads(ctx); err != nil {
+		return err
+	}
+
+	// scan and save new blocks, starting over after a reorg or when the head moved on
 	for {
-		nextBlock, err := b.GetNextBlock(ctx)
+		from, to, err := b.getNextBlockRange(ctx)
 		if err != nil {
 			return err
 		}
-		if nextBlock == 0 {
+		if from == 0 {
 			break
 		}
 
-		b.logger.Printf("Scanning block %d\n", nextBlock)
-		block, err := b.ScanBlock(ctx, nextBlock)
-		if err != nil {
+		if err := b.scanRange(ctx, from, to); err != nil {
 			return err
 		}
-		b.logger.Println("Saving transactions")
-		err = b.SaveTxsToSubscribers(block.Transactions)
+	}
+
+	return nil
+}
+
+// Fetches the blocks from..to concurrently and processes them in order.
+// Stops early, without error, after rolling back a reorg.
+func (b *Scanner) scanRange(ctx context.Context, from, to int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	b.stats.start(int(b.lastBlockNumber.Load()), to)
+	for result := range b.fetchBlocks(ctx, from, to) {
+		if result.err != nil {
+			return result.err
+		}
+
+		reorged, err := b.processBlock(ctx, result.number, result.block)
 		if err != nil {
 			return err
 		}
+		if reorged {
+			return nil
+		}
+		b.stats.processedBlock(result.number)
+	}
 
-		b.lastBlockNumber = nextBlock
+	// the pipeline also stops when the parent context is cancelled
+	return ctx.Err()
+}
+
+// Saves the block's subscribed transactions and advances the scanner to it.
+// Returns true instead if the block does not extend the indexed chain and a reorg was rolled back.
+func (b *Scanner) processBlock(ctx context.Context, blockNumber int, block ethclient.Block) (bool, error) {
+	b.logger.Printf("Scanning block %d\n", blockNumber)
+	if parentHash, ok := b.blockHashes[blockNumber-1]; ok && parentHash != block.ParentHash.Hex() {
+		b.logger.Printf("Reorg detected at block %d\n", blockNumber)
+		return true, b.handleReorg(ctx, blockNumber-1)
 	}
 
-	return nil
+	b.logger.Println("Saving transactions")
+	webhooks, err := b.indexBlock(ctx, blockNumber, block)
+	for errors.Is(err, errSubscriptionsChanged) {
+		webhooks, err = b.indexBlock(ctx, blockNumber, block)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	b.rememberBlock(blockNumber, block.Hash.Hex())
+	b.lastBlockNumber.Store(int64(blockNumber))
+	if webhooks > 0 {
+		b.wakeWebhooks()
+	}
+	return false, nil
 }
 
-// Interval scan for new blocks
-func (b *Scanner) StartScan(ctx context.Context, interval time.Duration) {
-	timer1 := time.NewTimer(interval)
+var errSubscriptionsChanged = errors.New("subscriptions changed")
 
-	// initial scan
-	err := b.ScanAll(ctx)
+// Saves the block's subscribed transactions and advances the checkpoint to it. Returns the
+// number of webhook deliveries queued, or errSubscriptionsChanged if an address was subscribed
+// while the block was being indexed, in which case the block has to be indexed again.
+func (b *Scanner) indexBlock(ctx context.Context, blockNumber int, block ethclient.Block) (int, error) {
+	// Save the block's transactions together with the checkpoint so a restart
+	// never skips or double counts a block
+	version := b.subscriptionsVersion.Load()
+	subscribedTxs := b.FilterSubscribedTxs(block.Transactions)
+	receipts, err := b.fetchReceipts(ctx, blockNumber, subscribedTxs)
+	if err != nil {
+		return 0, err
+	}
+
+	logs, err := b.fetchEventLogs(ctx, block.Hash.Hex())
 	if err != nil {
-		b.logger.Printf("error scanning: %v", err)
+		return 0, err
+	}
+	var tokenTransfers []TokenTransfer
+	if b.tokens {
+		tokenTransfers = b.filterTokenTransfers(logs)
+	}
+	var nftTransfers []NFTTransfer
+	if b.nfts {
+		nftTransfers = b.filterNFTTransfers(logs)
+	}
+
+	internalTxs, err := b.fetchInternalTxs(ctx, blockNumber, block)
+	if err != nil {
+		return 0, err
+	}
+	withdrawals := b.filterWithdrawals(blockWithdrawals(block))
+
+	// subscriptions made before the scanner indexed a block start at its first block
+	var unstarted []string
+	if b.lastBlockNumber.Load() == 0 {
+		if unstarted, err = listKeys(b.db, subKeyPrefix); err != nil {
+			return 0, err
+		}
+	}
+
+	// webhook deliveries are queued in the outbox together with the block, so that none
+	// is lost or queued twice
+	txs := indexTxs(block.Header, subscribedTxs)
+	webhooks := 0
+	backfills := false
+	err = b.db.Batch(func(batch datastore.Batch) error {
+		if b.subscriptionsVersion.Load() != version {
+			// the new address was not looked for in the block
+			return errSubscriptionsChanged
+		}
+		if err := saveTxs(batch, txs); err != nil {
+			return err
+		}
+		queued, err := b.queueWebhooks(batch, txs, receipts)
+		if err != nil {
+			return err
+		}
+		webhooks = queued
+		if err := putReceipts(batch, receipts); err != nil {
+			return err
+		}
+		if err := saveTokenTransfers(batch, tokenTransfers); err != nil {
+			return err
+		}
+		if err := saveNFTTransfers(batch, nftTransfers); err != nil {
+			return err
+		}
+		if err := saveInternalTxs(batch, internalTxs); err != nil {
+			return err
+		}
+		if err := saveWithdrawals(batch, withdrawals); err != nil {
+			return err
+		}
+		if backfills, err = startSubscriptions(batch, unstarted, blockNumber); err != nil {
+			return err
+		}
+		return putCheckpoint(batch, Checkpoint{Number: blockNumber, Hash: block.Hash.Hex()})
+	})
+	if err == nil && backfills {
+		b.wakeBackfills()
+	}
+	return webhooks, err
+}
+
+// Scans for new blocks whenever the WebSocket endpoint reports a new head, if one is configured,
+// and otherwise at every interval. Polling resumes while the subscription is down.
+func (b *Scanner) StartScan(ctx context.Context, interval time.Duration) {
+	wake := make(chan struct{}, 1)
+	if b.wsEndpoint != "" {
+		go b.followNewHeads(ctx, wake)
 	}
+	go b.runBackfills(ctx)
+	go b.runWebhooks(ctx)
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	scan := func() {
+		if err := b.ScanAll(ctx); err != nil && ctx.Err() == nil {
+			b.logger.Printf("error scanning: %v", err)
+		}
+	}
+
+	// initial scan
+	scan()
 
 	for {
 		select {
 		case <-ctx.Done():
 			b.logger.Println("Stopping scanner")
 			return
-		case <-timer1.C:
-			go func() {
-				err := b.ScanAll(ctx)
+		case <-wake:
+			scan()
+		case <-timer.C:
+			// poll while the subscription is down, or connected but not delivering heads
+			if !b.wsConnected.Load() || time.Since(time.Unix(0, b.lastHeadAt.Load())) >= interval {
 				b.logger.Printf("Scanning for new blocks\n")
-				if err != nil {
-					b.logger.Printf("error scanning: %v", err)
-				}
-				timer1.Reset(10 * time.Second)
-			}()
+				scan()
+			}
+			timer.Reset(interval)
 		}
 	}
 }
@@ -3,14 +3,14 @@ package parser
 import (
 	"encoding/json"
 
-	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
 )
 
-func deserializeTxn(txBytes [][]byte) ([]ethclient.Transaction, error) {
-	txs := make([]ethclient.Transaction, 0, len(txBytes))
+func deserializeTxn(txBytes [][]byte) ([]indexedTx, error) {
+	txs := make([]indexedTx, 0, len(txBytes))
 	for _, tx := range txBytes {
-		var txn ethclient.Transaction
-		if err := json.Unmarshal(tx, &txn); err != nil {
+		var txn indexedTx
+		if err := decodeTxn(tx, &txn); err != nil {
 			return nil, err
 		}
 		txs = append(txs, txn)
@@ -18,23 +18,93 @@ func deserializeTxn(txBytes [][]byte) ([]ethclient.Transaction, error) {
 	return txs, nil
 }
 
-func serializeTxn(txs []ethclient.Transaction) ([][]byte, error) {
+func serializeTxn(txs []indexedTx) ([][]byte, error) {
 	txBytes := make([][]byte, 0, len(txs))
 	for _, tx := range txs {
-		b, err := json.Marshal(tx)
-		if err != nil {
-			return nil, err
-		}
-		txBytes = append(txBytes, b)
+		txBytes = append(txBytes, encodeTxn(tx))
 	}
 	return txBytes, nil
 }
 
-func appendDBTxns(txBytes [][]byte, newTxs []ethclient.Transaction) ([][]byte, error) {
+func filterDBTxns(txBytes [][]byte, remove func(indexedTx) bool) ([][]byte, error) {
 	txs, err := deserializeTxn(txBytes)
 	if err != nil {
 		return nil, err
 	}
-	txs = append(txs, newTxs...)
-	return serializeTxn(txs)
+	kept := make([]indexedTx, 0, len(txs))
+	for _, tx := range txs {
+		if !remove(tx) {
+			kept = append(kept, tx)
+		}
+	}
+	return serializeTxn(kept)
+}
+
+// Decodes a record of any type, transactions in their binary format and others as JSON
+func decodeRecord[T any](v []byte, record *T) error {
+	if tx, ok := any(record).(*indexedTx); ok {
+		return decodeTxn(v, tx)
+	}
+	return json.Unmarshal(v, record)
+}
+
+// Encodes a record of any type, transactions in their binary format and others as JSON
+func encodeRecord[T any](record T) ([]byte, error) {
+	if tx, ok := any(record).(indexedTx); ok {
+		return encodeTxn(tx), nil
+	}
+	return json.Marshal(record)
+}
+
+// Decodes records of any type
+func deserializeRecords[T any](values [][]byte) ([]T, error) {
+	records := make([]T, 0, len(values))
+	for _, v := range values {
+		var record T
+		if err := decodeRecord(v, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Encodes records of any type
+func serializeRecords[T any](records []T) ([][]byte, error) {
+	values := make([][]byte, 0, len(records))
+	for _, record := range records {
+		b, err := encodeRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, b)
+	}
+	return values, nil
+}
+
+// Appends records to those stored under key, creating the key if it does not exist.
+// Stored records are neither read nor rewritten.
+func appendRecords[T any](batch datastore.Batch, key string, records []T) error {
+	values, err := serializeRecords(records)
+	if err != nil {
+		return err
+	}
+	return batch.Append(key, values)
+}
+
+// Removes the records matching the predicate from those stored under key
+func filterRecords[T any](batch datastore.Batch, key string, remove func(T) bool) error {
+	return batch.Update(key, func(old [][]byte) ([][]byte, error) {
+		records, err := deserializeRecords[T](old)
+		if err != nil {
+			return nil, err
+		}
+		kept := make([]T, 0, len(records))
+		for _, record := range records {
+			if !remove(record) {
+				kept = append(kept, record)
+			}
+		}
+		return serializeRecords(kept)
+	})
 }
@@ -4,12 +4,14 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/datastore/memorydb"
 	"github.com/zihaolam/ethereum-parser/internal/ethclient"
 )
 
 func TestDeserializeTxn(t *testing.T) {
 	// Setup sample transaction in JSON format
-	txn := ethclient.Transaction{Hash: "0x12345"}
+	txn := ethclient.Transaction{Hash: ethclient.Hash{0x12, 0x34, 0x5}}
 	txnBytes, err := json.Marshal(txn)
 	if err != nil {
 		t.Fatalf("unexpected error marshaling transaction: %v", err)
@@ -31,19 +33,19 @@ func TestDeserializeTxn(t *testing.T) {
 		t.Fatalf("expected transaction hash %s, got %s", txn.Hash, txs[0].Hash)
 	}
 
-	// Test error handling for invalid JSON
+	// Test error handling for input in neither format
 	invalidTxBytes := [][]byte{[]byte("invalid json")}
 	_, err = deserializeTxn(invalidTxBytes)
 	if err == nil {
-		t.Fatal("expected error for invalid JSON input")
+		t.Fatal("expected error for invalid input")
 	}
 }
 
 func TestSerializeTxn(t *testing.T) {
 	// Setup sample transactions
-	txs := []ethclient.Transaction{
-		{Hash: "0x12345"},
-		{Hash: "0x67890"},
+	txs := []indexedTx{
+		{Transaction: ethclient.Transaction{Hash: ethclient.Hash{0x12, 0x34, 0x5}}},
+		{Transaction: ethclient.Transaction{Hash: ethclient.Hash{0x67, 0x89}}},
 	}
 
 	// Test serialization
@@ -69,26 +71,33 @@ func TestSerializeTxn(t *testing.T) {
 	}
 }
 
-func TestAppendDBTxns(t *testing.T) {
-	// Setup initial transaction in JSON format
-	initialTxn := ethclient.Transaction{Hash: "0x12345"}
+func TestSaveTxsAppends(t *testing.T) {
+	const address = "0x00000000000000000000000000000000000a11ce"
+
+	db := memorydb.New()
+	db.Put(subKey(address), [][]byte{[]byte("{}")})
+	// history stored in the JSON encoding of earlier versions
+	initialTxn := ethclient.Transaction{Hash: ethclient.Hash{0x12, 0x34, 0x5}}
 	initialBytes, err := json.Marshal(initialTxn)
 	if err != nil {
 		t.Fatalf("unexpected error marshaling initial transaction: %v", err)
 	}
-	txBytes := [][]byte{initialBytes}
-
-	// New transaction to append
-	newTxn := ethclient.Transaction{Hash: "0x67890"}
+	db.Put(txKey(address), [][]byte{initialBytes})
 
-	// Test appending transaction
-	updatedTxBytes, err := appendDBTxns(txBytes, []ethclient.Transaction{newTxn})
-	if err != nil {
+	from, _ := ethclient.ParseAddress(address)
+	newTxn := indexedTx{Transaction: ethclient.Transaction{Hash: ethclient.Hash{0x67, 0x89}, From: from}}
+	if err := db.Batch(func(batch datastore.Batch) error {
+		return saveTxs(batch, []indexedTx{newTxn})
+	}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
 	// Deserialize to verify contents
-	updatedTxs, err := deserializeTxn(updatedTxBytes)
+	values, err := db.Get(txKey(address))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updatedTxs, err := deserializeTxn(values)
 	if err != nil {
 		t.Fatalf("unexpected error during deserialization: %v", err)
 	}
@@ -102,6 +111,314 @@ func TestAppendDBTxns(t *testing.T) {
 	}
 
 	if updatedTxs[1].Hash != newTxn.Hash {
-		t.Fatalf("expected second transaction hash %s, got %s", newTxn.Hash, updatedTxs[0].Hash)
+		t.Fatalf("expected second transaction hash %s, got %s", newTxn.Hash, updatedTxs[1].Hash)
+	}
+}
+
+// A mined transaction as returned by eth_getTransactionByHash
+const nodeTxn = `{
+	"hash": "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b",
+	"blockHash": "0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2",
+	"blockNumber": "0x5daf3b",
+	"transactionIndex": "0x41",
+	"from": "0xa7d9ddbe1f17865597fbd27ec712455208b6b76d",
+	"to": "0xf02c1c8e6114b1dbe8937a39260b5b0a374432bb",
+	"value": "0xf3dbb76162000",
+	"nonce": "0x15",
+	"gas": "0xc350",
+	"gasPrice": "0x4a817c800",
+	"input": "0x68656c6c6f21",
+	"chainId": "0x1"
+}`
+
+// A blob transaction as returned by eth_getTransactionByHash
+const blobTxn = `{
+	"type": "0x3",
+	"hash": "0x5ceec39b631763ae0b45a8fb55c373f38b8fab308336ca1dc90ecd2b3cf06d00",
+	"blockHash": "0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2",
+	"blockNumber": "0x12a4f6e",
+	"blockTimestamp": "0x6655c1e3",
+	"transactionIndex": "0x3",
+	"from": "0xa7d9ddbe1f17865597fbd27ec712455208b6b76d",
+	"to": "0xf02c1c8e6114b1dbe8937a39260b5b0a374432bb",
+	"value": "0x0",
+	"nonce": "0x15",
+	"gas": "0x5208",
+	"gasPrice": "0x3b9aca0e",
+	"maxFeePerGas": "0x77359400",
+	"maxPriorityFeePerGas": "0xe",
+	"maxFeePerBlobGas": "0x3b9aca00",
+	"blobVersionedHashes": [
+		"0x01a915e4d060149eb4365960e6a7a45f334393093061116b197e3240065ff2d8",
+		"0x0100000000000000000000000000000000000000000000000000000000000000"
+	],
+	"input": "0x",
+	"accessList": [
+		{
+			"address": "0x0000000000000000000000000000000000000b0b",
+			"storageKeys": [
+				"0x0000000000000000000000000000000000000000000000000000000000000001",
+				"0x0000000000000000000000000000000000000000000000000000000000000002"
+			]
+		},
+		{"address": "0x00000000000000000000000000000000000a11ce", "storageKeys": []}
+	],
+	"chainId": "0x1",
+	"v": "0x1",
+	"r": "0x7a7b5d2b8b17a1ee9fd7d53f8a1e3bc1f58b3e7a64bdb0b94e97d0ab3c8e5f21",
+	"s": "0x2b3c1d8e9f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c",
+	"yParity": "0x1"
+}`
+
+// A set code transaction as returned by eth_getTransactionByHash
+const setCodeTxn = `{
+	"type": "0x4",
+	"hash": "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b",
+	"blockHash": "0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2",
+	"blockNumber": "0x15d3b5a",
+	"transactionIndex": "0x0",
+	"from": "0xa7d9ddbe1f17865597fbd27ec712455208b6b76d",
+	"to": "0xa7d9ddbe1f17865597fbd27ec712455208b6b76d",
+	"value": "0x0",
+	"nonce": "0x16",
+	"gas": "0x186a0",
+	"gasPrice": "0x3b9aca0e",
+	"maxFeePerGas": "0x77359400",
+	"maxPriorityFeePerGas": "0xe",
+	"input": "0x",
+	"accessList": [],
+	"authorizationList": [
+		{
+			"chainId": "0x0",
+			"address": "0xf02c1c8e6114b1dbe8937a39260b5b0a374432bb",
+			"nonce": "0x17",
+			"yParity": "0x0",
+			"r": "0x1",
+			"s": "0x2b3c1d8e9f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c"
+		}
+	],
+	"chainId": "0x1",
+	"v": "0x0",
+	"r": "0x7a7b5d2b8b17a1ee9fd7d53f8a1e3bc1f58b3e7a64bdb0b94e97d0ab3c8e5f21",
+	"s": "0x2b3c1d8e9f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c",
+	"yParity": "0x0"
+}`
+
+func mustTxn(t *testing.T, s string) ethclient.Transaction {
+	t.Helper()
+	var tx ethclient.Transaction
+	if err := json.Unmarshal([]byte(s), &tx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return tx
+}
+
+// Compares transactions by their JSON encoding
+func sameTxn(t *testing.T, got, want indexedTx) bool {
+	t.Helper()
+	a, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(a) == string(b)
+}
+
+func TestTxnRecordRoundTrip(t *testing.T) {
+	full := mustTxn(t, nodeTxn)
+
+	tests := []struct {
+		name string
+		tx   indexedTx
+	}{
+		{"legacy", indexedTx{Transaction: full}},
+		{"blob", indexedTx{Transaction: mustTxn(t, blobTxn), BaseFeePerGas: ethclient.IntQuantity(7)}},
+		{"set code", indexedTx{Transaction: mustTxn(t, setCodeTxn), BaseFeePerGas: ethclient.IntQuantity(0)}},
+		{
+			// the zero values of fields that are left out
+			name: "zero values",
+			tx: indexedTx{Transaction: ethclient.Transaction{
+				Hash:        ethclient.Hash{0x01},
+				BlockNumber: ethclient.IntQuantity(0),
+				Value:       ethclient.IntQuantity(0),
+			}},
+		},
+		{
+			name: "contract creation",
+			tx:   indexedTx{Transaction: ethclient.Transaction{Hash: ethclient.Hash{0x01}, From: full.From, Input: ethclient.Data{0x60, 0x80}}},
+		},
+		{
+			name: "zero address recipient",
+			tx:   indexedTx{Transaction: ethclient.Transaction{Hash: ethclient.Hash{0x01}, From: full.From, To: &ethclient.Address{}}},
+		},
+		{
+			// told apart from lists the node left out
+			name: "empty lists",
+			tx: indexedTx{Transaction: ethclient.Transaction{
+				Hash:                ethclient.Hash{0x01},
+				AccessList:          []ethclient.AccessListEntry{},
+				BlobVersionedHashes: []ethclient.Hash{},
+				AuthorizationList:   []ethclient.Authorization{},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := encodeTxn(tt.tx)
+			if b[0] != txRecordVersion {
+				t.Fatalf("expected version header %d, got %d", txRecordVersion, b[0])
+			}
+
+			var tx indexedTx
+			if err := decodeTxn(b, &tx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !sameTxn(t, tx, tt.tx) {
+				t.Fatalf("expected %+v, got %+v", tt.tx, tx)
+			}
+			if (tx.To == nil) != (tt.tx.To == nil) {
+				t.Fatalf("expected recipient %v, got %v", tt.tx.To, tx.To)
+			}
+		})
+	}
+}
+
+func TestTxnRecordSize(t *testing.T) {
+	for _, s := range []string{nodeTxn, blobTxn} {
+		tx := mustTxn(t, s)
+		legacy, err := json.Marshal(tx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if b := encodeTxn(indexedTx{Transaction: tx}); 2*len(b) > len(legacy) {
+			t.Errorf("expected binary record of %d bytes to be under half the JSON size of %d", len(b), len(legacy))
+		}
+	}
+}
+
+func TestDecodeLegacyTxn(t *testing.T) {
+	want := indexedTx{Transaction: mustTxn(t, nodeTxn)}
+
+	tests := []struct {
+		name   string
+		record string
+	}{
+		{"node encoding", nodeTxn},
+		{
+			// fields were stored as the node returned them
+			name: "leading zeros and mixed case",
+			record: `{"hash":"0x88DF016429689C079F3B2F6AD39FA052532C56795B733DA78A91EBE6A713944B",` +
+				`"blockHash":"0x1d59ff54b1eb26b013ce3cb5fc9dab3705b415a67127a003c3e61eb445bb8df2","blockNumber":"0x005daf3b",` +
+				`"transactionIndex":"0x41","from":"0xA7d9ddBE1f17865597fBD27EC712455208B6B76d",` +
+				`"to":"0xf02c1c8e6114b1dbe8937a39260b5b0a374432bb","value":"0xf3dbb76162000","nonce":"0x15",` +
+				`"gas":"0xc350","gasPrice":"0x4a817c800","input":"0x68656c6c6f21","chainId":"0x1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tx indexedTx
+			if err := decodeTxn([]byte(tt.record), &tx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !sameTxn(t, tx, want) {
+				t.Fatalf("expected %+v, got %+v", want, tx)
+			}
+		})
+	}
+
+	// a contract creation stored before the recipient was optional
+	var tx indexedTx
+	if err := decodeTxn([]byte(`{"hash":"0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b","to":"","chainId":""}`), &tx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tx.To != nil || tx.ChainID.IsSet() {
+		t.Fatalf("expected empty fields to be unset, got %+v", tx)
+	}
+}
+
+func TestDecodeStringField(t *testing.T) {
+	// records written while fields were strings kept non-canonical values verbatim
+	b := []byte{txRecordVersion, 3, kindString, 4, '0', 'x', '0', '1'}
+
+	var tx indexedTx
+	if err := decodeTxn(b, &tx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, err := tx.BlockNumber.Int(); err != nil || n != 1 {
+		t.Fatalf("expected block number 1, got %s", tx.BlockNumber)
+	}
+}
+
+func TestDecodeCorruptTxnRecord(t *testing.T) {
+	b := encodeTxn(indexedTx{Transaction: ethclient.Transaction{Hash: ethclient.Hash{0x01}}})
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"empty", nil},
+		{"unknown version", append([]byte{txRecordVersion + 1}, b[1:]...)},
+		{"truncated", b[:len(b)-1]},
+		{"unknown kind", []byte{txRecordVersion, 1, 9, 0}},
+		{"short hash", []byte{txRecordVersion, 1, kindBytes, 1, 0x01}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tx indexedTx
+			if err := decodeTxn(tt.input, &tx); err == nil {
+				t.Fatal("expected error for corrupt record")
+			}
+		})
+	}
+}
+
+func TestDecodeSkipsUnknownFields(t *testing.T) {
+	b := encodeTxn(indexedTx{Transaction: ethclient.Transaction{Hash: ethclient.Hash{0x01}}})
+	// a field added by a newer version
+	b = append(b, 99, kindBytes, 3, 'n', 'e', 'w')
+
+	var tx indexedTx
+	if err := decodeTxn(b, &tx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tx.Hash != (ethclient.Hash{0x01}) {
+		t.Fatalf("expected hash %s, got %s", ethclient.Hash{0x01}, tx.Hash)
+	}
+}
+
+func TestMigrateTxEncoding(t *testing.T) {
+	const address = "0x00000000000000000000000000000000000a11ce"
+
+	db := memorydb.New()
+	from, _ := ethclient.ParseAddress(address)
+	legacy := indexedTx{Transaction: ethclient.Transaction{Hash: ethclient.Hash{0x01}, BlockNumber: ethclient.IntQuantity(1), From: from}}
+	legacyBytes, err := json.Marshal(legacy.Transaction)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.Put(txKey(address), [][]byte{legacyBytes, encodeTxn(indexedTx{Transaction: ethclient.Transaction{Hash: ethclient.Hash{0x02}, BlockNumber: ethclient.IntQuantity(2)}})})
+
+	if err := migrateTxEncoding(db); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	values, err := db.Get(txKey(address))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, v := range values {
+		if v[0] != txRecordVersion {
+			t.Errorf("expected record %d to be migrated, got %q", i, v)
+		}
+	}
+	txs, err := deserializeTxn(values)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(txs) != 2 || !sameTxn(t, txs[0], legacy) || txs[1].Hash != (ethclient.Hash{0x02}) {
+		t.Fatalf("expected history to be kept in order, got %+v", txs)
 	}
 }
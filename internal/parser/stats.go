@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats reports the scanner's progress
+type Stats struct {
+	LastBlock int `json:"last_block"`
+	HeadBlock int `json:"head_block"`
+	// number of blocks the scanner is behind the chain head it follows
+	Lag int `json:"lag"`
+	// blocks processed per second during the current or most recent scan
+	BlocksPerSecond float64 `json:"blocks_per_second"`
+}
+
+type scanStats struct {
+	mutex     sync.Mutex
+	stats     Stats
+	startedAt time.Time
+	processed int
+}
+
+// Marks the start of a scan towards the given head block
+func (s *scanStats) start(lastBlock, headBlock int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.startedAt = time.Now()
+	s.processed = 0
+	s.stats.LastBlock = lastBlock
+	s.stats.HeadBlock = headBlock
+	s.stats.Lag = max(headBlock-lastBlock, 0)
+}
+
+// Records a processed block
+func (s *scanStats) processedBlock(blockNumber int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.processed++
+	s.stats.LastBlock = blockNumber
+	s.stats.Lag = max(s.stats.HeadBlock-blockNumber, 0)
+	if elapsed := time.Since(s.startedAt).Seconds(); elapsed > 0 {
+		s.stats.BlocksPerSecond = float64(s.processed) / elapsed
+	}
+}
+
+func (s *scanStats) get() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stats
+}
@@ -0,0 +1,356 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Subscription is a subscribed address and its metadata
+type Subscription struct {
+	Address   string    `json:"address"`
+	CreatedAt time.Time `json:"createdAt"`
+	// first block scanned for the address, 0 while the scanner has not picked its first block
+	StartBlock int      `json:"startBlock"`
+	Labels     []string `json:"labels"`
+	// URL newly matched transactions are POSTed to, if any
+	Webhook string `json:"webhook,omitempty"`
+}
+
+// SubscribeOption sets optional metadata of a new subscription
+type SubscribeOption func(*Subscription)
+
+// Labels to attach to the subscription, e.g. to tell addresses apart
+func WithLabels(labels ...string) SubscribeOption {
+	return func(s *Subscription) {
+		s.Labels = append(s.Labels, labels...)
+	}
+}
+
+// URL to POST each newly matched transaction of the address to. Deliveries go through
+// an outbox in the datastore and are retried until they succeed or run out of attempts.
+// A URL that is not http or https, or that targets a private network, is rejected, see
+// ValidateWebhook.
+func WithWebhook(url string) SubscribeOption {
+	return func(s *Subscription) {
+		s.Webhook = url
+	}
+}
+
+// Block to index the address's history from. Blocks the scanner has already passed are
+// indexed by a backfill job in the background, which runs while new blocks are scanned.
+// A block after the next one the scanner will index is rejected.
+func WithFromBlock(blockNumber int) SubscribeOption {
+	return func(s *Subscription) {
+		s.StartBlock = max(blockNumber, 0)
+	}
+}
+
+var (
+	errAlreadySubscribed = errors.New("already subscribed")
+	errFromBlockAhead    = errors.New("from block is ahead of the scanner")
+)
+
+// Subscribes to the address. Subscribing is a no-op for an address that is already
+// subscribed, keeping its history and metadata; false is returned in that case, for
+// invalid addresses and for rejected webhooks. The address is stored in lowercase.
+// With WithFromBlock, a backfill job is queued for the blocks already scanned. Before the
+// scanner has indexed a block, the job waits for it to pick its first block.
+func (p *Parser) Subscribe(address string, opts ...SubscribeOption) bool {
+	address, err := ethclient.NormalizeAddress(address)
+	if err != nil {
+		p.logger.Printf("failed to subscribe: %v", err)
+		return false
+	}
+
+	subscription := Subscription{
+		Address:   address,
+		CreatedAt: time.Now().UTC(),
+		Labels:    []string{},
+	}
+
+	err = p.db.Batch(func(batch datastore.Batch) error {
+		if batch.Has(subKey(address)) {
+			return errAlreadySubscribed
+		}
+
+		// The checkpoint is written together with each block, so unlike the scanner's
+		// position it covers a block committed just before this batch
+		lastBlockNumber := p.GetCurrentBlock()
+		checkpoint, ok, err := loadCheckpoint(batch)
+		if err != nil {
+			return err
+		}
+		if ok {
+			lastBlockNumber = checkpoint.Number
+		}
+		// unset unless WithFromBlock is given
+		subscription.StartBlock = -1
+		for _, opt := range opts {
+			opt(&subscription)
+		}
+		if subscription.Webhook != "" {
+			if err := p.ValidateWebhook(subscription.Webhook); err != nil {
+				return err
+			}
+		}
+		fromBlock := subscription.StartBlock >= 0
+
+		switch {
+		case lastBlockNumber == 0:
+			// the scanner starts at the head once it runs, which sets the start block
+			// and ends the range of a backfill job
+			if !fromBlock {
+				subscription.StartBlock = 0
+				break
+			}
+			job := newBackfillJob(address, subscription.StartBlock, 0)
+			job.Status = BackfillPending
+			if err := putBackfillJob(batch, job); err != nil {
+				return err
+			}
+		case !fromBlock:
+			subscription.StartBlock = lastBlockNumber + 1
+		case subscription.StartBlock > lastBlockNumber+1:
+			// the blocks in between would be indexed anyway
+			return fmt.Errorf("%w: block %d, scanner at block %d", errFromBlockAhead, subscription.StartBlock, lastBlockNumber)
+		case subscription.StartBlock <= lastBlockNumber:
+			// blocks before the scanner's position are indexed by a backfill job
+			job := newBackfillJob(address, subscription.StartBlock, lastBlockNumber)
+			if err := putBackfillJob(batch, job); err != nil {
+				return err
+			}
+		}
+		// a block being indexed has to be indexed again to include the address
+		p.subscriptionsVersion.Add(1)
+		return putSubscription(batch, subscription)
+	})
+	if errors.Is(err, errAlreadySubscribed) {
+		return false
+	}
+	if err != nil {
+		p.logger.Printf("failed to subscribe to address %s: %v", address, err)
+		return false
+	}
+	p.wakeBackfills()
+	return true
+}
+
+// Removes the subscription and everything indexed for the address, including its pending
+// and dead-lettered webhook deliveries and the receipts of transactions no other subscribed
+// address was matched to. Returns false if the address is not subscribed.
+func (p *Parser) Unsubscribe(address string) bool {
+	address, err := ethclient.NormalizeAddress(address)
+	if err != nil {
+		p.logger.Printf("failed to unsubscribe: %v", err)
+		return false
+	}
+	if !p.db.Has(subKey(address)) {
+		return false
+	}
+	outboxIDs, err := listKeys(p.db, outboxKeyPrefix)
+	if err != nil {
+		p.logger.Printf("failed to unsubscribe from address %s: %v", address, err)
+		return false
+	}
+	deadLetterIDs, err := listKeys(p.db, deadLetterKeyPrefix)
+	if err != nil {
+		p.logger.Printf("failed to unsubscribe from address %s: %v", address, err)
+		return false
+	}
+
+	err = p.db.Batch(func(batch datastore.Batch) error {
+		// read before the transaction history is deleted
+		if err := removeReceipts(batch, address); err != nil {
+			return err
+		}
+		ofAddress := func(d WebhookDelivery) bool {
+			return strings.EqualFold(d.Address, address)
+		}
+		if err := removeDeliveries(batch, outboxKeyPrefix, outboxIDs, ofAddress); err != nil {
+			return err
+		}
+		if err := removeDeliveries(batch, deadLetterKeyPrefix, deadLetterIDs, ofAddress); err != nil {
+			return err
+		}
+
+		for _, key := range []string{
+			subKey(address),
+			txKey(address),
+			tokenKey(address),
+			nftKey(address),
+			internalKey(address),
+			withdrawalKey(address),
+			backfillKey(address),
+		} {
+			if err := batch.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		p.logger.Printf("failed to unsubscribe from address %s: %v", address, err)
+		return false
+	}
+	return true
+}
+
+// Returns the subscription of the address, or false if it is not subscribed.
+// The address is returned in its EIP-55 checksum encoding.
+func (p *Parser) GetSubscription(address string) (Subscription, bool) {
+	address, err := ethclient.NormalizeAddress(address)
+	if err != nil {
+		p.logger.Printf("failed to get subscription: %v", err)
+		return Subscription{}, false
+	}
+	subscription, err := getSubscription(p.db, address)
+	if err != nil {
+		if !errors.Is(err, datastore.KeyDoesNotExist) {
+			p.logger.Printf("failed to get subscription for address %s: %v", address, err)
+		}
+		return Subscription{}, false
+	}
+	subscription.Address = ethclient.ChecksumAddress(subscription.Address)
+	return subscription, true
+}
+
+// Returns all subscriptions ordered by address, in their EIP-55 checksum encoding
+func (p *Parser) ListSubscriptions() ([]Subscription, error) {
+	addresses, err := listKeys(p.db, subKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(addresses)
+
+	subscriptions := make([]Subscription, 0, len(addresses))
+	for _, address := range addresses {
+		subscription, err := getSubscription(p.db, address)
+		if errors.Is(err, datastore.KeyDoesNotExist) {
+			// unsubscribed in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		subscription.Address = ethclient.ChecksumAddress(subscription.Address)
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+func (p *Parser) GetSubscriptions() ([]string, error) {
+	addresses, err := listKeys(p.db, subKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+func getSubscription(db interface {
+	Get(key string) ([][]byte, error)
+}, address string) (Subscription, error) {
+	v, err := db.Get(subKey(address))
+	if err != nil {
+		return Subscription{}, err
+	}
+	return decodeSubscription(v)
+}
+
+func decodeSubscription(v [][]byte) (Subscription, error) {
+	if len(v) == 0 {
+		return Subscription{}, errors.New("empty subscription record")
+	}
+
+	var subscription Subscription
+	if err := json.Unmarshal(v[0], &subscription); err != nil {
+		return Subscription{}, err
+	}
+	return subscription, nil
+}
+
+func putSubscription(batch datastore.Batch, subscription Subscription) error {
+	b, err := json.Marshal(subscription)
+	if err != nil {
+		return err
+	}
+	return batch.Put(subKey(subscription.Address), [][]byte{b})
+}
+
+// Sets the start block of the given subscriptions, made before the scanner indexed a block,
+// to the first block it indexes, and ends the range of their pending backfill jobs before it.
+// A job that is left with no blocks to backfill is dropped. Returns whether a job was queued.
+func startSubscriptions(batch datastore.Batch, addresses []string, firstBlock int) (bool, error) {
+	queued := false
+	for _, address := range addresses {
+		subscription, err := getSubscription(batch, address)
+		if errors.Is(err, datastore.KeyDoesNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		job, err := getBackfillJob(batch, address)
+		if errors.Is(err, datastore.KeyDoesNotExist) || (err == nil && job.Status != BackfillPending) {
+			if subscription.StartBlock == 0 {
+				subscription.StartBlock = firstBlock
+				if err := putSubscription(batch, subscription); err != nil {
+					return false, err
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if job.FromBlock >= firstBlock {
+			// the scanner indexes the blocks from its first one anyway
+			subscription.StartBlock = firstBlock
+			if err := putSubscription(batch, subscription); err != nil {
+				return false, err
+			}
+			if err := batch.Delete(backfillKey(address)); err != nil {
+				return false, err
+			}
+			continue
+		}
+		job.ToBlock = firstBlock - 1
+		job.Status = BackfillQueued
+		job.NextAttemptAt = time.Now().UTC()
+		job.UpdatedAt = job.NextAttemptAt
+		if err := putBackfillJob(batch, job); err != nil {
+			return false, err
+		}
+		queued = true
+	}
+	return queued, nil
+}
+
+// Creates subscription records for addresses subscribed before subscriptions had metadata,
+// when a transaction history key was all that marked an address as subscribed
+func migrateSubscriptions(db datastore.DataStore) error {
+	addresses, err := listKeys(db, txKeyPrefix)
+	if err != nil {
+		return err
+	}
+
+	return db.Batch(func(batch datastore.Batch) error {
+		for _, address := range addresses {
+			if batch.Has(subKey(address)) {
+				continue
+			}
+			if err := putSubscription(batch, Subscription{Address: address, Labels: []string{}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,349 @@
+package parser_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore/memorydb"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+func TestSubscriptionMetadata(t *testing.T) {
+	const bob = "0x0000000000000000000000000000000000000b0b"
+
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+
+	if !p.Subscribe(bob, parser.WithLabels("treasury", "cold")) {
+		t.Fatal("expected subscription to succeed")
+	}
+	p.Subscribe(alice)
+
+	subscription, ok := p.GetSubscription(bob)
+	if !ok {
+		t.Fatal("expected subscription to exist")
+	}
+	if subscription.Address != ethclient.ChecksumAddress(bob) || subscription.StartBlock != 11 || subscription.CreatedAt.IsZero() {
+		t.Errorf("unexpected subscription: %+v", subscription)
+	}
+	if !slices.Equal(subscription.Labels, []string{"treasury", "cold"}) {
+		t.Errorf("expected labels to be kept, got %v", subscription.Labels)
+	}
+
+	if _, ok := p.GetSubscription(carol); ok {
+		t.Error("expected no subscription for unknown address")
+	}
+
+	subscriptions, err := p.ListSubscriptions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(subscriptions) != 2 ||
+		subscriptions[0].Address != ethclient.ChecksumAddress(bob) ||
+		subscriptions[1].Address != ethclient.ChecksumAddress(alice) {
+		t.Fatalf("expected subscriptions ordered by address, got %+v", subscriptions)
+	}
+}
+
+func TestSubscribeNormalizesAddress(t *testing.T) {
+	const (
+		checksummed = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+		lowercase   = "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"
+	)
+
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+
+	for _, invalid := range []string{"", "0xalice", "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"} {
+		if p.Subscribe(invalid) {
+			t.Errorf("expected subscribing to invalid address %q to fail", invalid)
+		}
+	}
+
+	if !p.Subscribe(checksummed) {
+		t.Fatal("expected subscription to succeed")
+	}
+	if p.Subscribe(lowercase) {
+		t.Error("expected the lowercase address to already be subscribed")
+	}
+
+	// nodes return lowercase addresses
+	node.mine(newTx(1, carol, lowercase, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, address := range []string{checksummed, lowercase, "0x" + strings.ToUpper(lowercase[2:])} {
+		if txs := p.GetTransactions(address); len(txs) != 1 {
+			t.Errorf("expected 1 transaction for %s, got %d", address, len(txs))
+		}
+	}
+
+	subscriptions, err := p.GetSubscriptions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(subscriptions, []string{lowercase}) {
+		t.Errorf("expected subscription to be stored in lowercase, got %v", subscriptions)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	const (
+		alice = "0x00000000000000000000000000000000000a11ce"
+		bob   = "0x0000000000000000000000000000000000000b0b"
+	)
+
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+	p.Subscribe(alice)
+	p.Subscribe(bob)
+
+	node.mine(newTx(1, alice, bob, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !p.Unsubscribe(alice) {
+		t.Fatal("expected unsubscribe to succeed")
+	}
+	if p.Unsubscribe(alice) {
+		t.Error("expected unsubscribing twice to report the address as not subscribed")
+	}
+	if _, ok := p.GetSubscription(alice); ok {
+		t.Error("expected subscription to be removed")
+	}
+	if txs := p.GetTransactions(alice); len(txs) != 0 {
+		t.Errorf("expected history to be removed, got %d transactions", len(txs))
+	}
+
+	// bob keeps his history and still gets new transactions
+	node.mine(newTx(2, bob, "0x00000000000000000000000000000000000ca201", 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if txs := p.GetTransactions(bob); len(txs) != 2 {
+		t.Errorf("expected 2 transactions for bob, got %d", len(txs))
+	}
+	if txs := p.GetTransactions(alice); len(txs) != 0 {
+		t.Errorf("expected no transactions after unsubscribing, got %d", len(txs))
+	}
+}
+
+func TestUnsubscribeRemovesDeliveriesAndReceipts(t *testing.T) {
+	const bob = "0x0000000000000000000000000000000000000b0b"
+
+	receiver := newWebhookReceiver(t)
+	receiver.setStatus(http.StatusInternalServerError)
+	node := newFakeNode(t, 10)
+	db := memorydb.New()
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10,
+		parser.WithDataStore(db),
+		parser.WithReceipts(true),
+		parser.WithPrivateWebhooks(true),
+		parser.WithWebhookRetryPolicy(parser.WebhookRetryPolicy{MaxAttempts: 1}),
+	)
+	p.Subscribe(alice, parser.WithWebhook(receiver.server.URL))
+	p.Subscribe(bob, parser.WithWebhook(receiver.server.URL))
+
+	// the deliveries of both transactions are dead-lettered, those of the third stay pending
+	node.mine(newTx(1, alice, bob, 0), newTx(2, carol, alice, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.DeliverWebhooks(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	node.mine(newTx(3, carol, alice, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !p.Unsubscribe(alice) {
+		t.Fatal("expected unsubscribe to succeed")
+	}
+
+	deadLetters, err := p.ListDeadLetters()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deadLetters) != 1 || deadLetters[0].Address != ethclient.ChecksumAddress(bob) {
+		t.Errorf("expected only bob's dead letter to be kept, got %+v", deadLetters)
+	}
+	keys, err := db.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, key := range keys {
+		if strings.HasPrefix(key, "webhook/outbox/") {
+			t.Errorf("expected alice's pending deliveries to be removed, got %s", key)
+		}
+	}
+	for n, want := range map[int]bool{1: true, 2: false, 3: false} {
+		if got := slices.Contains(keys, "receipt/"+testHash(n).Hex()); got != want {
+			t.Errorf("expected receipt of transaction %d to be kept: %v, got %v", n, want, got)
+		}
+	}
+	if txs := p.GetTransactions(bob); len(txs) != 1 || txs[0].Receipt == nil {
+		t.Errorf("expected bob's transaction to keep its receipt, got %+v", txs)
+	}
+}
+
+func TestMigrateSubscriptions(t *testing.T) {
+	node := newFakeNode(t, 10)
+
+	// older versions marked subscriptions by the transaction history key alone
+	db := memorydb.New()
+	db.Put("tx/"+alice, [][]byte{})
+
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithDataStore(db))
+	if _, ok := p.GetSubscription(alice); !ok {
+		t.Fatal("expected existing subscription to be migrated")
+	}
+}
+
+func TestMigrateMixedCaseAddresses(t *testing.T) {
+	const checksummed = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+
+	node := newFakeNode(t, 10)
+
+	// older versions stored addresses as given
+	db := memorydb.New()
+	db.Put("sub/"+checksummed, [][]byte{[]byte(`{"address":"` + checksummed + `","labels":["hot"]}`)})
+	db.Put("tx/"+checksummed, [][]byte{[]byte(`{"hash":"` + testHash(1).Hex() + `","blockNumber":"0x1"}`)})
+
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithDataStore(db))
+	subscription, ok := p.GetSubscription(strings.ToLower(checksummed))
+	if !ok || subscription.Address != checksummed || !slices.Equal(subscription.Labels, []string{"hot"}) {
+		t.Fatalf("expected subscription to be migrated, got %+v", subscription)
+	}
+	if txs := p.GetTransactions(checksummed); len(txs) != 1 {
+		t.Errorf("expected history to be migrated, got %d transactions", len(txs))
+	}
+	if db.Has("sub/" + checksummed) {
+		t.Error("expected mixed-case key to be removed")
+	}
+}
+
+func TestResubscribeKeepsHistory(t *testing.T) {
+	const (
+		alice = "0x00000000000000000000000000000000000a11ce"
+		bob   = "0x0000000000000000000000000000000000000b0b"
+	)
+
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+	p.Subscribe(alice, parser.WithLabels("hot"))
+	p.Subscribe(bob)
+
+	node.mine(newTx(1, alice, bob, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before, _ := p.GetSubscription(alice)
+
+	if p.Subscribe(alice, parser.WithLabels("cold")) {
+		t.Error("expected subscribing again to report the address as already subscribed")
+	}
+
+	if txs := p.GetTransactions(alice); len(txs) != 1 {
+		t.Fatalf("expected history to be kept, got %d transactions", len(txs))
+	}
+	after, _ := p.GetSubscription(alice)
+	if !after.CreatedAt.Equal(before.CreatedAt) || !slices.Equal(after.Labels, []string{"hot"}) {
+		t.Errorf("expected metadata to be kept, got %+v", after)
+	}
+}
+
+func TestSubscriptionWithoutHistory(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+	p.Subscribe("0x00000000000000000000000000000000000a11ce")
+
+	// transactions with unsubscribed counterparties are only stored for the subscriber
+	node.mine(newTx(1, "0x00000000000000000000000000000000000a11ce", "0x0000000000000000000000000000000000000b0b", 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if txs := p.GetTransactions("0x00000000000000000000000000000000000a11ce"); len(txs) != 1 {
+		t.Errorf("expected 1 transaction, got %d", len(txs))
+	}
+	if txs := p.GetTransactions("0x0000000000000000000000000000000000000b0b"); len(txs) != 0 {
+		t.Errorf("expected no transactions for unsubscribed address, got %d", len(txs))
+	}
+	if subscriptions, _ := p.GetSubscriptions(); len(subscriptions) != 1 {
+		t.Errorf("expected history not to create subscriptions, got %v", subscriptions)
+	}
+}
+
+func TestSubscribeWhileIndexingBlock(t *testing.T) {
+	const bob = "0x0000000000000000000000000000000000000b0b"
+
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithTokenTransfers(true))
+	p.Subscribe(alice)
+
+	// bob subscribes after block 11 was searched for subscribed transactions, while
+	// its logs are fetched
+	var once sync.Once
+	node.onCall = func(method string) {
+		if method == ethclient.GetLogsMethod {
+			once.Do(func() { p.Subscribe(bob) })
+		}
+	}
+	node.mine(newTx(1, carol, bob, 0), newTx(2, alice, carol, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if txs := p.GetTransactions(bob); len(txs) != 1 || txs[0].Hash != testHash(1) {
+		t.Fatalf("expected the block being indexed to include bob's transaction, got %+v", txs)
+	}
+	if txs := p.GetTransactions(alice); len(txs) != 1 {
+		t.Fatalf("expected alice's transaction to be saved once, got %d", len(txs))
+	}
+}
+
+func TestInvalidAddress(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+	p.Subscribe(alice)
+
+	// a mixed-case address with a wrong checksum, and one that is too short
+	checksum := ethclient.ChecksumAddress(alice)
+	badChecksum := checksum[:len(checksum)-1] + strings.ToLower(checksum[len(checksum)-1:])
+	for _, address := range []string{"0x123", badChecksum} {
+		if txs := p.GetTransactions(address); txs != nil {
+			t.Errorf("expected no transactions for %s, got %+v", address, txs)
+		}
+		if transfers := p.GetTokenTransfers(address); transfers != nil {
+			t.Errorf("expected no token transfers for %s, got %+v", address, transfers)
+		}
+		if _, ok := p.GetSubscription(address); ok {
+			t.Errorf("expected no subscription for %s", address)
+		}
+		if _, err := p.GetTransactionsPage(address, parser.TransactionQuery{}); !errors.Is(err, ethclient.ErrInvalidAddress) &&
+			!errors.Is(err, ethclient.ErrInvalidChecksum) {
+			t.Errorf("expected an invalid address error for %s, got %v", address, err)
+		}
+		if p.Unsubscribe(address) {
+			t.Errorf("expected unsubscribing %s to fail", address)
+		}
+	}
+
+	// the checksum encoding of a subscribed address is the same address
+	if _, ok := p.GetSubscription(ethclient.ChecksumAddress(alice)); !ok {
+		t.Fatal("expected the subscription to be found by its checksum encoding")
+	}
+	if !p.Unsubscribe(ethclient.ChecksumAddress(alice)) {
+		t.Fatal("expected unsubscribing by the checksum encoding to succeed")
+	}
+}
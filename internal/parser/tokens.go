@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Topic of Transfer(address,address,uint256), emitted by ERC-20 and ERC-721 contracts.
+// ERC-20 transfers carry the amount in the log data, ERC-721 transfers index the token ID as a fourth topic.
+const TransferEventTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+// TokenTransfer is an ERC-20 transfer decoded from a Transfer event log
+type TokenTransfer struct {
+	// address of the token contract that emitted the event
+	Token string `json:"token"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	// hex quantity in the token's smallest unit
+	Amount          string `json:"amount"`
+	TransactionHash string `json:"transactionHash"`
+	BlockNumber     string `json:"blockNumber"`
+	BlockHash       string `json:"blockHash"`
+	LogIndex        string `json:"logIndex"`
+}
+
+// Decodes an ERC-20 Transfer log. Returns false for any other log.
+func decodeTokenTransfer(log ethclient.Log) (TokenTransfer, bool) {
+	if len(log.Topics) != 3 || log.Topics[0] != TransferEventTopic {
+		return TokenTransfer{}, false
+	}
+	from, ok := topicAddress(log.Topics[1])
+	if !ok {
+		return TokenTransfer{}, false
+	}
+	to, ok := topicAddress(log.Topics[2])
+	if !ok {
+		return TokenTransfer{}, false
+	}
+	amount, ok := wordQuantity(log.Data)
+	if !ok {
+		return TokenTransfer{}, false
+	}
+
+	return TokenTransfer{
+		Token:           strings.ToLower(log.Address),
+		From:            from,
+		To:              to,
+		Amount:          amount,
+		TransactionHash: log.TransactionHash,
+		BlockNumber:     log.BlockNumber,
+		BlockHash:       log.BlockHash,
+		LogIndex:        log.LogIndex,
+	}, true
+}
+
+// Returns the address held in an indexed topic, which pads it to 32 bytes
+func topicAddress(topic string) (string, bool) {
+	if len(topic) != 66 || !strings.HasPrefix(topic, "0x") || strings.Trim(topic[2:26], "0") != "" {
+		return "", false
+	}
+	return "0x" + strings.ToLower(topic[26:]), true
+}
+
+// Returns the indexed topic holding the address, the inverse of topicAddress
+func addressToTopic(address string) (string, bool) {
+	if len(address) != 42 || !strings.HasPrefix(address, "0x") {
+		return "", false
+	}
+	return "0x" + strings.Repeat("0", 24) + strings.ToLower(address[2:]), true
+}
+
+// Returns a single 32 byte ABI word, such as a uint256 in log data, as a hex quantity
+func wordQuantity(data string) (string, bool) {
+	if len(data) != 66 || !strings.HasPrefix(data, "0x") {
+		return "", false
+	}
+	n, ok := new(big.Int).SetString(data[2:], 16)
+	if !ok {
+		return "", false
+	}
+	return "0x" + n.Text(16), true
+}
+
+// Returns the ERC-20 transfers among the logs that involve a subscribed address
+func (b *Scanner) filterTokenTransfers(logs []ethclient.Log) []TokenTransfer {
+	transfers := make([]TokenTransfer, 0)
+	for _, log := range logs {
+		transfer, ok := decodeTokenTransfer(log)
+		if !ok {
+			continue
+		}
+		if b.isSubscribed(transfer.From) || b.isSubscribed(transfer.To) {
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers
+}
+
+// Saves token transfers under each subscribed counterparty
+func saveTokenTransfers(batch datastore.Batch, transfers []TokenTransfer) error {
+	return saveToCounterparties(batch, tokenKey, transfers, func(transfer TokenTransfer) (string, string) {
+		return transfer.From, transfer.To
+	})
+}
+
+func removeTokenTransfers(
+	batch datastore.Batch,
+	addresses []string,
+	remove func(transfer TokenTransfer) bool,
+) error {
+	return removeRecords(batch, tokenKey, addresses, remove)
+}
+
+// Returns the ERC-20 transfers to or from the address, with their addresses in their
+// EIP-55 checksum encoding
+func (p *Parser) GetTokenTransfers(address string) []TokenTransfer {
+	address, err := ethclient.NormalizeAddress(address)
+	if err != nil {
+		p.logger.Printf("failed to get token transfers: %v", err)
+		return nil
+	}
+
+	transfers, err := getRecords[TokenTransfer](p.db, tokenKey(address))
+	if err != nil {
+		p.logger.Printf("failed to get token transfers for address %s: %v", address, err)
+		return nil
+	}
+	for i := range transfers {
+		transfers[i].Token = ethclient.ChecksumAddress(transfers[i].Token)
+		transfers[i].From = ethclient.ChecksumAddress(transfers[i].From)
+		transfers[i].To = ethclient.ChecksumAddress(transfers[i].To)
+	}
+	return transfers
+}
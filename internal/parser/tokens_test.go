@@ -0,0 +1,115 @@
+package parser_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+const (
+	usdc  = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+	alice = "0x00000000000000000000000000000000000a11ce"
+	carol = "0x00000000000000000000000000000000000ca201"
+)
+
+// Pads an address to a 32 byte indexed topic
+func addressTopic(address string) string {
+	return "0x" + strings.Repeat("0", 24) + strings.TrimPrefix(address, "0x")
+}
+
+// Encodes a quantity as a 32 byte ABI word
+func word(n int) string {
+	return fmt.Sprintf("0x%064x", n)
+}
+
+func erc20Transfer(txHash, token, from, to string, amount int) ethclient.Log {
+	return ethclient.Log{
+		Address:         token,
+		Topics:          []string{parser.TransferEventTopic, addressTopic(from), addressTopic(to)},
+		Data:            word(amount),
+		TransactionHash: txHash,
+	}
+}
+
+func TestTokenTransfers(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithTokenTransfers(true))
+	p.Subscribe(alice)
+
+	node.emit(
+		erc20Transfer("0x01", usdc, carol, alice, 1_000_000),
+		// ERC-721 transfers share the signature but index the token ID
+		ethclient.Log{
+			Address:         "0xnft",
+			Topics:          []string{parser.TransferEventTopic, addressTopic(carol), addressTopic(alice), word(7)},
+			TransactionHash: "0x02",
+		},
+		erc20Transfer("0x03", usdc, carol, "0x00000000000000000000000000000000000000b0", 5),
+	)
+	node.mine()
+	node.emit(erc20Transfer("0x04", usdc, alice, carol, 250_000))
+	node.mine()
+
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	transfers := p.GetTokenTransfers(alice)
+	if len(transfers) != 2 {
+		t.Fatalf("expected 2 token transfers, got %d: %+v", len(transfers), transfers)
+	}
+	want := parser.TokenTransfer{
+		Token:           ethclient.ChecksumAddress(usdc),
+		From:            ethclient.ChecksumAddress(carol),
+		To:              ethclient.ChecksumAddress(alice),
+		Amount:          "0xf4240",
+		TransactionHash: "0x01",
+		BlockNumber:     "0xb",
+		LogIndex:        "0x0",
+	}
+	got := transfers[0]
+	got.BlockHash = ""
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if transfers[1].TransactionHash != "0x04" || transfers[1].From != ethclient.ChecksumAddress(alice) {
+		t.Errorf("expected outgoing transfer 0x04, got %+v", transfers[1])
+	}
+
+	// carol is not subscribed
+	if transfers := p.GetTokenTransfers(carol); len(transfers) != 0 {
+		t.Errorf("expected no token transfers for unsubscribed address, got %d", len(transfers))
+	}
+}
+
+func TestTokenTransfersReorg(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithTokenTransfers(true))
+	p.Subscribe(alice)
+
+	node.emit(erc20Transfer("0x01", usdc, carol, alice, 1))
+	node.mine()
+	node.emit(erc20Transfer("0x02", usdc, carol, alice, 2))
+	node.mine()
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	node.fork(11)
+	node.mine()
+	node.mine()
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	transfers := p.GetTokenTransfers(alice)
+	if len(transfers) != 1 || transfers[0].TransactionHash != "0x01" {
+		t.Fatalf("expected only the transfer before the fork, got %+v", transfers)
+	}
+}
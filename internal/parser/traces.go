@@ -0,0 +1,230 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// InternalTransaction is a value transfer made by a contract call within a transaction,
+// such as a multisig payout or a DEX withdrawal
+type InternalTransaction struct {
+	TransactionHash string `json:"transactionHash"`
+	BlockNumber     string `json:"blockNumber"`
+	BlockHash       string `json:"blockHash"`
+	From            string `json:"from"`
+	To              string `json:"to"`
+	Value           string `json:"value"`
+	// call, callcode, create, create2 or selfdestruct
+	CallType string `json:"callType"`
+	// 1 for calls made by the transaction's top-level call, 2 for calls they make and so on
+	Depth int `json:"depth"`
+	// position of the call in the call tree, one index per level
+	TraceAddress []int `json:"traceAddress"`
+}
+
+// Reports whether a call of the given type moved value. Delegate and static calls
+// cannot transfer value, even though tracers may report the caller's value for them.
+func movesValue(callType, value string) bool {
+	switch callType {
+	case "delegatecall", "staticcall":
+		return false
+	}
+	return value != "" && strings.TrimLeft(strings.TrimPrefix(value, "0x"), "0") != ""
+}
+
+// Returns the internal value transfers in the call trees of the block's transactions.
+// Calls that reverted, and the calls they made, are skipped as their transfers were undone.
+func flattenCallTraces(block ethclient.Block, traces []ethclient.TransactionTrace) ([]InternalTransaction, error) {
+	if len(traces) != len(block.Transactions) {
+		return nil, fmt.Errorf("traced %d transactions, block %s has %d", len(traces), block.Hash, len(block.Transactions))
+	}
+
+	result := make([]InternalTransaction, 0)
+	for i, trace := range traces {
+		txHash := block.Transactions[i].Hash.Hex()
+		if trace.TxHash != "" && trace.TxHash != txHash {
+			return nil, fmt.Errorf("traced transaction %s, block %s has %s", trace.TxHash, block.Hash, txHash)
+		}
+		if trace.Result.Error != "" {
+			continue
+		}
+
+		var walk func(frame ethclient.CallFrame, path []int)
+		walk = func(frame ethclient.CallFrame, path []int) {
+			for j, call := range frame.Calls {
+				if call.Error != "" {
+					continue
+				}
+				callPath := append(slices.Clone(path), j)
+				callType := strings.ToLower(call.Type)
+				if movesValue(callType, call.Value) {
+					result = append(result, InternalTransaction{
+						TransactionHash: txHash,
+						BlockNumber:     block.Number.String(),
+						BlockHash:       block.Hash.Hex(),
+						From:            strings.ToLower(call.From),
+						To:              strings.ToLower(call.To),
+						Value:           call.Value,
+						CallType:        callType,
+						Depth:           len(callPath),
+						TraceAddress:    callPath,
+					})
+				}
+				walk(call, callPath)
+			}
+		}
+		walk(trace.Result, []int{})
+	}
+	return result, nil
+}
+
+// Returns the internal value transfers in the flat traces of the block's transactions.
+// Traces that reverted, and the traces below them, are skipped as their transfers were undone.
+func flattenTraces(block ethclient.Block, traces []ethclient.Trace) ([]InternalTransaction, error) {
+	result := make([]InternalTransaction, 0)
+	// trace addresses of reverted traces by transaction
+	reverted := make(map[string][][]int)
+	for _, trace := range traces {
+		if trace.BlockHash != "" && trace.BlockHash != block.Hash.Hex() {
+			return nil, fmt.Errorf("traced block %s, expected %s", trace.BlockHash, block.Hash)
+		}
+		if trace.Error != "" {
+			reverted[trace.TransactionHash] = append(reverted[trace.TransactionHash], trace.TraceAddress)
+		}
+		if len(trace.TraceAddress) == 0 || trace.TransactionHash == "" {
+			// the top-level call is the transaction itself, block rewards belong to no transaction
+			continue
+		}
+		if slices.ContainsFunc(reverted[trace.TransactionHash], func(prefix []int) bool {
+			return len(prefix) <= len(trace.TraceAddress) && slices.Equal(prefix, trace.TraceAddress[:len(prefix)])
+		}) {
+			continue
+		}
+
+		tx, ok := internalTxFromTrace(trace, block.Number.String(), block.Hash.Hex())
+		if !ok {
+			continue
+		}
+		result = append(result, tx)
+	}
+	return result, nil
+}
+
+// Converts a trace below the top-level call into an internal transaction.
+// Returns false if the trace did not move value.
+func internalTxFromTrace(trace ethclient.Trace, blockNumber, blockHash string) (InternalTransaction, bool) {
+	tx := InternalTransaction{
+		TransactionHash: trace.TransactionHash,
+		BlockNumber:     blockNumber,
+		BlockHash:       blockHash,
+		Depth:           len(trace.TraceAddress),
+		TraceAddress:    trace.TraceAddress,
+	}
+	switch trace.Type {
+	case "call":
+		tx.CallType = trace.Action.CallType
+		tx.From, tx.To, tx.Value = trace.Action.From, trace.Action.To, trace.Action.Value
+	case "create":
+		tx.CallType = "create"
+		tx.From, tx.Value = trace.Action.From, trace.Action.Value
+		if trace.Result != nil {
+			tx.To = trace.Result.Address
+		}
+	case "suicide":
+		tx.CallType = "selfdestruct"
+		tx.From, tx.To, tx.Value = trace.Action.Address, trace.Action.RefundAddress, trace.Action.Balance
+	default:
+		return InternalTransaction{}, false
+	}
+	if !movesValue(tx.CallType, tx.Value) {
+		return InternalTransaction{}, false
+	}
+	tx.From, tx.To = strings.ToLower(tx.From), strings.ToLower(tx.To)
+	return tx, true
+}
+
+// Returns the internal value transfers in the block that involve a subscribed address.
+// Traces the block with debug_traceBlockByNumber, falling back to trace_block if the node
+// does not support it. Returns nil without calling the node if tracing is disabled.
+func (b *Scanner) fetchInternalTxs(ctx context.Context, blockNumber int, block ethclient.Block) ([]InternalTransaction, error) {
+	if !b.tracing {
+		return nil, nil
+	}
+
+	var internalTxs []InternalTransaction
+	if !b.debugTraceUnsupported {
+		traces, err := b.ethClient.TraceBlockByNumber(ctx, blockNumber)
+		if err == nil {
+			if internalTxs, err = flattenCallTraces(block, traces); err != nil {
+				return nil, err
+			}
+			return b.filterInternalTxs(internalTxs), nil
+		}
+		if !errors.Is(err, ethclient.ErrMethodNotFound) {
+			return nil, err
+		}
+		b.logger.Printf("node does not support %s, tracing with %s", ethclient.TraceBlockByNumberMethod, ethclient.TraceBlockMethod)
+		b.debugTraceUnsupported = true
+	}
+
+	traces, err := b.ethClient.TraceBlock(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	if internalTxs, err = flattenTraces(block, traces); err != nil {
+		return nil, err
+	}
+	return b.filterInternalTxs(internalTxs), nil
+}
+
+func (b *Scanner) filterInternalTxs(txs []InternalTransaction) []InternalTransaction {
+	result := make([]InternalTransaction, 0)
+	for _, tx := range txs {
+		if b.isSubscribed(tx.From) || b.isSubscribed(tx.To) {
+			result = append(result, tx)
+		}
+	}
+	return result
+}
+
+// Saves internal transactions under each subscribed counterparty
+func saveInternalTxs(batch datastore.Batch, txs []InternalTransaction) error {
+	return saveToCounterparties(batch, internalKey, txs, func(tx InternalTransaction) (string, string) {
+		return tx.From, tx.To
+	})
+}
+
+func removeInternalTxs(
+	batch datastore.Batch,
+	addresses []string,
+	remove func(tx InternalTransaction) bool,
+) error {
+	return removeRecords(batch, internalKey, addresses, remove)
+}
+
+// Returns the internal value transfers to or from the address, with their addresses in
+// their EIP-55 checksum encoding
+func (p *Parser) GetInternalTransactions(address string) []InternalTransaction {
+	address, err := ethclient.NormalizeAddress(address)
+	if err != nil {
+		p.logger.Printf("failed to get internal transactions: %v", err)
+		return nil
+	}
+
+	txs, err := getRecords[InternalTransaction](p.db, internalKey(address))
+	if err != nil {
+		p.logger.Printf("failed to get internal transactions for address %s: %v", address, err)
+		return nil
+	}
+	for i := range txs {
+		txs[i].From = ethclient.ChecksumAddress(txs[i].From)
+		txs[i].To = ethclient.ChecksumAddress(txs[i].To)
+	}
+	return txs
+}
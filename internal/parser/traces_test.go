@@ -0,0 +1,83 @@
+package parser_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"slices"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+func TestInternalTransactions(t *testing.T) {
+	const (
+		user     = "0x00000000000000000000000000000000000005e1"
+		multisig = "0x000000000000000000000000000000000000ca5e"
+		wallet   = "0x000000000000000000000000000000000000f00d"
+	)
+
+	for _, debugTrace := range []bool{true, false} {
+		name := "DebugTraceBlockByNumber"
+		if !debugTrace {
+			name = "TraceBlock"
+		}
+		t.Run(name, func(t *testing.T) {
+			node := newFakeNode(t, 10)
+			node.noDebugTrace = !debugTrace
+			node.calls[testHash(1).Hex()] = ethclient.CallFrame{
+				Type: "CALL", From: user, To: multisig, Value: "0x0",
+				Calls: []ethclient.CallFrame{
+					// delegate calls report the caller's value without moving it
+					{Type: "DELEGATECALL", From: multisig, To: wallet, Value: "0x5", Calls: []ethclient.CallFrame{
+						{Type: "CALL", From: multisig, To: alice, Value: "0x64"},
+					}},
+					// reverted payouts moved nothing
+					{Type: "CALL", From: multisig, To: wallet, Value: "0x1", Error: "execution reverted", Calls: []ethclient.CallFrame{
+						{Type: "CALL", From: wallet, To: alice, Value: "0x1"},
+					}},
+					{Type: "STATICCALL", From: multisig, To: alice, Value: "0x0"},
+					{Type: "SELFDESTRUCT", From: wallet, To: alice, Value: "0x20"},
+				},
+			}
+			// a transaction whose top-level call reverted
+			node.calls[testHash(2).Hex()] = ethclient.CallFrame{
+				Type: "CALL", From: user, To: multisig, Error: "execution reverted",
+				Calls: []ethclient.CallFrame{{Type: "CALL", From: multisig, To: alice, Value: "0x7"}},
+			}
+
+			p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithInternalTransactions(true))
+			p.Subscribe(alice)
+
+			node.mine(
+				newTx(1, user, multisig, 0),
+				newTx(2, user, multisig, 0),
+			)
+			if err := p.ScanAll(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			txs := p.GetInternalTransactions(alice)
+			if len(txs) != 2 {
+				t.Fatalf("expected 2 internal transactions, got %d: %+v", len(txs), txs)
+			}
+
+			payout := txs[0]
+			if payout.TransactionHash != testHash(1).Hex() || payout.From != ethclient.ChecksumAddress(multisig) || payout.To != ethclient.ChecksumAddress(alice) ||
+				payout.Value != "0x64" || payout.CallType != "call" || payout.Depth != 2 ||
+				!slices.Equal(payout.TraceAddress, []int{0, 0}) {
+				t.Errorf("unexpected payout: %+v", payout)
+			}
+			selfdestruct := txs[1]
+			if selfdestruct.CallType != "selfdestruct" || selfdestruct.From != ethclient.ChecksumAddress(wallet) ||
+				selfdestruct.Value != "0x20" || selfdestruct.Depth != 1 {
+				t.Errorf("unexpected selfdestruct: %+v", selfdestruct)
+			}
+
+			if txs := p.GetInternalTransactions(user); len(txs) != 0 {
+				t.Errorf("expected no internal transactions for unsubscribed address, got %d", len(txs))
+			}
+		})
+	}
+}
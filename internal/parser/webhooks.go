@@ -0,0 +1,561 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Timeout of a single webhook delivery attempt
+const webhookTimeout = 10 * time.Second
+
+var errInvalidWebhook = errors.New("invalid webhook")
+
+// Headers sent with every webhook delivery. The signature is only sent if a webhook secret is set.
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// Type of the webhook event sent for a newly matched transaction
+const WebhookTransactionEvent = "transaction"
+
+// WebhookRetryPolicy controls how failed webhook deliveries are retried. A delivery fails
+// if the webhook cannot be reached or responds with a status other than 2xx; it is then
+// retried with exponential backoff until it runs out of attempts and is dead-lettered.
+type WebhookRetryPolicy struct {
+	// Maximum number of attempts per delivery, including the first
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var DefaultWebhookRetryPolicy = WebhookRetryPolicy{
+	MaxAttempts:    10,
+	InitialBackoff: 5 * time.Second,
+	MaxBackoff:     time.Hour,
+}
+
+// Returns how long to wait before the next attempt, after the given number of failed attempts
+func (p WebhookRetryPolicy) backoff(attempts int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempts && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return max(min(backoff, p.MaxBackoff), 0)
+}
+
+// WebhookEvent is the body POSTed to the webhook of a subscription
+type WebhookEvent struct {
+	// same as the delivery ID, for receivers to recognise a delivery sent again
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// subscribed address the event is for
+	Address     string      `json:"address"`
+	Transaction Transaction `json:"transaction"`
+}
+
+// WebhookDelivery is a webhook event waiting in the outbox or, after running out of
+// attempts, in the dead-letter list
+type WebhookDelivery struct {
+	ID              string `json:"id"`
+	Address         string `json:"address"`
+	URL             string `json:"url"`
+	TransactionHash string `json:"transactionHash"`
+	BlockHash       string `json:"blockHash"`
+	// encoded WebhookEvent, sent as is on every attempt
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"lastError,omitempty"`
+	NextAttemptAt time.Time       `json:"nextAttemptAt"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
+}
+
+// Returns the ID of the delivery of a transaction to an address. The ID is the same
+// whenever the block is processed, but differs for the transaction included in another block.
+func webhookDeliveryID(blockHash, txHash, address string) string {
+	sum := sha256.Sum256([]byte(blockHash + "/" + txHash + "/" + address))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Reports whether the address is loopback, private, link-local or unspecified, which
+// webhooks may not target unless private webhooks are allowed
+func isPrivateAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast()
+}
+
+// Checks that the webhook is an http or https URL and, unless private webhooks are allowed,
+// that it does not name a loopback, private or link-local host. Host names are checked again
+// against the addresses they resolve to whenever a delivery connects.
+func (b *Scanner) ValidateWebhook(webhook string) error {
+	u, err := url.Parse(webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: %s is not an http or https URL", errInvalidWebhook, webhook)
+	}
+	if b.privateWebhooks {
+		return nil
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s is a loopback host", errInvalidWebhook, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && isPrivateAddr(addr) {
+		return fmt.Errorf("%w: %s is a private address", errInvalidWebhook, host)
+	}
+	return nil
+}
+
+// Returns the client webhooks are delivered with. Unless private webhooks are allowed, it
+// refuses to connect to loopback, private and link-local addresses, whichever host name
+// resolved to them, and connects directly rather than through a proxy for the check to apply.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivate {
+		dialer := &net.Dialer{
+			Timeout:   webhookTimeout,
+			KeepAlive: 30 * time.Second,
+			Control: func(network, address string, c syscall.RawConn) error {
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if isPrivateAddr(addrPort.Addr()) {
+					return fmt.Errorf("%w: %s is a private address", errInvalidWebhook, addrPort.Addr())
+				}
+				return nil
+			},
+		}
+		transport.Proxy = nil
+		transport.DialContext = dialer.DialContext
+	}
+	return &http.Client{Transport: transport}
+}
+
+// Returns the hex HMAC-SHA256 of the timestamp and body, joined by a dot, keyed with the secret
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Queues a delivery of each transaction to the webhooks of its sender's and recipient's
+// subscriptions. Returns the number of deliveries queued.
+func (b *Scanner) queueWebhooks(batch datastore.Batch, txs []indexedTx, receipts []ethclient.Receipt) (int, error) {
+	receiptsByHash := make(map[string]*ethclient.Receipt, len(receipts))
+	for i := range receipts {
+		receiptsByHash[receipts[i].TransactionHash] = &receipts[i]
+	}
+
+	now := time.Now().UTC()
+	queued := 0
+	for _, tx := range txs {
+		addresses := []string{strings.ToLower(tx.From.Hex())}
+		if to := strings.ToLower(recipient(tx.Transaction)); to != "" && to != addresses[0] {
+			addresses = append(addresses, to)
+		}
+
+		for _, address := range addresses {
+			v, err := batch.Get(subKey(address))
+			if errors.Is(err, datastore.KeyDoesNotExist) {
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			subscription, err := decodeSubscription(v)
+			if err != nil {
+				return 0, err
+			}
+			if subscription.Webhook == "" {
+				continue
+			}
+
+			id := webhookDeliveryID(tx.BlockHash.Hex(), tx.Hash.Hex(), address)
+			payload, err := json.Marshal(WebhookEvent{
+				ID:          id,
+				Type:        WebhookTransactionEvent,
+				Address:     ethclient.ChecksumAddress(address),
+				Transaction: attachReceipt(b.withStatus(tx), receiptsByHash[tx.Hash.Hex()]),
+			})
+			if err != nil {
+				return 0, err
+			}
+			if err := putDelivery(batch, outboxKey(id), WebhookDelivery{
+				ID:              id,
+				Address:         address,
+				URL:             subscription.Webhook,
+				TransactionHash: tx.Hash.Hex(),
+				BlockHash:       tx.BlockHash.Hex(),
+				Payload:         payload,
+				NextAttemptAt:   now,
+				CreatedAt:       now,
+				UpdatedAt:       now,
+			}); err != nil {
+				return 0, err
+			}
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+// Signals the webhook worker that deliveries were queued
+func (b *Scanner) wakeWebhooks() {
+	select {
+	case b.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// Delivers webhooks until ctx is done, waking up when deliveries are queued or a retry is due
+func (b *Scanner) runWebhooks(ctx context.Context) {
+	for {
+		next, _, err := b.deliverWebhooks(ctx)
+		if err != nil && ctx.Err() == nil {
+			b.logger.Printf("error delivering webhooks: %v", err)
+		}
+
+		var retry <-chan time.Time
+		var timer *time.Timer
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			retry = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-b.webhookWake:
+		case <-retry:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Sends the webhook deliveries in the outbox that are due, including deliveries left
+// by a restart, and waits for them. Deliveries of addresses unsubscribed in the meantime are dropped.
+func (b *Scanner) DeliverWebhooks(ctx context.Context) error {
+	_, senders, err := b.deliverWebhooks(ctx)
+	senders.Wait()
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// Starts sending the deliveries that are due, with one sender per webhook URL so that a slow
+// or unreachable webhook does not hold up the others. A URL whose sender from an earlier call
+// is still running is skipped; the sender wakes the webhook worker once it is done. Returns
+// when the next remaining delivery is due, or the zero time if there is none, and the senders.
+func (b *Scanner) deliverWebhooks(ctx context.Context) (time.Time, *sync.WaitGroup, error) {
+	var senders sync.WaitGroup
+	deliveries, err := listDeliveries(b.db, outboxKeyPrefix)
+	if err != nil {
+		return time.Time{}, &senders, err
+	}
+
+	var next time.Time
+	var urls []string
+	due := make(map[string][]WebhookDelivery)
+	for _, delivery := range deliveries {
+		if delivery.NextAttemptAt.After(time.Now()) {
+			if next.IsZero() || delivery.NextAttemptAt.Before(next) {
+				next = delivery.NextAttemptAt
+			}
+			continue
+		}
+
+		if !b.isSubscribed(delivery.Address) {
+			if err := b.db.Delete(outboxKey(delivery.ID)); err != nil {
+				return time.Time{}, &senders, err
+			}
+			continue
+		}
+
+		if _, ok := due[delivery.URL]; !ok {
+			urls = append(urls, delivery.URL)
+		}
+		due[delivery.URL] = append(due[delivery.URL], delivery)
+	}
+
+	for _, url := range urls {
+		if !b.startSending(url) {
+			continue
+		}
+		senders.Add(1)
+		go func(url string, deliveries []WebhookDelivery) {
+			defer senders.Done()
+			defer b.stopSending(url)
+			b.sendDeliveries(ctx, deliveries)
+		}(url, due[url])
+	}
+	return next, &senders, nil
+}
+
+// Marks the webhook URL as being sent to, returning false if it already is
+func (b *Scanner) startSending(url string) bool {
+	b.webhookMutex.Lock()
+	defer b.webhookMutex.Unlock()
+	if b.webhookSending[url] {
+		return false
+	}
+	b.webhookSending[url] = true
+	return true
+}
+
+// Marks the webhook URL as no longer being sent to and wakes the webhook worker to pick up
+// its remaining deliveries and retries
+func (b *Scanner) stopSending(url string) {
+	b.webhookMutex.Lock()
+	delete(b.webhookSending, url)
+	b.webhookMutex.Unlock()
+	b.wakeWebhooks()
+}
+
+// Sends the deliveries to their webhook one after another, recording each attempt
+func (b *Scanner) sendDeliveries(ctx context.Context, deliveries []WebhookDelivery) {
+	for _, delivery := range deliveries {
+		sendErr := b.sendWebhook(ctx, delivery)
+		if sendErr != nil && ctx.Err() != nil {
+			// the attempt was interrupted rather than failed
+			return
+		}
+		if err := b.recordAttempt(delivery, sendErr); err != nil {
+			b.logger.Printf("error recording webhook delivery %s: %v", delivery.ID, err)
+			return
+		}
+	}
+}
+
+// POSTs the delivery's payload to its webhook, giving up after webhookTimeout
+func (b *Scanner) sendWebhook(ctx context.Context, delivery WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, delivery.ID)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	if b.webhookSecret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(b.webhookSecret, timestamp, delivery.Payload))
+	}
+
+	resp, err := b.webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Removes a sent delivery from the outbox, or records a failed attempt and either schedules
+// a retry or moves the delivery to the dead-letter list.
+func (b *Scanner) recordAttempt(delivery WebhookDelivery, sendErr error) error {
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.UpdatedAt = now
+
+	return b.db.Batch(func(batch datastore.Batch) error {
+		if !batch.Has(outboxKey(delivery.ID)) {
+			// removed by a reorg in the meantime
+			return nil
+		}
+		if sendErr == nil {
+			return batch.Delete(outboxKey(delivery.ID))
+		}
+
+		delivery.LastError = sendErr.Error()
+		if delivery.Attempts >= b.webhookRetry.MaxAttempts {
+			b.logger.Printf("webhook delivery %s to %s failed %d times, dead-lettering it: %v",
+				delivery.ID, delivery.URL, delivery.Attempts, sendErr)
+			if err := batch.Delete(outboxKey(delivery.ID)); err != nil {
+				return err
+			}
+			return putDelivery(batch, deadLetterKey(delivery.ID), delivery)
+		}
+
+		delivery.NextAttemptAt = now.Add(b.webhookRetry.backoff(delivery.Attempts))
+		return putDelivery(batch, outboxKey(delivery.ID), delivery)
+	})
+}
+
+// Removes the deliveries stored under the prefix, in the outbox or the dead-letter list,
+// matching the given predicate
+func removeDeliveries(batch datastore.Batch, prefix string, ids []string, remove func(d WebhookDelivery) bool) error {
+	for _, id := range ids {
+		delivery, err := getDelivery(batch, prefix+id)
+		if errors.Is(err, datastore.KeyDoesNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !remove(delivery) {
+			continue
+		}
+		if err := batch.Delete(prefix + id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the deliveries that ran out of attempts, oldest first
+func (p *Parser) ListDeadLetters() ([]WebhookDelivery, error) {
+	deliveries, err := listDeliveries(p.db, deadLetterKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for i := range deliveries {
+		deliveries[i].Address = ethclient.ChecksumAddress(deliveries[i].Address)
+	}
+	return deliveries, nil
+}
+
+// Returns the dead-lettered delivery with the given ID, or false if there is none
+func (p *Parser) GetDeadLetter(id string) (WebhookDelivery, bool) {
+	delivery, err := getDelivery(p.db, deadLetterKey(id))
+	if err != nil {
+		if !errors.Is(err, datastore.KeyDoesNotExist) {
+			p.logger.Printf("failed to get dead letter %s: %v", id, err)
+		}
+		return WebhookDelivery{}, false
+	}
+	delivery.Address = ethclient.ChecksumAddress(delivery.Address)
+	return delivery, true
+}
+
+// Moves the dead-lettered delivery back to the outbox to be sent right away, with a fresh
+// set of attempts. Returns false if there is no such delivery.
+func (p *Parser) ReplayDeadLetter(id string) bool {
+	err := p.db.Batch(func(batch datastore.Batch) error {
+		delivery, err := getDelivery(batch, deadLetterKey(id))
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		delivery.Attempts = 0
+		delivery.NextAttemptAt = now
+		delivery.UpdatedAt = now
+		if err := batch.Delete(deadLetterKey(id)); err != nil {
+			return err
+		}
+		return putDelivery(batch, outboxKey(id), delivery)
+	})
+	if err != nil {
+		if !errors.Is(err, datastore.KeyDoesNotExist) {
+			p.logger.Printf("failed to replay dead letter %s: %v", id, err)
+		}
+		return false
+	}
+	p.wakeWebhooks()
+	return true
+}
+
+// Discards the dead-lettered delivery. Returns false if there is no such delivery.
+func (p *Parser) DeleteDeadLetter(id string) bool {
+	if !p.db.Has(deadLetterKey(id)) {
+		return false
+	}
+	if err := p.db.Delete(deadLetterKey(id)); err != nil {
+		p.logger.Printf("failed to delete dead letter %s: %v", id, err)
+		return false
+	}
+	return true
+}
+
+// Returns the deliveries stored under the prefix, oldest first
+func listDeliveries(db datastore.DataStore, prefix string) ([]WebhookDelivery, error) {
+	ids, err := listKeys(db, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]WebhookDelivery, 0, len(ids))
+	for _, id := range ids {
+		delivery, err := getDelivery(db, prefix+id)
+		if errors.Is(err, datastore.KeyDoesNotExist) {
+			// sent or removed in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID < deliveries[j].ID
+	})
+	return deliveries, nil
+}
+
+// Reads a delivery from the datastore or a batch
+func getDelivery(db interface {
+	Get(key string) ([][]byte, error)
+}, key string) (WebhookDelivery, error) {
+	v, err := db.Get(key)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	if len(v) == 0 {
+		return WebhookDelivery{}, errors.New("empty webhook delivery record")
+	}
+
+	var delivery WebhookDelivery
+	if err := json.Unmarshal(v[0], &delivery); err != nil {
+		return WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+func putDelivery(batch datastore.Batch, key string, delivery WebhookDelivery) error {
+	b, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return batch.Put(key, [][]byte{b})
+}
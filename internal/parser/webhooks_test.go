@@ -0,0 +1,380 @@
+package parser_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore/memorydb"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+// webhookReceiver records the webhook deliveries POSTed to it and answers with its status
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+	server   *httptest.Server
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	r := &webhookReceiver{status: http.StatusOK}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *webhookReceiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+// Fields of a received webhook event that the tests check
+type webhookEvent struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	Address     string `json:"address"`
+	Transaction struct {
+		Hash        string `json:"hash"`
+		BlockNumber string `json:"blockNumber"`
+	} `json:"transaction"`
+}
+
+// Returns the events received so far
+func (r *webhookReceiver) events(t *testing.T) []webhookEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := make([]webhookEvent, 0, len(r.bodies))
+	for _, body := range r.bodies {
+		var event webhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	const (
+		bob    = "0x0000000000000000000000000000000000000b0b"
+		secret = "s3cret"
+	)
+
+	receiver := newWebhookReceiver(t)
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10,
+		parser.WithWebhookSecret(secret),
+		parser.WithPrivateWebhooks(true),
+	)
+	p.Subscribe(alice, parser.WithWebhook(receiver.server.URL))
+	p.Subscribe(bob)
+
+	node.mine(newTx(1, alice, bob, 0), newTx(2, carol, usdc, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.DeliverWebhooks(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	events := receiver.events(t)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 delivery to alice's webhook, got %d", len(events))
+	}
+	event := events[0]
+	if event.Type != parser.WebhookTransactionEvent || event.Address != ethclient.ChecksumAddress(alice) ||
+		event.Transaction.Hash != testHash(1).Hex() || event.ID == "" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	req, body := receiver.requests[0], receiver.bodies[0]
+	if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" ||
+		req.Header.Get(parser.WebhookIDHeader) != event.ID {
+		t.Errorf("unexpected request: %s %v", req.Method, req.Header)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(req.Header.Get(parser.WebhookTimestampHeader) + "."))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); req.Header.Get(parser.WebhookSignatureHeader) != want {
+		t.Errorf("expected signature %s, got %s", want, req.Header.Get(parser.WebhookSignatureHeader))
+	}
+
+	// a sent delivery leaves the outbox
+	if err := p.DeliverWebhooks(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if events := receiver.events(t); len(events) != 1 {
+		t.Fatalf("expected the delivery to be sent once, got %d", len(events))
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	receiver.setStatus(http.StatusInternalServerError)
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10,
+		parser.WithPrivateWebhooks(true),
+		parser.WithWebhookRetryPolicy(parser.WebhookRetryPolicy{MaxAttempts: 2}),
+	)
+	p.Subscribe(alice, parser.WithWebhook(receiver.server.URL))
+
+	node.mine(newTx(1, carol, alice, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := p.DeliverWebhooks(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if events := receiver.events(t); len(events) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(events))
+	}
+
+	deadLetters, err := p.ListDeadLetters()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deadLetters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %+v", deadLetters)
+	}
+	dead := deadLetters[0]
+	if dead.Attempts != 2 || !strings.Contains(dead.LastError, "500") ||
+		dead.Address != ethclient.ChecksumAddress(alice) || dead.TransactionHash != testHash(1).Hex() {
+		t.Errorf("unexpected dead letter: %+v", dead)
+	}
+	if got, ok := p.GetDeadLetter(dead.ID); !ok || got.ID != dead.ID {
+		t.Errorf("expected to get dead letter %s, got %+v", dead.ID, got)
+	}
+
+	// replaying sends the same payload again once the webhook recovers
+	receiver.setStatus(http.StatusNoContent)
+	if !p.ReplayDeadLetter(dead.ID) {
+		t.Fatal("expected replay to succeed")
+	}
+	if err := p.DeliverWebhooks(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events := receiver.events(t)
+	if len(events) != 3 || events[2].ID != dead.ID || string(receiver.bodies[2]) != string(dead.Payload) {
+		t.Fatalf("expected the dead letter to be delivered again, got %+v", events)
+	}
+	if deadLetters, _ := p.ListDeadLetters(); len(deadLetters) != 0 {
+		t.Errorf("expected no dead letters after replay, got %+v", deadLetters)
+	}
+	if p.ReplayDeadLetter(dead.ID) || p.DeleteDeadLetter(dead.ID) {
+		t.Error("expected a replayed dead letter to be gone")
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	receiver.setStatus(http.StatusServiceUnavailable)
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10,
+		parser.WithPrivateWebhooks(true),
+		parser.WithWebhookRetryPolicy(parser.WebhookRetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}),
+	)
+	p.Subscribe(alice, parser.WithWebhook(receiver.server.URL))
+
+	node.mine(newTx(1, carol, alice, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := p.DeliverWebhooks(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the retry is not due yet
+	if events := receiver.events(t); len(events) != 1 {
+		t.Fatalf("expected 1 attempt before the backoff elapsed, got %d", len(events))
+	}
+	if deadLetters, _ := p.ListDeadLetters(); len(deadLetters) != 0 {
+		t.Fatalf("expected the delivery to stay in the outbox, got %+v", deadLetters)
+	}
+}
+
+func TestReorgRemovesWebhooks(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithPrivateWebhooks(true))
+	p.Subscribe(alice, parser.WithWebhook(receiver.server.URL))
+
+	node.mine(newTx(1, carol, alice, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the transaction moves to block 12 before its delivery is sent
+	node.fork(10)
+	node.mine()
+	node.mine(newTx(1, carol, alice, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.DeliverWebhooks(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	events := receiver.events(t)
+	if len(events) != 1 || events[0].Transaction.BlockNumber != "0xc" {
+		t.Fatalf("expected only the delivery from the new branch, got %+v", events)
+	}
+}
+
+func TestUnsubscribeDropsWebhooks(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithPrivateWebhooks(true))
+	p.Subscribe(alice, parser.WithWebhook(receiver.server.URL))
+
+	node.mine(newTx(1, carol, alice, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p.Unsubscribe(alice)
+	if err := p.DeliverWebhooks(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if events := receiver.events(t); len(events) != 0 {
+		t.Fatalf("expected no deliveries after unsubscribing, got %d", len(events))
+	}
+}
+
+func TestWebhookTargets(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+
+	for _, webhook := range []string{
+		"ftp://example.com/hook",
+		"http://localhost:8080/hook",
+		"http://127.0.0.1:8080/hook",
+		"http://10.0.0.1/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		if err := p.ValidateWebhook(webhook); err == nil {
+			t.Errorf("expected webhook %s to be rejected", webhook)
+		}
+	}
+	if err := p.ValidateWebhook("https://example.com/hook"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if p.Subscribe(alice, parser.WithWebhook("http://169.254.169.254/latest/meta-data")) {
+		t.Fatal("expected a subscription with a private webhook to be rejected")
+	}
+	if _, ok := p.GetSubscription(alice); ok {
+		t.Fatal("expected no subscription")
+	}
+
+	allowed := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithPrivateWebhooks(true))
+	if err := allowed.ValidateWebhook("http://127.0.0.1:8080/hook"); err != nil {
+		t.Errorf("expected private webhooks to be allowed, got %v", err)
+	}
+}
+
+func TestWebhookPrivateAddressRefused(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	node := newFakeNode(t, 10)
+	db := memorydb.New()
+
+	// subscribed while private webhooks were allowed, as a host name resolving to a
+	// private address would be
+	allowed := parser.New(log.New(io.Discard, "", 0), node.URL(), 10,
+		parser.WithDataStore(db),
+		parser.WithPrivateWebhooks(true),
+	)
+	allowed.Subscribe(alice, parser.WithWebhook(receiver.server.URL))
+	node.mine(newTx(1, carol, alice, 0))
+	if err := allowed.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10,
+		parser.WithDataStore(db),
+		parser.WithWebhookRetryPolicy(parser.WebhookRetryPolicy{MaxAttempts: 1}),
+	)
+	if err := p.DeliverWebhooks(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if events := receiver.events(t); len(events) != 0 {
+		t.Fatalf("expected no request to a private address, got %d", len(events))
+	}
+	deadLetters, _ := p.ListDeadLetters()
+	if len(deadLetters) != 1 || !strings.Contains(deadLetters[0].LastError, "private address") {
+		t.Fatalf("expected the delivery to fail, got %+v", deadLetters)
+	}
+}
+
+func TestWebhookSlowEndpoint(t *testing.T) {
+	const bob = "0x0000000000000000000000000000000000000b0b"
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	fast := newWebhookReceiver(t)
+
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10, parser.WithPrivateWebhooks(true))
+	p.Subscribe(alice, parser.WithWebhook(slow.URL))
+	p.Subscribe(bob, parser.WithWebhook(fast.server.URL))
+
+	// alice's delivery is queued first
+	node.mine(newTx(1, carol, alice, 0))
+	node.mine(newTx(2, carol, bob, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.DeliverWebhooks(context.Background())
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(fast.events(t)) == 0 {
+		if time.Now().After(deadline) {
+			t.Error("expected the fast webhook to be delivered while the slow one is pending")
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/zihaolam/ethereum-parser/internal/datastore"
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+)
+
+// Withdrawal is a transfer of staked ether from the beacon chain, credited to an
+// address by a block rather than by a transaction
+type Withdrawal struct {
+	Index          string `json:"index"`
+	ValidatorIndex string `json:"validatorIndex"`
+	Address        string `json:"address"`
+	// amount in gwei, as the beacon chain accounts it
+	Amount string `json:"amount"`
+	// amount in wei, like the value of a transaction
+	Value          string `json:"value"`
+	BlockNumber    string `json:"blockNumber"`
+	BlockHash      string `json:"blockHash"`
+	BlockTimestamp string `json:"blockTimestamp"`
+}
+
+var gwei = big.NewInt(1e9)
+
+// Returns the withdrawals of the block, none before Shanghai
+func blockWithdrawals(block ethclient.Block) []Withdrawal {
+	result := make([]Withdrawal, 0, len(block.Withdrawals))
+	for _, w := range block.Withdrawals {
+		var value string
+		if amount := w.Amount.Big(); amount != nil {
+			value = ethclient.NewQuantity(amount.Mul(amount, gwei)).String()
+		}
+		result = append(result, Withdrawal{
+			Index:          w.Index.String(),
+			ValidatorIndex: w.ValidatorIndex.String(),
+			Address:        strings.ToLower(w.Address.Hex()),
+			Amount:         w.Amount.String(),
+			Value:          value,
+			BlockNumber:    block.Number.String(),
+			BlockHash:      block.Hash.Hex(),
+			BlockTimestamp: block.Timestamp.String(),
+		})
+	}
+	return result
+}
+
+func (b *Scanner) filterWithdrawals(withdrawals []Withdrawal) []Withdrawal {
+	result := make([]Withdrawal, 0)
+	for _, w := range withdrawals {
+		if b.isSubscribed(w.Address) {
+			result = append(result, w)
+		}
+	}
+	return result
+}
+
+// Saves withdrawals under their subscribed recipients
+func saveWithdrawals(batch datastore.Batch, withdrawals []Withdrawal) error {
+	return saveToCounterparties(batch, withdrawalKey, withdrawals, func(w Withdrawal) (string, string) {
+		return w.Address, w.Address
+	})
+}
+
+func removeWithdrawals(
+	batch datastore.Batch,
+	addresses []string,
+	remove func(w Withdrawal) bool,
+) error {
+	return removeRecords(batch, withdrawalKey, addresses, remove)
+}
+
+// Returns the beacon chain withdrawals credited to the address, which is given in its
+// EIP-55 checksum encoding
+func (p *Parser) GetWithdrawals(address string) []Withdrawal {
+	address, err := ethclient.NormalizeAddress(address)
+	if err != nil {
+		p.logger.Printf("failed to get withdrawals: %v", err)
+		return nil
+	}
+
+	withdrawals, err := getRecords[Withdrawal](p.db, withdrawalKey(address))
+	if err != nil {
+		p.logger.Printf("failed to get withdrawals for address %s: %v", address, err)
+		return nil
+	}
+	for i := range withdrawals {
+		withdrawals[i].Address = ethclient.ChecksumAddress(withdrawals[i].Address)
+	}
+	return withdrawals
+}
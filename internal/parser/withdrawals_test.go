@@ -0,0 +1,120 @@
+package parser_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/zihaolam/ethereum-parser/internal/ethclient"
+	"github.com/zihaolam/ethereum-parser/internal/parser"
+)
+
+// Returns withdrawal number index of amount gwei to the address
+func newWithdrawal(index int, address string, amount int) ethclient.Withdrawal {
+	return ethclient.Withdrawal{
+		Index:          ethclient.IntQuantity(index),
+		ValidatorIndex: ethclient.IntQuantity(1000 + index),
+		Address:        mustAddress(address),
+		Amount:         ethclient.IntQuantity(amount),
+	}
+}
+
+func TestWithdrawals(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+	p.Subscribe(alice)
+
+	node.withdraw(newWithdrawal(1, alice, 17000000), newWithdrawal(2, carol, 5))
+	node.mine()
+	node.withdraw(newWithdrawal(3, alice, 1))
+	node.mine(newTx(1, alice, usdc, 0))
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	withdrawals := p.GetWithdrawals(alice)
+	if len(withdrawals) != 2 {
+		t.Fatalf("expected 2 withdrawals, got %+v", withdrawals)
+	}
+	want := parser.Withdrawal{
+		Index:          "0x1",
+		ValidatorIndex: "0x3e9",
+		Address:        ethclient.ChecksumAddress(alice),
+		Amount:         "0x1036640",
+		Value:          "0x3c6568f12e8000",
+		BlockNumber:    "0xb",
+		BlockHash:      blockHash(11).Hex(),
+		BlockTimestamp: ethclient.IntQuantity(int(blockTime(11).Unix())).String(),
+	}
+	if withdrawals[0] != want {
+		t.Errorf("expected %+v, got %+v", want, withdrawals[0])
+	}
+	if withdrawals[1].Index != "0x3" || withdrawals[1].BlockNumber != "0xc" {
+		t.Errorf("expected withdrawal 0x3 in block 0xc, got %+v", withdrawals[1])
+	}
+	if got := p.GetWithdrawals(carol); len(got) != 0 {
+		t.Errorf("expected no withdrawals for an address not subscribed, got %+v", got)
+	}
+
+	// withdrawals are part of the address's indexed history
+	p.Unsubscribe(alice)
+	p.Subscribe(alice)
+	if got := p.GetWithdrawals(alice); len(got) != 0 {
+		t.Errorf("expected withdrawals to be removed with the subscription, got %+v", got)
+	}
+}
+
+func TestReorgRemovesWithdrawals(t *testing.T) {
+	node := newFakeNode(t, 10)
+	p := parser.New(log.New(io.Discard, "", 0), node.URL(), 10)
+	p.Subscribe(alice)
+
+	node.withdraw(newWithdrawal(1, alice, 1))
+	node.mine()
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the new branch pays the withdrawal one block later
+	node.fork(10)
+	node.mine()
+	node.withdraw(newWithdrawal(1, alice, 1))
+	node.mine()
+	if err := p.ScanAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	withdrawals := p.GetWithdrawals(alice)
+	if len(withdrawals) != 1 || withdrawals[0].BlockNumber != "0xc" {
+		t.Fatalf("expected the withdrawal from the new branch only, got %+v", withdrawals)
+	}
+}
+
+func TestBackfillWithdrawals(t *testing.T) {
+	for _, traceFilter := range []bool{true, false} {
+		name := "TraceFilter"
+		if !traceFilter {
+			name = "BlockScan"
+		}
+		t.Run(name, func(t *testing.T) {
+			node := newFakeNode(t, 0)
+			node.noTraceFilter = !traceFilter
+			node.withdraw(newWithdrawal(1, alice, 1))
+			node.mine()
+			node.withdraw(newWithdrawal(2, alice, 2), newWithdrawal(3, carol, 3))
+			node.mine()
+
+			p := parser.New(log.New(io.Discard, "", 0), node.URL(), 2)
+			p.Subscribe(alice, parser.WithFromBlock(2))
+			if err := p.Backfill(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			withdrawals := p.GetWithdrawals(alice)
+			if len(withdrawals) != 1 || withdrawals[0].Index != "0x2" {
+				t.Fatalf("expected withdrawal 0x2 from block 2, got %+v", withdrawals)
+			}
+		})
+	}
+}
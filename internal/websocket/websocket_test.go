@@ -0,0 +1,148 @@
+package websocket_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zihaolam/ethereum-parser/internal/websocket"
+)
+
+// Starts a server that echoes every message back to the client
+func newEchoServer(t *testing.T) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(message); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestEcho(t *testing.T) {
+	conn, err := websocket.Dial(context.Background(), newEchoServer(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	// covers the 7 bit, 16 bit and 64 bit payload length encodings
+	for _, size := range []int{5, 300, 70000} {
+		message := bytes.Repeat([]byte("x"), size)
+		if err := conn.WriteMessage(message); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(got, message) {
+			t.Fatalf("expected echo of %d bytes, got %d bytes", size, len(got))
+		}
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		conn.WriteMessage([]byte("bye"))
+		conn.Close()
+	}))
+	defer server.Close()
+
+	conn, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	if message, err := conn.ReadMessage(); err != nil || string(message) != "bye" {
+		t.Fatalf("expected message before close, got %q, %v", message, err)
+	}
+	if _, err := conn.ReadMessage(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF after close, got %v", err)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	conn, err := websocket.Dial(context.Background(), newEchoServer(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	conn.KeepAlive(10 * time.Millisecond)
+
+	// the server's pongs keep an idle connection alive past the read timeout
+	time.Sleep(100 * time.Millisecond)
+	if err := conn.WriteMessage([]byte("ping")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if message, err := conn.ReadMessage(); err != nil || string(message) != "ping" {
+		t.Fatalf("expected echo, got %q, %v", message, err)
+	}
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	// a server that stalls after the handshake, answering neither messages nor pings
+	stalled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-stalled
+	}))
+	defer server.Close()
+	defer close(stalled)
+
+	conn, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	conn.KeepAlive(10 * time.Millisecond)
+
+	start := time.Now()
+	_, err = conn.ReadMessage()
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the read to time out after twice the interval, took %v", elapsed)
+	}
+}
+
+func TestBadHandshake(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not a websocket endpoint", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"))
+	if !errors.Is(err, websocket.ErrBadHandshake) {
+		t.Fatalf("expected ErrBadHandshake, got %v", err)
+	}
+}
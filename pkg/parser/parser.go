@@ -6,16 +6,126 @@ import (
 	"github.com/zihaolam/ethereum-parser/internal/parser"
 )
 
+type (
+	Transaction         = parser.Transaction
+	Block               = parser.Block
+	Subscription        = parser.Subscription
+	SubscribeOption     = parser.SubscribeOption
+	TokenTransfer       = parser.TokenTransfer
+	NFTTransfer         = parser.NFTTransfer
+	NFTHolding          = parser.NFTHolding
+	InternalTransaction = parser.InternalTransaction
+	Withdrawal          = parser.Withdrawal
+	BackfillJob         = parser.BackfillJob
+	WebhookEvent        = parser.WebhookEvent
+	WebhookDelivery     = parser.WebhookDelivery
+	WebhookRetryPolicy  = parser.WebhookRetryPolicy
+	TransactionQuery    = parser.TransactionQuery
+	TransactionPage     = parser.TransactionPage
+	Direction           = parser.Direction
+	SortOrder           = parser.SortOrder
+	Option              = parser.Option
+	Quantity            = ethclient.Quantity
+	Hash                = ethclient.Hash
+	Data                = ethclient.Data
+	Address             = ethclient.Address
+	AccessListEntry     = ethclient.AccessListEntry
+	Authorization       = ethclient.Authorization
+)
+
+const (
+	DirectionIn  = parser.DirectionIn
+	DirectionOut = parser.DirectionOut
+	OrderAsc     = parser.OrderAsc
+	OrderDesc    = parser.OrderDesc
+
+	WebhookIDHeader         = parser.WebhookIDHeader
+	WebhookTimestampHeader  = parser.WebhookTimestampHeader
+	WebhookSignatureHeader  = parser.WebhookSignatureHeader
+	WebhookTransactionEvent = parser.WebhookTransactionEvent
+
+	LegacyTxType     = ethclient.LegacyTxType
+	AccessListTxType = ethclient.AccessListTxType
+	DynamicFeeTxType = ethclient.DynamicFeeTxType
+	BlobTxType       = ethclient.BlobTxType
+	SetCodeTxType    = ethclient.SetCodeTxType
+)
+
+var (
+	WithConfirmations        = parser.WithConfirmations
+	WithHeadTag              = parser.WithHeadTag
+	WithDataStore            = parser.WithDataStore
+	WithWorkers              = parser.WithWorkers
+	WithPrefetch             = parser.WithPrefetch
+	WithClientOptions        = parser.WithClientOptions
+	WithWebSocketEndpoint    = parser.WithWebSocketEndpoint
+	WithReceipts             = parser.WithReceipts
+	WithTokenTransfers       = parser.WithTokenTransfers
+	WithNFTTransfers         = parser.WithNFTTransfers
+	WithInternalTransactions = parser.WithInternalTransactions
+	WithLabels               = parser.WithLabels
+	WithFromBlock            = parser.WithFromBlock
+	WithWebhook              = parser.WithWebhook
+	WithWebhookSecret        = parser.WithWebhookSecret
+	WithWebhookRetryPolicy   = parser.WithWebhookRetryPolicy
+	WithPrivateWebhooks      = parser.WithPrivateWebhooks
+
+	DefaultWebhookRetryPolicy = parser.DefaultWebhookRetryPolicy
+
+	ErrInvalidAddress  = ethclient.ErrInvalidAddress
+	ErrInvalidChecksum = ethclient.ErrInvalidChecksum
+)
+
+// Parser indexes the history of subscribed addresses. Addresses are 0x-prefixed 40 digit
+// hex and match regardless of case; mixed-case addresses must carry a valid EIP-55 checksum.
+// Methods given an invalid address log it and return nothing, or an error wrapping
+// ErrInvalidAddress or ErrInvalidChecksum where they return one.
 type Parser interface {
 	// last parsed block
 	GetCurrentBlock() int
 	// add address to observer
-	Subscribe(address string) bool
-	// list of inbound or outbound transactions for an address
-	GetTransactions(address string) []ethclient.Transaction
+	Subscribe(address string, opts ...SubscribeOption) bool
+	// remove address from observer together with its indexed history
+	Unsubscribe(address string) bool
+	// check that a webhook URL is accepted by Subscribe
+	ValidateWebhook(url string) error
+	// get the subscription of an address
+	GetSubscription(address string) (Subscription, bool)
 	// get existing subscriptions
+	ListSubscriptions() ([]Subscription, error)
+	// list of inbound or outbound transactions for an address
+	GetTransactions(address string) []Transaction
+	// page of an address's transactions matching the query's filters
+	GetTransactionsPage(address string, query TransactionQuery) (TransactionPage, error)
+	// list of ERC-20 transfers to or from an address
+	GetTokenTransfers(address string) []TokenTransfer
+	// list of ERC-721 and ERC-1155 transfers to or from an address
+	GetNFTTransfers(address string) []NFTTransfer
+	// NFTs an address currently holds, derived from its transfers
+	GetNFTHoldings(address string) []NFTHolding
+	// list of value transfers made by contract calls to or from an address
+	GetInternalTransactions(address string) []InternalTransaction
+	// list of beacon chain withdrawals to an address
+	GetWithdrawals(address string) []Withdrawal
+	// progress of the history backfill of an address subscribed with a from block
+	GetBackfillJob(address string) (BackfillJob, bool)
+	// backfill jobs of all addresses
+	ListBackfillJobs() ([]BackfillJob, error)
+	// webhook deliveries that ran out of attempts
+	ListDeadLetters() ([]WebhookDelivery, error)
+	// get a dead-lettered webhook delivery
+	GetDeadLetter(id string) (WebhookDelivery, bool)
+	// send a dead-lettered webhook delivery again
+	ReplayDeadLetter(id string) bool
+	// discard a dead-lettered webhook delivery
+	DeleteDeadLetter(id string) bool
 }
 
-func NewParser(logger logging.Logger, ethEndpoint string, initialBlockNumber int) Parser {
-	return parser.New(logger, ethEndpoint, initialBlockNumber)
+func NewParser(
+	logger logging.Logger,
+	ethEndpoint string,
+	initialBlockNumber int,
+	opts ...Option,
+) Parser {
+	return parser.New(logger, ethEndpoint, initialBlockNumber, opts...)
 }